@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/cloudfront"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+const (
+	// Profile of the default behavior, it serves the documents
+	defaultCacheProfile = "html"
+	// CloudFront allows 25 cache behaviors besides the default one
+	maxCacheBehaviors = 25
+)
+
+// cacheProfile bounds how long CloudFront caches the objects of a behavior.
+// Objects carry their own Cache-Control from the cache-control rules, the
+// default TTL applies only to objects without it
+type cacheProfile struct {
+	name       string
+	comment    string
+	minTtl     int
+	defaultTtl int
+	maxTtl     int
+}
+
+// Profiles the site behaviors choose from, each is one cache policy shared by all distributions
+var cacheProfiles = []cacheProfile{
+	// Documents are revalidated by their no-cache header and invalidated on deploy
+	{"html", "Documents revalidated with the origin", 0, 60 * 60, 60 * 60 * 24},
+	// Hashed assets never change under the same name
+	{"assets", "Hashed scripts, styles and fonts", 0, 60 * 60 * 24, 60 * 60 * 24 * 365},
+	{"images", "Images", 0, 60 * 60 * 24 * 30, 60 * 60 * 24 * 365},
+	// Data fetched by the pages, like the price list, changes without a deploy of the pages
+	{"data", "JSON data loaded by the pages", 0, 60, 60 * 5},
+}
+
+// Behaviors of sites without cache-behaviors in their config
+var defaultCacheBehaviors = cacheBehaviors{
+	{PathPattern: "/images/*", Profile: "images"},
+}
+
+// Characters CloudFront accepts in a path pattern, * and ? are wildcards
+var cachePathPattern = regexp.MustCompile(`^[A-Za-z0-9_\-.*?$/~"'@:+&]+$`)
+
+// cacheBehavior serves the paths matching the CloudFront path pattern with the cache profile
+type cacheBehavior struct {
+	PathPattern string `json:"path-pattern"`
+	Profile     string `json:"profile"`
+}
+
+// cacheBehaviors are evaluated by CloudFront in order, the first matching behavior wins
+type cacheBehaviors []cacheBehavior
+
+func findCacheProfile(name string) (cacheProfile, bool) {
+	for _, profile := range cacheProfiles {
+		if profile.name == name {
+			return profile, true
+		}
+	}
+	return cacheProfile{}, false
+}
+
+func (behaviors cacheBehaviors) validate() error {
+	if len(behaviors) > maxCacheBehaviors {
+		return fmt.Errorf("at most %d cache-behaviors are allowed, got %d", maxCacheBehaviors, len(behaviors))
+	}
+	seen := make(map[string]bool, len(behaviors))
+	for i, behavior := range behaviors {
+		if behavior.PathPattern == "" || behavior.Profile == "" {
+			return fmt.Errorf("cache behavior %d: path-pattern and profile are required", i)
+		}
+		if len(behavior.PathPattern) > 255 || !cachePathPattern.MatchString(behavior.PathPattern) {
+			return fmt.Errorf("cache behavior %d: invalid path-pattern %q", i, behavior.PathPattern)
+		}
+		if behavior.PathPattern == "*" || behavior.PathPattern == "/*" {
+			return fmt.Errorf("cache behavior %d: path-pattern %q is served by the default behavior", i, behavior.PathPattern)
+		}
+		if seen[behavior.PathPattern] {
+			return fmt.Errorf("cache behavior %d: duplicate path-pattern %q", i, behavior.PathPattern)
+		}
+		seen[behavior.PathPattern] = true
+		if _, ok := findCacheProfile(behavior.Profile); !ok {
+			return fmt.Errorf("cache behavior %d: unknown profile %q", i, behavior.Profile)
+		}
+	}
+	return nil
+}
+
+// cachePolicies are the cache policies by profile name and the origin request
+// policy of all behaviors, shared by every distribution
+type cachePolicies struct {
+	profiles      map[string]*cloudfront.CachePolicy
+	originRequest *cloudfront.OriginRequestPolicy
+}
+
+func createCachePolicies(ctx *pulumi.Context) (cachePolicies, error) {
+	// The cache key has no query strings, cookies or headers, the normalized
+	// Accept-Encoding is part of it so compressed responses are cached apart
+	// and the edge lambda picks the pre-compressed variants by it
+	policies := cachePolicies{profiles: make(map[string]*cloudfront.CachePolicy, len(cacheProfiles))}
+	for _, profile := range cacheProfiles {
+		policyName := fmt.Sprintf("%s-cache-%s", ctx.Project(), profile.name)
+		log.Printf("Creating cache policy %s\n", policyName)
+		policy, err := cloudfront.NewCachePolicy(ctx, policyName, &cloudfront.CachePolicyArgs{
+			Name:       pulumi.String(policyName),
+			Comment:    pulumi.String(profile.comment),
+			MinTtl:     pulumi.Int(profile.minTtl),
+			DefaultTtl: pulumi.Int(profile.defaultTtl),
+			MaxTtl:     pulumi.Int(profile.maxTtl),
+			ParametersInCacheKeyAndForwardedToOrigin: &cloudfront.CachePolicyParametersInCacheKeyAndForwardedToOriginArgs{
+				CookiesConfig: &cloudfront.CachePolicyParametersInCacheKeyAndForwardedToOriginCookiesConfigArgs{
+					CookieBehavior: pulumi.String("none"),
+				},
+				HeadersConfig: &cloudfront.CachePolicyParametersInCacheKeyAndForwardedToOriginHeadersConfigArgs{
+					HeaderBehavior: pulumi.String("none"),
+				},
+				QueryStringsConfig: &cloudfront.CachePolicyParametersInCacheKeyAndForwardedToOriginQueryStringsConfigArgs{
+					QueryStringBehavior: pulumi.String("none"),
+				},
+				EnableAcceptEncodingBrotli: pulumi.Bool(true),
+				EnableAcceptEncodingGzip:   pulumi.Bool(true),
+			},
+		})
+		if err != nil {
+			return cachePolicies{}, resourceErr(policyName, err)
+		}
+		policies.profiles[profile.name] = policy
+	}
+
+	// S3 needs none of the viewer values, so nothing is forwarded besides the cache key
+	policyName := fmt.Sprintf("%s-origin-request", ctx.Project())
+	log.Printf("Creating origin request policy %s\n", policyName)
+	originRequest, err := cloudfront.NewOriginRequestPolicy(ctx, policyName, &cloudfront.OriginRequestPolicyArgs{
+		Name:    pulumi.String(policyName),
+		Comment: pulumi.String("Static site bucket origins"),
+		CookiesConfig: &cloudfront.OriginRequestPolicyCookiesConfigArgs{
+			CookieBehavior: pulumi.String("none"),
+		},
+		HeadersConfig: &cloudfront.OriginRequestPolicyHeadersConfigArgs{
+			HeaderBehavior: pulumi.String("none"),
+		},
+		QueryStringsConfig: &cloudfront.OriginRequestPolicyQueryStringsConfigArgs{
+			QueryStringBehavior: pulumi.String("none"),
+		},
+	})
+	if err != nil {
+		return cachePolicies{}, resourceErr(policyName, err)
+	}
+	policies.originRequest = originRequest
+	return policies, nil
+}
+
+func siteCacheBehaviors(project staticSiteProject) cacheBehaviors {
+	// Ordered behaviors of the site distribution
+	if len(project.cacheBehaviors) == 0 {
+		return defaultCacheBehaviors
+	}
+	return project.cacheBehaviors
+}
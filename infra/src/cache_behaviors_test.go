@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+func TestCacheBehaviorsValidate(t *testing.T) {
+	valid := cacheBehaviors{
+		{PathPattern: "/pricing.json", Profile: "data"},
+		{PathPattern: "/images/*", Profile: "images"},
+		{PathPattern: "*.css", Profile: "assets"},
+		{PathPattern: "/docs/page-?.html", Profile: "html"},
+	}
+	if err := valid.validate(); err != nil {
+		t.Fatalf("valid behaviors rejected: %v", err)
+	}
+
+	tooMany := make(cacheBehaviors, maxCacheBehaviors+1)
+	for i := range tooMany {
+		tooMany[i] = cacheBehavior{PathPattern: fmt.Sprintf("/%d/*", i), Profile: "images"}
+	}
+	tests := []struct {
+		name      string
+		behaviors cacheBehaviors
+		err       string
+	}{
+		{"missing profile", cacheBehaviors{{PathPattern: "/images/*"}}, "are required"},
+		{"unknown profile", cacheBehaviors{{PathPattern: "/images/*", Profile: "photos"}}, "unknown profile"},
+		{"space", cacheBehaviors{{PathPattern: "/my images/*", Profile: "images"}}, "invalid path-pattern"},
+		{"default", cacheBehaviors{{PathPattern: "*", Profile: "html"}}, "default behavior"},
+		{"duplicate", cacheBehaviors{{PathPattern: "*.js", Profile: "assets"}, {PathPattern: "*.js", Profile: "data"}}, "duplicate path-pattern"},
+		{"too many", tooMany, "at most"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.behaviors.validate(); err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("validate() = %v, want error containing %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestDeployProjectCacheBehaviors(t *testing.T) {
+	project := testProject("example.com", "www.example.com")
+	project.cacheBehaviors = cacheBehaviors{
+		{PathPattern: "/pricing.json", Profile: "data"},
+		{PathPattern: "*.js", Profile: "assets"},
+	}
+	mocks, err := runWithMocks(t, "test", nil, deployTestProject(project))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	distribution := mocks.find(t, "aws:cloudfront/distribution:Distribution", "example.com-cdn")
+	defaultBehavior := distribution.Inputs["defaultCacheBehavior"].(map[string]interface{})
+	if defaultBehavior["cachePolicyId"] != "www-infra-cache-html-id" || defaultBehavior["originRequestPolicyId"] != "www-infra-origin-request-id" {
+		t.Errorf("default behavior policies = %v", defaultBehavior)
+	}
+	if _, ok := defaultBehavior["forwardedValues"]; ok {
+		t.Error("default behavior uses deprecated forwarded values")
+	}
+
+	ordered := distribution.Inputs["orderedCacheBehaviors"].([]interface{})
+	want := [][2]string{{"/pricing.json", "www-infra-cache-data-id"}, {"*.js", "www-infra-cache-assets-id"}}
+	if len(ordered) != len(want) {
+		t.Fatalf("ordered behaviors = %v", ordered)
+	}
+	for i, behavior := range ordered {
+		behavior := behavior.(map[string]interface{})
+		if behavior["pathPattern"] != want[i][0] || behavior["cachePolicyId"] != want[i][1] || behavior["originRequestPolicyId"] != "www-infra-origin-request-id" {
+			t.Errorf("behavior %d = %v, want %v", i, behavior, want[i])
+		}
+		for _, ttl := range []string{"minTtl", "defaultTtl", "maxTtl"} {
+			if _, ok := behavior[ttl]; ok {
+				t.Errorf("behavior %d sets %s next to its cache policy", i, ttl)
+			}
+		}
+	}
+
+	data := mocks.find(t, "aws:cloudfront/cachePolicy:CachePolicy", "www-infra-cache-data")
+	if data.Inputs["maxTtl"] != float64(300) {
+		t.Errorf("data policy inputs = %v", data.Inputs)
+	}
+}
+
+func TestDeployProjectDefaultCacheBehaviors(t *testing.T) {
+	mocks, err := runWithMocks(t, "test", nil, deployTestProject(testProject("example.com")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	distribution := mocks.find(t, "aws:cloudfront/distribution:Distribution", "example.com-cdn")
+	ordered := distribution.Inputs["orderedCacheBehaviors"].([]interface{})
+	if len(ordered) != 1 || ordered[0].(map[string]interface{})["pathPattern"] != "/images/*" || ordered[0].(map[string]interface{})["cachePolicyId"] != "www-infra-cache-images-id" {
+		t.Errorf("default ordered behaviors = %v", ordered)
+	}
+}
+
+func TestCachePoliciesAreShared(t *testing.T) {
+	mocks, err := runWithMocks(t, "test", nil, func(ctx *pulumi.Context) error {
+		logsBucket, err := createBucket(ctx, "test-logs")
+		if err != nil {
+			return err
+		}
+		policies, err := createCachePolicies(ctx)
+		if err != nil {
+			return err
+		}
+		var errs deployErrors
+		for _, project := range []staticSiteProject{testProject("example.com"), testProject("example.net")} {
+			project.name = project.domain
+			errs.add(project.name, deployProject(ctx, project, logsBucket, nil, policies))
+		}
+		return errs.errOrNil()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policies := mocks.byType("aws:cloudfront/cachePolicy:CachePolicy"); len(policies) != len(cacheProfiles) {
+		t.Errorf("cache policies = %s, want one per profile", names(policies))
+	}
+	if policies := mocks.byType("aws:cloudfront/originRequestPolicy:OriginRequestPolicy"); len(policies) != 1 {
+		t.Errorf("origin request policies = %s", names(policies))
+	}
+	for _, domain := range []string{"example.com", "example.net"} {
+		behavior := mocks.find(t, "aws:cloudfront/distribution:Distribution", domain+"-cdn").Inputs["defaultCacheBehavior"].(map[string]interface{})
+		if behavior["cachePolicyId"] != "www-infra-cache-html-id" {
+			t.Errorf("%s default cache policy = %v", domain, behavior["cachePolicyId"])
+		}
+	}
+}
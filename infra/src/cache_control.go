@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Parcel names built assets as name.<8 hex chars>.ext
+const hashedAssetPattern = "*.[0-9a-f][0-9a-f][0-9a-f][0-9a-f][0-9a-f][0-9a-f][0-9a-f][0-9a-f].*"
+
+// cacheControlRule sets the Cache-Control header of objects matching the pattern.
+// Patterns use path.Match syntax, patterns without a slash match the file name
+// in any directory, patterns with a slash match the whole key
+type cacheControlRule struct {
+	Pattern      string `json:"pattern"`
+	CacheControl string `json:"cache-control"`
+}
+
+// cacheControlRules are evaluated in order, the first matching rule wins
+type cacheControlRules []cacheControlRule
+
+var defaultCacheControlRules = cacheControlRules{
+	// Documents and crawler files must be revalidated to pick up new deploys
+	{Pattern: "*.html", CacheControl: "no-cache"},
+	{Pattern: "sitemap.xml", CacheControl: "no-cache"},
+	{Pattern: "robots.txt", CacheControl: "no-cache"},
+	// Hashed assets never change under the same name
+	{Pattern: hashedAssetPattern, CacheControl: "public, max-age=31536000, immutable"},
+	{Pattern: "*.woff2", CacheControl: "public, max-age=31536000"},
+	{Pattern: "*.woff", CacheControl: "public, max-age=31536000"},
+	{Pattern: "*.ttf", CacheControl: "public, max-age=31536000"},
+	{Pattern: "*.eot", CacheControl: "public, max-age=31536000"},
+	{Pattern: "images/*", CacheControl: "public, max-age=2592000"},
+	{Pattern: "*.webp", CacheControl: "public, max-age=2592000"},
+	{Pattern: "*.png", CacheControl: "public, max-age=2592000"},
+	{Pattern: "*.jpg", CacheControl: "public, max-age=2592000"},
+	{Pattern: "*.svg", CacheControl: "public, max-age=2592000"},
+	{Pattern: "*.ico", CacheControl: "public, max-age=2592000"},
+	{Pattern: "*", CacheControl: "public, max-age=86400"},
+}
+
+func (rule cacheControlRule) matches(key string) bool {
+	key = strings.TrimPrefix(key, "/")
+	if !strings.Contains(rule.Pattern, "/") {
+		key = path.Base(key)
+	}
+	matched, _ := path.Match(rule.Pattern, key)
+	return matched
+}
+
+func (rules cacheControlRules) validate() error {
+	for i, rule := range rules {
+		if rule.Pattern == "" || rule.CacheControl == "" {
+			return fmt.Errorf("cache-control rule %d: pattern and cache-control are required", i)
+		}
+		if _, err := path.Match(rule.Pattern, ""); err != nil {
+			return fmt.Errorf("cache-control rule %d: invalid pattern %q: %w", i, rule.Pattern, err)
+		}
+	}
+	return nil
+}
+
+func (rules cacheControlRules) cacheControl(key string) string {
+	// Site rules take precedence over the built-in defaults
+	for _, rule := range append(append(cacheControlRules{}, rules...), defaultCacheControlRules...) {
+		if rule.matches(key) {
+			return rule.CacheControl
+		}
+	}
+	return ""
+}
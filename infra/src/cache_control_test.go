@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestDefaultCacheControl(t *testing.T) {
+	tests := map[string]string{
+		"/index.html":                   "no-cache",
+		"galerie.html":                  "no-cache",
+		"sitemap.xml":                   "no-cache",
+		"robots.txt":                    "no-cache",
+		"index.3d214d75.js":             "public, max-age=31536000, immutable",
+		"assets/main.8f3e2a91.css":      "public, max-age=31536000, immutable",
+		"banner.0a1b2c3d.webp":          "public, max-age=31536000, immutable",
+		"jquery.scrolly.min.js":         "public, max-age=86400",
+		"webfonts/fa-solid-900.woff2":   "public, max-age=31536000",
+		"images/galerie/galerie_01.jpg": "public, max-age=2592000",
+		"images/banner_logo.png":        "public, max-age=2592000",
+		"favicon.ico":                   "public, max-age=2592000",
+		"pricing.json":                  "public, max-age=86400",
+	}
+	for key, want := range tests {
+		if got := cacheControlRules(nil).cacheControl(key); got != want {
+			t.Errorf("cacheControl(%s) = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestSiteCacheControlRules(t *testing.T) {
+	rules := cacheControlRules{
+		{Pattern: "pricing.json", CacheControl: "no-cache"},
+		{Pattern: "images/galerie/*", CacheControl: "public, max-age=604800"},
+	}
+	tests := map[string]string{
+		"pricing.json":                  "no-cache",
+		"data/pricing.json":             "no-cache",
+		"images/galerie/galerie_01.jpg": "public, max-age=604800",
+		"images/banner.webp":            "public, max-age=2592000",
+		"index.html":                    "no-cache",
+	}
+	for key, want := range tests {
+		if got := rules.cacheControl(key); got != want {
+			t.Errorf("cacheControl(%s) = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestCacheControlRulesValidate(t *testing.T) {
+	if err := (cacheControlRules{{Pattern: "*.json", CacheControl: "no-cache"}}).validate(); err != nil {
+		t.Error(err)
+	}
+	if err := (cacheControlRules{{Pattern: "[a-", CacheControl: "no-cache"}}).validate(); err == nil {
+		t.Error("expected error for invalid pattern")
+	}
+	if err := (cacheControlRules{{Pattern: "*.json"}}).validate(); err == nil {
+		t.Error("expected error for missing cache-control")
+	}
+}
@@ -0,0 +1,209 @@
+// Command submissions lists and exports the stored contact form submissions.
+//
+//	go run ./cmd/submissions list -site sramek-transportation -since 2024-05-01
+//	go run ./cmd/submissions export -o objednavky.csv
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+
+	"sramek.com/m/v2/internal/submission"
+)
+
+// Table created by the mail subsystem of the infrastructure
+const defaultTable = "email-form-submissions"
+
+// Layout of the submission times in the output
+const timeLayout = "2006-01-02 15:04"
+
+// Length of messages shown by the list command
+const listMessageLength = 40
+
+// Header of the CSV export, read by the business owners
+var csvHeader = []string{"Datum", "Web", "Jméno", "Telefon", "Email", "Zpráva", "Stav", "Pokusy", "Chyba"}
+
+// lister reads the stored submissions, replaced in tests
+type lister interface {
+	List(ctx context.Context, site string, since time.Time) ([]submission.Submission, error)
+}
+
+// options are the flags shared by the commands
+type options struct {
+	table  string
+	site   string
+	since  time.Time
+	output string
+	comma  string
+	zone   *time.Location
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: submissions list|export [flags]")
+	fmt.Fprintln(w, "  list    prints the submissions as a table")
+	fmt.Fprintln(w, "  export  writes the submissions as CSV")
+}
+
+func parseOptions(command string, args []string, stderr io.Writer) (options, error) {
+	flags := flag.NewFlagSet(command, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	opts := options{}
+	var since, zone string
+	flags.StringVar(&opts.table, "table", defaultTable, "DynamoDB table of the submissions")
+	flags.StringVar(&opts.site, "site", "", "site of the submissions, all sites when empty")
+	flags.StringVar(&since, "since", "", "first day of the submissions as YYYY-MM-DD, all when empty")
+	flags.StringVar(&zone, "zone", "Europe/Prague", "time zone of the submission times")
+	if command == "export" {
+		flags.StringVar(&opts.output, "o", "", "output file, standard output when empty")
+		flags.StringVar(&opts.comma, "comma", ";", "CSV field separator")
+	}
+	if err := flags.Parse(args); err != nil {
+		return opts, err
+	}
+	if flags.NArg() > 0 {
+		return opts, fmt.Errorf("unexpected arguments %v", flags.Args())
+	}
+
+	location, err := time.LoadLocation(zone)
+	if err != nil {
+		return opts, fmt.Errorf("invalid -zone: %w", err)
+	}
+	opts.zone = location
+	if since != "" {
+		opts.since, err = time.ParseInLocation("2006-01-02", since, location)
+		if err != nil {
+			return opts, fmt.Errorf("invalid -since: %w", err)
+		}
+	}
+	if command == "export" && utf8.RuneCountInString(opts.comma) != 1 {
+		return opts, fmt.Errorf("-comma must be a single character")
+	}
+	return opts, nil
+}
+
+func truncate(value string, length int) string {
+	runes := []rune(value)
+	if len(runes) <= length {
+		return value
+	}
+	return string(runes[:length-1]) + "…"
+}
+
+func list(w io.Writer, submissions []submission.Submission, opts options) error {
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "TIME\tSITE\tNAME\tPHONE\tEMAIL\tSTATUS\tMESSAGE")
+	for _, s := range submissions {
+		message := truncate(singleLine(s.Message), listMessageLength)
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			s.SubmittedAt.In(opts.zone).Format(timeLayout), s.Site, s.Name, s.Phone, s.Email, s.Status, message)
+	}
+	return table.Flush()
+}
+
+func singleLine(value string) string {
+	runes := []rune(value)
+	for i, r := range runes {
+		if r == '\n' || r == '\r' || r == '\t' {
+			runes[i] = ' '
+		}
+	}
+	return string(runes)
+}
+
+func spreadsheetCell(value string) string {
+	// Spreadsheets run cells starting with these characters as formulas, the
+	// quote makes them text. Values come from the public form
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+func export(w io.Writer, submissions []submission.Submission, opts options) error {
+	// Starts with a byte order mark, so spreadsheets read the file as UTF-8
+	if _, err := io.WriteString(w, "\uFEFF"); err != nil {
+		return err
+	}
+	writer := csv.NewWriter(w)
+	writer.Comma, _ = utf8.DecodeRuneInString(opts.comma)
+	if err := writer.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, s := range submissions {
+		err := writer.Write([]string{
+			s.SubmittedAt.In(opts.zone).Format(timeLayout), s.Site,
+			spreadsheetCell(s.Name), spreadsheetCell(s.Phone), spreadsheetCell(s.Email), spreadsheetCell(s.Message),
+			s.Status, strconv.Itoa(s.Attempts), spreadsheetCell(s.LastError),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func run(ctx context.Context, args []string, stdout io.Writer, stderr io.Writer, newLister func(table string) (lister, error)) error {
+	if len(args) == 0 || (args[0] != "list" && args[0] != "export") {
+		usage(stderr)
+		return errors.New("unknown command")
+	}
+	command := args[0]
+	opts, err := parseOptions(command, args[1:], stderr)
+	if err != nil {
+		return err
+	}
+	store, err := newLister(opts.table)
+	if err != nil {
+		return err
+	}
+	submissions, err := store.List(ctx, opts.site, opts.since)
+	if err != nil {
+		return err
+	}
+	log.Printf("Found %d submissions\n", len(submissions))
+
+	if command == "list" {
+		return list(stdout, submissions, opts)
+	}
+	if opts.output == "" {
+		return export(stdout, submissions, opts)
+	}
+	file, err := os.Create(opts.output)
+	if err != nil {
+		return err
+	}
+	if err := export(file, submissions, opts); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func dynamoLister(table string) (lister, error) {
+	cfg, err := config.LoadDefaultConfig(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS config: %w", err)
+	}
+	return submission.Store{Db: dynamodb.NewFromConfig(cfg), Table: table}, nil
+}
+
+func main() {
+	if err := run(context.Background(), os.Args[1:], os.Stdout, os.Stderr, dynamoLister); err != nil {
+		log.Fatal(err)
+	}
+}
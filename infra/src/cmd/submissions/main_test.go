@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"sramek.com/m/v2/internal/submission"
+)
+
+// fixedLister returns its submissions and records the listed site and time
+type fixedLister struct {
+	submissions []submission.Submission
+	site        string
+	since       time.Time
+}
+
+func (l *fixedLister) List(ctx context.Context, site string, since time.Time) ([]submission.Submission, error) {
+	l.site, l.since = site, since
+	return l.submissions, nil
+}
+
+func testLister() *fixedLister {
+	return &fixedLister{submissions: []submission.Submission{
+		{
+			Site:        "sramek-transportation",
+			Id:          "1",
+			SubmittedAt: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+			Name:        "Jan Novák",
+			Phone:       "+420 123 456 789",
+			Email:       "jan@example.cz",
+			Message:     "Dobrý den;\npotřebuji odvézt \"písek\".",
+			Status:      submission.StatusSent,
+			Attempts:    1,
+		},
+	}}
+}
+
+func runWith(t *testing.T, fixed *fixedLister, args ...string) (string, error) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	err := run(context.Background(), args, &stdout, &stderr, func(table string) (lister, error) {
+		if table != defaultTable {
+			t.Errorf("table = %s", table)
+		}
+		return fixed, nil
+	})
+	return stdout.String(), err
+}
+
+func TestExport(t *testing.T) {
+	lister := testLister()
+	out, err := runWith(t, lister, "export", "-site", "sramek-transportation", "-since", "2024-05-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lister.site != "sramek-transportation" || !lister.since.Equal(time.Date(2024, 4, 30, 22, 0, 0, 0, time.UTC)) {
+		t.Errorf("listed site %s since %v", lister.site, lister.since)
+	}
+	if !strings.HasPrefix(out, "\uFEFF") {
+		t.Error("export without byte order mark")
+	}
+
+	reader := csv.NewReader(strings.NewReader(strings.TrimPrefix(out, "\uFEFF")))
+	reader.Comma = ';'
+	records, err := reader.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || strings.Join(records[0], ",") != strings.Join(csvHeader, ",") {
+		t.Fatalf("records = %v", records)
+	}
+	want := []string{"2024-05-01 14:00", "sramek-transportation", "Jan Novák", "'+420 123 456 789", "jan@example.cz",
+		"Dobrý den;\npotřebuji odvézt \"písek\".", "sent", "1", ""}
+	if strings.Join(records[1], "|") != strings.Join(want, "|") {
+		t.Errorf("record = %q, want %q", records[1], want)
+	}
+}
+
+func TestExportEscapesFormulas(t *testing.T) {
+	lister := &fixedLister{submissions: []submission.Submission{
+		{
+			SubmittedAt: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+			Name:        `=HYPERLINK("https://example.com","Jan")`,
+			Email:       "@jan@example.cz",
+			Message:     "-1+1",
+			LastError:   "+boom",
+		},
+	}}
+	out, err := runWith(t, lister, "export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader := csv.NewReader(strings.NewReader(strings.TrimPrefix(out, "\uFEFF")))
+	reader.Comma = ';'
+	records, err := reader.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := records[1]
+	want := map[int]string{2: `'=HYPERLINK("https://example.com","Jan")`, 4: "'@jan@example.cz", 5: "'-1+1", 8: "'+boom"}
+	for column, value := range want {
+		if record[column] != value {
+			t.Errorf("column %s = %q, want %q", csvHeader[column], record[column], value)
+		}
+	}
+}
+
+func TestSpreadsheetCell(t *testing.T) {
+	tests := map[string]string{
+		"":          "",
+		"Jan Novák": "Jan Novák",
+		"=1+1":      "'=1+1",
+		"\t=1+1":    "'\t=1+1",
+		"a=1":       "a=1",
+	}
+	for value, want := range tests {
+		if got := spreadsheetCell(value); got != want {
+			t.Errorf("spreadsheetCell(%q) = %q, want %q", value, got, want)
+		}
+	}
+}
+
+func TestExportToFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "submissions.csv")
+	if _, err := runWith(t, testLister(), "export", "-o", path, "-comma", ","); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	content, _ := io.ReadAll(file)
+	if !strings.Contains(string(content), "Datum,Web,Jméno") {
+		t.Errorf("exported %s", content)
+	}
+}
+
+func TestList(t *testing.T) {
+	out, err := runWith(t, testLister(), "list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "TIME") {
+		t.Fatalf("listed %q", out)
+	}
+	if !strings.Contains(lines[1], "Dobrý den; potřebuji odvézt \"písek\".") || !strings.Contains(lines[1], "2024-05-01 14:00") {
+		t.Errorf("listed %q", lines[1])
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	for _, args := range [][]string{
+		nil,
+		{"delete"},
+		{"list", "-since", "yesterday"},
+		{"list", "-zone", "Mars/Olympus"},
+		{"export", "-comma", ";;"},
+		{"list", "extra"},
+	} {
+		if _, err := runWith(t, testLister(), args...); err == nil {
+			t.Errorf("args %v accepted", args)
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	if got := truncate("Dobrý den", 5); got != "Dobr…" {
+		t.Errorf("truncate = %s", got)
+	}
+	if got := truncate("Ahoj", 5); got != "Ahoj" {
+		t.Errorf("truncate = %s", got)
+	}
+}
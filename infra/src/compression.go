@@ -0,0 +1,124 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// Pre-compressed variants are stored next to the original object with the
+// encoding suffix, the edge lambda rewrites requests to them by Accept-Encoding
+var (
+	compressibleExtensions = map[string]bool{
+		".html": true,
+		".css":  true,
+		".js":   true,
+		".mjs":  true,
+		".json": true,
+		".svg":  true,
+		".xml":  true,
+	}
+	contentEncodings = []struct {
+		name   string
+		suffix string
+	}{
+		{"br", ".br"},
+		{"gzip", ".gz"},
+	}
+)
+
+// Origin custom header telling the edge lambda the index document of the
+// site, it resolves directory requests to the document and its variants
+const indexDocumentHeader = "X-Index-Document"
+
+// compressedVariant is a pre-compressed copy of a content file
+type compressedVariant struct {
+	suffix    string // appended to the key of the original object
+	encoding  string // Content-Encoding of the variant
+	localPath string
+}
+
+func isCompressible(key string) bool {
+	return compressibleExtensions[strings.ToLower(filepath.Ext(key))]
+}
+
+func variantSource(key string) (string, bool) {
+	// Returns the key of the original object if the key belongs to a pre-compressed variant
+	for _, encoding := range contentEncodings {
+		if source, found := strings.CutSuffix(key, encoding.suffix); found && isCompressible(source) {
+			return source, true
+		}
+	}
+	return "", false
+}
+
+// precompressedRoot holds the variants of all sites next to the lambda archives, replaced in tests
+var precompressedRoot = filepath.Join("..", "dist", "precompressed")
+
+func precompressedDir(projectName string) string {
+	return filepath.Join(precompressedRoot, projectName)
+}
+
+func compressFile(localPath string, relPath string, outDir string) ([]compressedVariant, error) {
+	// Writes brotli and gzip variants of a compressible file to outDir, the
+	// output is deterministic so unchanged files keep their content hash
+	if !isCompressible(localPath) {
+		return nil, nil
+	}
+	content, err := os.ReadFile(localPath)
+	if err != nil {
+		return nil, err
+	}
+
+	variants := make([]compressedVariant, 0, len(contentEncodings))
+	for _, encoding := range contentEncodings {
+		variantPath := filepath.Join(outDir, relPath+encoding.suffix)
+		if err := os.MkdirAll(filepath.Dir(variantPath), 0o755); err != nil {
+			return nil, err
+		}
+		if err := writeCompressed(variantPath, content, encoding.name); err != nil {
+			return nil, fmt.Errorf("compressing %s: %w", localPath, err)
+		}
+		variants = append(variants, compressedVariant{
+			suffix:    encoding.suffix,
+			encoding:  encoding.name,
+			localPath: variantPath,
+		})
+	}
+	return variants, nil
+}
+
+func writeCompressed(path string, content []byte, encoding string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var writer io.WriteCloser
+	switch encoding {
+	case "br":
+		writer = brotli.NewWriterLevel(file, brotli.BestCompression)
+	case "gzip":
+		// gzip header carries no name nor modification time, so the output is stable
+		writer, err = gzip.NewWriterLevel(file, gzip.BestCompression)
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown content encoding %s", encoding)
+	}
+	if _, err := writer.Write(content); err != nil {
+		writer.Close()
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+func usePrecompressedRoot(t *testing.T) string {
+	// Keeps variants built by the test out of the dist directory
+	t.Helper()
+	previous := precompressedRoot
+	precompressedRoot = t.TempDir()
+	t.Cleanup(func() { precompressedRoot = previous })
+	return precompressedRoot
+}
+
+func decompress(t *testing.T, path string, encoding string) []byte {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var reader io.Reader = brotli.NewReader(file)
+	if encoding == "gzip" {
+		if reader, err = gzip.NewReader(file); err != nil {
+			t.Fatal(err)
+		}
+	}
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return content
+}
+
+func TestCompressFile(t *testing.T) {
+	outDir := t.TempDir()
+	source := filepath.Join(testSiteDir, "index.html")
+	variants, err := compressFile(source, "index.html", outDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(variants) != 2 || variants[0].encoding != "br" || variants[1].encoding != "gzip" {
+		t.Fatalf("variants = %+v, want br and gzip", variants)
+	}
+
+	original, _ := os.ReadFile(source)
+	for _, variant := range variants {
+		if want := filepath.Join(outDir, "index.html"+variant.suffix); variant.localPath != want {
+			t.Errorf("%s variant written to %s, want %s", variant.encoding, variant.localPath, want)
+		}
+		if got := decompress(t, variant.localPath, variant.encoding); !bytes.Equal(got, original) {
+			t.Errorf("%s variant does not decompress to the original", variant.encoding)
+		}
+	}
+
+	// Rebuilding must produce identical bytes, otherwise every deploy re-uploads the variants
+	first, _ := os.ReadFile(variants[1].localPath)
+	if _, err := compressFile(source, "index.html", outDir); err != nil {
+		t.Fatal(err)
+	}
+	second, _ := os.ReadFile(variants[1].localPath)
+	if !bytes.Equal(first, second) {
+		t.Error("gzip output is not deterministic")
+	}
+}
+
+func TestCompressFileSkipsBinaryContent(t *testing.T) {
+	outDir := t.TempDir()
+	image := filepath.Join(t.TempDir(), "photo.webp")
+	if err := os.WriteFile(image, []byte("RIFF"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	variants, err := compressFile(image, "photo.webp", outDir)
+	if err != nil || len(variants) != 0 {
+		t.Errorf("compressFile(photo.webp) = %v, %v, want no variants", variants, err)
+	}
+}
+
+func TestVariantSource(t *testing.T) {
+	tests := []struct {
+		key    string
+		source string
+		ok     bool
+	}{
+		{"index.html.br", "index.html", true},
+		{"js/app.1a2b3c4d.js.gz", "js/app.1a2b3c4d.js", true},
+		{"index.html", "", false},
+		{"archive.tar.gz", "", false},
+		{"photo.webp.br", "", false},
+	}
+	for _, test := range tests {
+		source, ok := variantSource(test.key)
+		if source != test.source || ok != test.ok {
+			t.Errorf("variantSource(%s) = %s, %v, want %s, %v", test.key, source, ok, test.source, test.ok)
+		}
+	}
+}
+
+func TestChangedPathsOfVariants(t *testing.T) {
+	diff := manifestDiff{upload: []string{"about.html", "about.html.br", "about.html.gz", "blog/index.html.br"}}
+	want := []string{"/about.html", "/blog/", "/blog/index.html"}
+	if got := diff.changedPaths(); !reflect.DeepEqual(got, want) {
+		t.Errorf("changed paths = %v, want %v", got, want)
+	}
+}
+
+func TestBuildContentManifestWithVariants(t *testing.T) {
+	settings := objectSettings{precompressDir: t.TempDir()}
+	manifest, err := buildContentManifest(testSiteDir, settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"error.html", "error.html.br", "error.html.gz",
+		"images/logo.svg", "images/logo.svg.br", "images/logo.svg.gz",
+		"index.html", "index.html.br", "index.html.gz",
+	}
+	if got := manifestKeys(manifest); !reflect.DeepEqual(got, want) {
+		t.Fatalf("manifest keys = %v, want %v", got, want)
+	}
+
+	index, variant := manifest["index.html"], manifest["index.html.br"]
+	if variant.ContentEncoding != "br" || variant.ContentType != index.ContentType || variant.CacheControl != index.CacheControl {
+		t.Errorf("brotli variant %+v does not match original %+v", variant, index)
+	}
+	if variant.SHA256 == index.SHA256 || variant.localPath != filepath.Join(settings.precompressDir, "index.html.br") {
+		t.Errorf("brotli variant must describe the compressed file, got %+v", variant)
+	}
+}
+
+func TestDeployProjectPrecompressed(t *testing.T) {
+	usePrecompressedRoot(t)
+	project := testProject("example.com", "www.example.com")
+	project.precompress = true
+
+	mocks, err := runWithMocks(t, "test", nil, deployTestProject(project))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	objects := mocks.byType("aws:s3/bucketObject:BucketObject")
+	if len(objects) != 9 {
+		t.Errorf("expected originals with brotli and gzip variants, got %s", names(objects))
+	}
+	variant := mocks.find(t, "aws:s3/bucketObject:BucketObject", "www/test-site/index.html.gz")
+	index := mocks.find(t, "aws:s3/bucketObject:BucketObject", "www/test-site/index.html")
+	if variant.Inputs["key"] != "/index.html.gz" || variant.Inputs["contentEncoding"] != "gzip" {
+		t.Errorf("gzip variant inputs = %v", variant.Inputs)
+	}
+	if variant.Inputs["contentType"] != index.Inputs["contentType"] || variant.Inputs["cacheControl"] != index.Inputs["cacheControl"] {
+		t.Errorf("variant headers %v differ from original %v", variant.Inputs, index.Inputs)
+	}
+	if _, ok := index.Inputs["contentEncoding"]; ok {
+		t.Errorf("original object must not set content encoding, got %v", index.Inputs["contentEncoding"])
+	}
+
+	distribution := mocks.find(t, "aws:cloudfront/distribution:Distribution", "example.com-cdn")
+	behavior := distribution.Inputs["defaultCacheBehavior"].(map[string]interface{})
+	var events []string
+	for _, association := range behavior["lambdaFunctionAssociations"].([]interface{}) {
+		events = append(events, association.(map[string]interface{})["eventType"].(string))
+	}
+	if want := []string{"origin-request", "origin-response"}; !reflect.DeepEqual(events, want) {
+		t.Errorf("lambda association events = %v, want %v", events, want)
+	}
+	mocks.find(t, "aws:lambda/function:Function", "lambda-precompress")
+	origin := distribution.Inputs["origins"].([]interface{})[0].(map[string]interface{})
+	header := origin["customHeaders"].([]interface{})[0].(map[string]interface{})
+	if header["name"] != indexDocumentHeader || header["value"] != "index.html" {
+		t.Errorf("origin custom headers = %v, want the index document for the lambda", origin["customHeaders"])
+	}
+	// The lambda sees the Accept-Encoding the cache policy puts in the cache key
+	policy := mocks.find(t, "aws:cloudfront/cachePolicy:CachePolicy", strings.TrimSuffix(behavior["cachePolicyId"].(string), "-id"))
+	parameters := policy.Inputs["parametersInCacheKeyAndForwardedToOrigin"].(map[string]interface{})
+	if parameters["enableAcceptEncodingBrotli"] != true || parameters["enableAcceptEncodingGzip"] != true {
+		t.Errorf("cache policy parameters = %v, want Accept-Encoding in the cache key", parameters)
+	}
+}
+
+func TestPrecompressLambdaVary(t *testing.T) {
+	// Runs the origin response handler in node, responses of compressible
+	// paths vary by Accept-Encoding whichever variant was picked
+	node, err := exec.LookPath("node")
+	if err != nil {
+		t.Skip("node is needed to run the precompress lambda")
+	}
+	source, err := filepath.Abs(filepath.Join("lambda", "lambda_precompress.mjs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	script := `
+const { handler } = await import(process.argv[1]);
+const response = (uri, vary) => handler({ Records: [{ cf: {
+    config: { eventType: 'origin-response' },
+    request: { uri, headers: {} },
+    response: { status: '200', headers: vary ? { vary: [{ key: 'Vary', value: vary }] } : {} },
+} }] });
+const results = [];
+for (const [uri, vary] of [['/index.html.br'], ['/app.js', 'Origin'], ['/style.css.gz', 'Accept-Encoding'], ['/logo.png'], ['/docs/']]) {
+    const headers = (await response(uri, vary)).headers;
+    results.push(headers.vary ? headers.vary.map((header) => header.value).join(', ') : '');
+}
+console.log(JSON.stringify(results));
+`
+	output, err := exec.Command(node, "--input-type=module", "-e", script, source).CombinedOutput()
+	if err != nil {
+		t.Fatalf("running the lambda: %v\n%s", err, output)
+	}
+	var vary []string
+	if err := json.Unmarshal(output, &vary); err != nil {
+		t.Fatalf("invalid output %s: %v", output, err)
+	}
+	if want := []string{"Accept-Encoding", "Origin, Accept-Encoding", "Accept-Encoding", "", ""}; !reflect.DeepEqual(vary, want) {
+		t.Errorf("vary headers = %q, want %q", vary, want)
+	}
+}
+
+func TestPrecompressLambdaIndexDocument(t *testing.T) {
+	// Runs the origin request and response handlers in node, directories of
+	// sites with an index document get the variant of the document
+	node, err := exec.LookPath("node")
+	if err != nil {
+		t.Skip("node is needed to run the precompress lambda")
+	}
+	source, err := filepath.Abs(filepath.Join("lambda", "lambda_precompress.mjs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	script := `
+const { handler } = await import(process.argv[1]);
+const originRequest = (uri, acceptEncoding, indexDocument) => handler({ Records: [{ cf: {
+    config: { eventType: 'origin-request' },
+    request: {
+        uri,
+        headers: acceptEncoding ? { 'accept-encoding': [{ key: 'Accept-Encoding', value: acceptEncoding }] } : {},
+        origin: { custom: { customHeaders: indexDocument ? { 'x-index-document': [{ key: 'X-Index-Document', value: indexDocument }] } : {} } },
+    },
+} }] });
+const originResponse = (uri) => handler({ Records: [{ cf: {
+    config: { eventType: 'origin-response' },
+    request: { uri, headers: {} },
+    response: { status: '200', headers: {} },
+} }] });
+const results = [];
+for (const [uri, acceptEncoding, indexDocument] of [['/', 'gzip, br', 'index.html'], ['/docs/', 'gzip', 'index.html'], ['/', '', 'index.html'], ['/', 'br', '']]) {
+    const request = await originRequest(uri, acceptEncoding, indexDocument);
+    const response = await originResponse(request.uri);
+    results.push(request.uri + ' ' + (response.headers.vary ? response.headers.vary[0].value : ''));
+}
+console.log(JSON.stringify(results));
+`
+	output, err := exec.Command(node, "--input-type=module", "-e", script, source).CombinedOutput()
+	if err != nil {
+		t.Fatalf("running the lambda: %v\n%s", err, output)
+	}
+	var results []string
+	if err := json.Unmarshal(output, &results); err != nil {
+		t.Fatalf("invalid output %s: %v", output, err)
+	}
+	want := []string{
+		"/index.html.br Accept-Encoding",
+		"/docs/index.html.gz Accept-Encoding",
+		"/index.html Accept-Encoding",
+		"/ ",
+	}
+	if !reflect.DeepEqual(results, want) {
+		t.Errorf("requests of directories = %q, want %q", results, want)
+	}
+}
+
+func TestDeployProjectWithoutPrecompress(t *testing.T) {
+	mocks, err := runWithMocks(t, "test", nil, deployTestProject(testProject("example.com")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	distribution := mocks.find(t, "aws:cloudfront/distribution:Distribution", "example.com-cdn")
+	behavior := distribution.Inputs["defaultCacheBehavior"].(map[string]interface{})
+	if associations, ok := behavior["lambdaFunctionAssociations"]; ok {
+		t.Errorf("expected no lambda associations, got %v", associations)
+	}
+	if got := names(mocks.byType("aws:lambda/function:Function")); got != "lambda-precompress" {
+		t.Errorf("lambda functions = %s, want only the kept edge function", got)
+	}
+}
+
+func TestDeployProjectManifestSyncPrecompressed(t *testing.T) {
+	usePrecompressedRoot(t)
+	store := useMemoryContentStore(t)
+	project := testProject("example.com")
+	project.contentSync = contentSyncManifest
+	project.precompress = true
+
+	if _, err := runWithMocks(t, "test", nil, deployTestProject(project)); err != nil {
+		t.Fatal(err)
+	}
+	entry, ok := store.objects["images/logo.svg.br"]
+	if !ok || entry.ContentEncoding != "br" || entry.ContentType != "image/svg+xml" {
+		t.Errorf("synced brotli variant = %+v, %v", entry, ok)
+	}
+}
+
+func TestParseSitesPrecompress(t *testing.T) {
+	sites, err := parseSites(`[{"name": "a", "dir": "d", "index-doc": "index.html", "error-doc": "error.html", "precompress": true}]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sites[0].precompress {
+		t.Error("precompress is not passed to the project")
+	}
+}
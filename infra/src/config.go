@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+)
+
+// siteConfig is a single entry of the `www-infra:sites` stack config
+type siteConfig struct {
+	Name       string   `json:"name"`
+	Dir        string   `json:"dir"`
+	BucketPath string   `json:"bucket-path"`
+	Domain     string   `json:"domain"`
+	Aliases    []string `json:"aliases"`
+	HostedZone string   `json:"hosted-zone"`
+	IndexDoc   string   `json:"index-doc"`
+	ErrorDoc   string   `json:"error-doc"`
+	Cors       string   `json:"cors"`
+	// Serve the site from a private bucket through CloudFront origin access control
+	PrivateOrigin bool `json:"private-origin"`
+	// How the content is uploaded, "objects" (default) or "manifest"
+	ContentSync string `json:"content-sync"`
+	// Cache-Control rules applied before the built-in defaults
+	CacheControl cacheControlRules `json:"cache-control"`
+	// CloudFront path patterns with their cache profiles, evaluated in order
+	CacheBehaviors cacheBehaviors `json:"cache-behaviors"`
+	// Content types by file extension applied before the built-in table
+	ContentTypes contentTypes `json:"content-types"`
+	// Upload brotli and gzip variants of text files served by Accept-Encoding
+	Precompress bool `json:"precompress"`
+	// Contact form mailed through SES, sites without it have no form endpoint
+	ContactForm *contactForm `json:"contact-form"`
+	// Pages CloudFront answers origin errors with, by error code
+	ErrorPages errorPages `json:"error-pages"`
+	// Host the other hosts redirect to, "www", "apex" or the primary domain when not set
+	CanonicalHost string `json:"canonical-host"`
+	// Redirect paths of directories to the form with ("add") or without ("remove") trailing slash
+	TrailingSlash string `json:"trailing-slash"`
+	// Legacy and renamed URLs redirected at the edge, in the config or a file
+	Redirects     redirectRules `json:"redirects"`
+	RedirectsFile string        `json:"redirects-file"`
+	// Relaxations of the strict security response headers
+	SecurityHeaders *securityHeaders `json:"security-headers"`
+}
+
+func (site siteConfig) validate() error {
+	// Checks that all required keys of the site are set
+	required := []struct {
+		key   string
+		value string
+	}{
+		{"name", site.Name},
+		{"dir", site.Dir},
+		{"index-doc", site.IndexDoc},
+		{"error-doc", site.ErrorDoc},
+	}
+	missing := make([]string, 0)
+	for _, field := range required {
+		if strings.TrimSpace(field.value) == "" {
+			missing = append(missing, field.key)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing required keys: %s", strings.Join(missing, ", "))
+	}
+	switch site.ContentSync {
+	case "", contentSyncObjects, contentSyncManifest:
+	default:
+		return fmt.Errorf("invalid content-sync %q, must be %s or %s", site.ContentSync, contentSyncObjects, contentSyncManifest)
+	}
+	switch site.CanonicalHost {
+	case "", canonicalHostWww, canonicalHostApex:
+	default:
+		return fmt.Errorf("invalid canonical-host %q, must be %s or %s", site.CanonicalHost, canonicalHostWww, canonicalHostApex)
+	}
+	switch site.TrailingSlash {
+	case "", trailingSlashAdd, trailingSlashRemove:
+	default:
+		return fmt.Errorf("invalid trailing-slash %q, must be %s or %s", site.TrailingSlash, trailingSlashAdd, trailingSlashRemove)
+	}
+	if err := site.CacheControl.validate(); err != nil {
+		return err
+	}
+	if err := site.CacheBehaviors.validate(); err != nil {
+		return err
+	}
+	if err := site.ContentTypes.validate(); err != nil {
+		return err
+	}
+	if err := site.Redirects.validate(); err != nil {
+		return err
+	}
+	if err := site.ErrorPages.validate(); err != nil {
+		return err
+	}
+	if err := site.SecurityHeaders.validate(); err != nil {
+		return err
+	}
+	return site.ContactForm.validate()
+}
+
+func (site siteConfig) project() staticSiteProject {
+	project := staticSiteProject{
+		name:       site.Name,
+		dir:        site.Dir,
+		bucketPath: site.BucketPath,
+		domain:     site.Domain,
+		aliases:    site.Aliases,
+		hostedZone: site.HostedZone,
+		indexDoc:   site.IndexDoc,
+		errorDoc:   site.ErrorDoc,
+		cors:       site.Cors,
+
+		privateOrigin: site.PrivateOrigin,
+		contentSync:   site.ContentSync,
+		cacheControl:  site.CacheControl,
+		contentTypes:  site.ContentTypes,
+		precompress:   site.Precompress,
+		contactForm:   site.ContactForm,
+
+		securityHeaders: site.SecurityHeaders,
+		cacheBehaviors:  site.CacheBehaviors,
+		errorPages:      site.ErrorPages,
+		canonicalHost:   site.CanonicalHost,
+		trailingSlash:   site.TrailingSlash,
+		redirects:       site.Redirects,
+		redirectsFile:   site.RedirectsFile,
+	}
+	if project.contentSync == "" {
+		project.contentSync = contentSyncObjects
+	}
+	return project
+}
+
+func parseSites(raw string) ([]staticSiteProject, error) {
+	// Parses and validates the sites registry, unknown keys are rejected to catch typos
+	decoder := json.NewDecoder(bytes.NewReader([]byte(raw)))
+	decoder.DisallowUnknownFields()
+	var sites []siteConfig
+	if err := decoder.Decode(&sites); err != nil {
+		return nil, fmt.Errorf("invalid sites config: %w", err)
+	}
+	if len(sites) == 0 {
+		return nil, fmt.Errorf("invalid sites config: no sites defined")
+	}
+
+	projects := make([]staticSiteProject, len(sites))
+	seen := make(map[string]bool, len(sites))
+	for i, site := range sites {
+		if err := site.validate(); err != nil {
+			return nil, fmt.Errorf("invalid sites config: site %d (%s): %w", i, site.Name, err)
+		}
+		if seen[site.Name] {
+			return nil, fmt.Errorf("invalid sites config: duplicate site name %s", site.Name)
+		}
+		seen[site.Name] = true
+		projects[i] = site.project()
+	}
+	return projects, nil
+}
+
+func getSitesConfig(ctx *pulumi.Context) ([]staticSiteProject, error) {
+	// Reads all deployed sites from the `www-infra:sites` stack config
+	raw, err := config.Try(ctx, fmt.Sprintf("%s:sites", ctx.Project()))
+	if err != nil {
+		return nil, fmt.Errorf("reading %s:sites config: %w", ctx.Project(), err)
+	}
+	return parseSites(raw)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseSites(t *testing.T) {
+	projects, err := parseSites(`[
+		{"name": "garden", "dir": "dist", "domain": "example.com", "index-doc": "index.html", "error-doc": "error.html"},
+		{"name": "transport", "dir": "dist2", "bucket-path": "www/transport", "index-doc": "index.html", "error-doc": "404.html", "cors": "*"}
+	]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(projects) != 2 {
+		t.Fatalf("expected 2 projects, got %d", len(projects))
+	}
+	want := staticSiteProject{
+		name:       "transport",
+		dir:        "dist2",
+		bucketPath: "www/transport",
+		indexDoc:   "index.html",
+		errorDoc:   "404.html",
+		cors:       "*",
+
+		contentSync: contentSyncObjects,
+	}
+	if !reflect.DeepEqual(projects[1], want) {
+		t.Errorf("project = %+v, want %+v", projects[1], want)
+	}
+}
+
+func TestParseSitesErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want string
+	}{
+		{"empty", `[]`, "no sites defined"},
+		{"not a list", `{"name": "garden"}`, "invalid sites config"},
+		{"missing keys", `[{"name": "garden", "dir": "dist"}]`, "site 0 (garden): missing required keys: index-doc, error-doc"},
+		{"unknown key", `[{"name": "garden", "dir": "dist", "index-doc": "i", "error-doc": "e", "domian": "x"}]`, `unknown field "domian"`},
+		{"content sync", `[{"name": "a", "dir": "d", "index-doc": "i", "error-doc": "e", "content-sync": "rsync"}]`, `invalid content-sync "rsync"`},
+		{"canonical host", `[{"name": "a", "dir": "d", "index-doc": "i", "error-doc": "e", "canonical-host": "root"}]`, `invalid canonical-host "root"`},
+		{"trailing slash", `[{"name": "a", "dir": "d", "index-doc": "i", "error-doc": "e", "trailing-slash": "yes"}]`, `invalid trailing-slash "yes"`},
+		{"redirect", `[{"name": "a", "dir": "d", "index-doc": "i", "error-doc": "e", "redirects": [{"from": "/old.html", "to": "/new.html", "status": 308}]}]`, "invalid status 308"},
+		{"cache control", `[{"name": "a", "dir": "d", "index-doc": "i", "error-doc": "e", "cache-control": [{"pattern": "*.json"}]}]`, "pattern and cache-control are required"},
+		{"content types", `[{"name": "a", "dir": "d", "index-doc": "i", "error-doc": "e", "content-types": {"rss": "application/rss+xml"}}]`, "must be lower case and start with a dot"},
+		{"contact form", `[{"name": "a", "dir": "d", "index-doc": "i", "error-doc": "e", "contact-form": {"sender": "form@example.com"}}]`, "at least one recipient"},
+		{"contact form mail from", `[{"name": "a", "dir": "d", "index-doc": "i", "error-doc": "e", "contact-form": {"recipients": ["o@example.com"], "sender": "form@example.com", "mail-from": "mail.example.org"}}]`, "must be a subdomain of the sender domain"},
+		{"duplicate", `[{"name": "a", "dir": "d", "index-doc": "i", "error-doc": "e"}, {"name": "a", "dir": "d", "index-doc": "i", "error-doc": "e"}]`, "duplicate site name a"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := parseSites(test.raw)
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("error = %v, want %q", err, test.want)
+			}
+		})
+	}
+}
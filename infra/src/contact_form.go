@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/mail"
+	"strings"
+	"text/template"
+)
+
+// Subject of form mails of sites without their own template
+const defaultSubjectTemplate = "Message from {{.Name}}"
+
+// contactForm is the optional contact form of a site, submissions are mailed
+// through SES from the sender to the recipients
+type contactForm struct {
+	Recipients []string `json:"recipients"`
+	Sender     string   `json:"sender"`
+	// text/template of the mail subject, fields are .Name, .Phone, .Email and .Message
+	Subject string `json:"subject"`
+	// https origins allowed to post the form, defaults to all hosts of the site
+	AllowedOrigins []string `json:"allowed-origins"`
+	// Custom MAIL FROM subdomain of the sender domain, defaults to mail.<sender domain>
+	MailFrom string `json:"mail-from"`
+	// DMARC record of the sender domain, defaults to a monitoring only policy
+	Dmarc string `json:"dmarc"`
+	// Limits of submissions per client and of requests to the form route
+	RateLimit *formRateLimit `json:"rate-limit"`
+	// Seconds a person needs at least to fill in the form, 0 disables the check
+	MinFillSeconds *int `json:"min-fill-seconds"`
+	// Optional CAPTCHA verification of submissions
+	Captcha *formCaptcha `json:"captcha"`
+	// Look of the notification and auto-reply mails
+	Branding *formBranding `json:"branding"`
+	// Sends a confirmation in Czech to customers who filled in their email
+	AutoReply bool `json:"auto-reply"`
+}
+
+// contactFormFields are the submitted fields available to the subject template,
+// they mirror the form request of the send mail handler
+type contactFormFields struct {
+	Name    string
+	Phone   string
+	Email   string
+	Message string
+}
+
+func (form *contactForm) validate() error {
+	if form == nil {
+		return nil
+	}
+	if len(form.Recipients) == 0 {
+		return fmt.Errorf("contact-form: at least one recipient is required")
+	}
+	for _, recipient := range form.Recipients {
+		if _, err := mail.ParseAddress(recipient); err != nil {
+			return fmt.Errorf("contact-form: invalid recipient %q: %w", recipient, err)
+		}
+	}
+	if _, err := mail.ParseAddress(form.Sender); err != nil {
+		return fmt.Errorf("contact-form: invalid sender %q: %w", form.Sender, err)
+	}
+	if _, err := form.subjectTemplate(); err != nil {
+		return fmt.Errorf("contact-form: invalid subject: %w", err)
+	}
+	if mailFrom := form.mailFrom(); !strings.HasSuffix(mailFrom, "."+form.senderDomain()) {
+		return fmt.Errorf("contact-form: mail-from %q must be a subdomain of the sender domain %s", form.MailFrom, form.senderDomain())
+	}
+	if !strings.HasPrefix(form.dmarc(), "v=DMARC1;") {
+		return fmt.Errorf("contact-form: dmarc record must start with v=DMARC1;")
+	}
+	if form.MinFillSeconds != nil && *form.MinFillSeconds < 0 {
+		return fmt.Errorf("contact-form: min-fill-seconds must not be negative")
+	}
+	if err := form.RateLimit.validate(); err != nil {
+		return err
+	}
+	if err := form.Captcha.validate(); err != nil {
+		return err
+	}
+	if err := form.Branding.validate(); err != nil {
+		return err
+	}
+	for _, origin := range form.AllowedOrigins {
+		if !formOriginPattern.MatchString(strings.TrimSuffix(origin, "/")) {
+			return fmt.Errorf("contact-form: invalid allowed origin %q, must be https://host in lower case", origin)
+		}
+	}
+	return nil
+}
+
+func (form *contactForm) subject() string {
+	if form.Subject == "" {
+		return defaultSubjectTemplate
+	}
+	return form.Subject
+}
+
+func (form *contactForm) mailFrom() string {
+	if form.MailFrom == "" {
+		return fmt.Sprintf("mail.%s", form.senderDomain())
+	}
+	return normalizeHost(form.MailFrom)
+}
+
+func (form *contactForm) dmarc() string {
+	if form.Dmarc == "" {
+		return defaultDmarcPolicy
+	}
+	return form.Dmarc
+}
+
+func (form *contactForm) subjectTemplate() (*template.Template, error) {
+	// Template is executed once, so unknown fields fail the config instead of a submission
+	tmpl, err := template.New("subject").Option("missingkey=error").Parse(form.subject())
+	if err != nil {
+		return nil, err
+	}
+	return tmpl, tmpl.Execute(io.Discard, contactFormFields{})
+}
+
+func (form *contactForm) senderDomain() string {
+	// Domain of the SES identity the form mails are sent from
+	address, err := mail.ParseAddress(form.Sender)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(address.Address[strings.LastIndex(address.Address, "@")+1:])
+}
+
+func (form *contactForm) allowedOrigins(domains []siteDomain) ([]string, error) {
+	// Explicit origins win, otherwise every host of the site may post the form
+	if len(form.AllowedOrigins) > 0 {
+		origins := make([]string, len(form.AllowedOrigins))
+		for i, origin := range form.AllowedOrigins {
+			origins[i] = strings.TrimSuffix(origin, "/")
+		}
+		return origins, nil
+	}
+	if len(domains) == 0 {
+		return nil, fmt.Errorf("contact-form: allowed-origins are required for sites without a domain")
+	}
+	origins := make([]string, len(domains))
+	for i, domain := range domains {
+		origins[i] = fmt.Sprintf("https://%s", domain.host)
+	}
+	return origins, nil
+}
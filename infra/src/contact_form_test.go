@@ -0,0 +1,77 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestContactFormValidate(t *testing.T) {
+	valid := contactForm{
+		Recipients:     []string{"orders@example.com", "Owner <owner@example.com>"},
+		Sender:         "form@example.com",
+		Subject:        "Order from {{.Name}} ({{.Phone}}, {{.Email}})",
+		AllowedOrigins: []string{"https://example.com", "https://localhost:1234/"},
+	}
+	if err := valid.validate(); err != nil {
+		t.Fatalf("valid form rejected: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		edit func(form *contactForm)
+		err  string
+	}{
+		{"no recipients", func(form *contactForm) { form.Recipients = nil }, "at least one recipient"},
+		{"invalid recipient", func(form *contactForm) { form.Recipients = []string{"orders"} }, "invalid recipient"},
+		{"invalid sender", func(form *contactForm) { form.Sender = "" }, "invalid sender"},
+		{"subject syntax", func(form *contactForm) { form.Subject = "{{.Name" }, "invalid subject"},
+		{"subject field", func(form *contactForm) { form.Subject = "{{.Address}}" }, "invalid subject"},
+		{"origin path", func(form *contactForm) { form.AllowedOrigins = []string{"https://example.com/form"} }, "invalid allowed origin"},
+		{"origin scheme", func(form *contactForm) { form.AllowedOrigins = []string{"example.com"} }, "invalid allowed origin"},
+		{"origin http", func(form *contactForm) { form.AllowedOrigins = []string{"http://example.com"} }, "invalid allowed origin"},
+		{"origin case", func(form *contactForm) { form.AllowedOrigins = []string{"https://Example.com"} }, "invalid allowed origin"},
+		{"origin quote", func(form *contactForm) { form.AllowedOrigins = []string{"https://example.com'"} }, "invalid allowed origin"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			form := valid
+			test.edit(&form)
+			if err := form.validate(); err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("validate() = %v, want error containing %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestContactFormAllowedOrigins(t *testing.T) {
+	domains, err := getSiteDomains(testProject("example.com", "www.example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	form := contactForm{}
+	origins, err := form.allowedOrigins(domains)
+	if err != nil || !reflect.DeepEqual(origins, []string{"https://example.com", "https://www.example.com"}) {
+		t.Errorf("default origins = %v, %v", origins, err)
+	}
+
+	form.AllowedOrigins = []string{"https://shop.example.com/"}
+	if origins, _ := form.allowedOrigins(domains); !reflect.DeepEqual(origins, []string{"https://shop.example.com"}) {
+		t.Errorf("explicit origins = %v", origins)
+	}
+
+	if _, err := (&contactForm{}).allowedOrigins(nil); err == nil {
+		t.Error("site without domain requires explicit origins")
+	}
+}
+
+func TestContactFormSenderDomain(t *testing.T) {
+	form := contactForm{Sender: "Web Form <form@Example.COM>"}
+	if got := form.senderDomain(); got != "example.com" {
+		t.Errorf("sender domain = %s", got)
+	}
+	if got := (&contactForm{}).subject(); got != defaultSubjectTemplate {
+		t.Errorf("default subject = %s", got)
+	}
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/s3"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+)
+
+const (
+	// Content is uploaded either as one BucketObject resource per file or
+	// synced by the siteContent component based on a content manifest.
+	// The manifest is tracked in both modes to know which paths changed,
+	// it records the mode it was written in
+	contentSyncObjects  = "objects"
+	contentSyncManifest = "manifest"
+
+	// Key of the manifest of the last synced content in the content bucket
+	manifestKey = ".deploy/manifest.json"
+)
+
+// manifestEntry describes a single object of the synced content
+type manifestEntry struct {
+	SHA256          string `json:"sha256"`
+	Size            int64  `json:"size"`
+	ContentType     string `json:"content-type"`
+	CacheControl    string `json:"cache-control,omitempty"`
+	ContentEncoding string `json:"content-encoding,omitempty"`
+
+	localPath string
+}
+
+func (e manifestEntry) withoutLocalPath() manifestEntry {
+	// Local path is not part of the stored manifest, so it is ignored in comparisons
+	e.localPath = ""
+	return e
+}
+
+// contentManifest maps bucket keys to the content stored under them
+type contentManifest map[string]manifestEntry
+
+// manifestDiff lists keys which have to be changed to get from one manifest to another
+type manifestDiff struct {
+	upload []string // new or changed keys
+	remove []string // keys no longer present
+}
+
+func (d manifestDiff) empty() bool {
+	return len(d.upload) == 0 && len(d.remove) == 0
+}
+
+func (d manifestDiff) changedPaths() []string {
+	// URL paths whose cached responses are outdated, index documents are
+	// cached under their directory path as well. Pre-compressed variants are
+	// cached under the path of the original object
+	unique := make(map[string]bool)
+	for _, key := range append(append([]string{}, d.upload...), d.remove...) {
+		if source, ok := variantSource(key); ok {
+			key = source
+		}
+		unique["/"+key] = true
+		if dir, file := filepath.Split(key); file == "index.html" {
+			unique["/"+dir] = true
+		}
+	}
+	paths := make([]string, 0, len(unique))
+	for path := range unique {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// contentStore is the bucket the site content is synced to
+type contentStore interface {
+	// Mode is empty for manifests written before the mode was recorded
+	getManifest(ctx context.Context) (manifest contentManifest, mode string, err error)
+	putObject(ctx context.Context, key string, entry manifestEntry) error
+	deleteObjects(ctx context.Context, keys []string) error
+	putManifest(ctx context.Context, manifest contentManifest, mode string) error
+}
+
+// openContentStore opens the store of the given bucket, replaced in tests
+var openContentStore = newS3ContentStore
+
+func buildContentManifest(dir string, settings objectSettings) (contentManifest, error) {
+	// Computes the manifest of all regular files in the directory, keys are
+	// slash separated paths relative to the directory
+	manifest := make(contentManifest)
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.Type().IsRegular() {
+			return err
+		}
+		relPath, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(relPath)
+		contentType, _ := settings.contentTypes.contentType(path)
+		digest, size, err := fileDigest(path)
+		if err != nil {
+			return err
+		}
+		original := manifestEntry{
+			SHA256:       digest,
+			Size:         size,
+			ContentType:  contentType,
+			CacheControl: settings.cacheControl.cacheControl(key),
+			localPath:    path,
+		}
+		manifest[key] = original
+		if settings.precompressDir == "" {
+			return nil
+		}
+
+		variants, err := compressFile(path, relPath, settings.precompressDir)
+		if err != nil {
+			return err
+		}
+		for _, variant := range variants {
+			digest, size, err := fileDigest(variant.localPath)
+			if err != nil {
+				return err
+			}
+			entry := original
+			entry.SHA256, entry.Size, entry.ContentEncoding, entry.localPath = digest, size, variant.encoding, variant.localPath
+			manifest[key+variant.suffix] = entry
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("building content manifest of %s: %w", dir, err)
+	}
+	return manifest, nil
+}
+
+func fileDigest(path string) (string, int64, error) {
+	// Returns hex encoded sha256 and size of the file
+	file, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), size, nil
+}
+
+func (m contentManifest) hash() string {
+	// Manifest is serialized with sorted keys, so the hash is stable
+	encoded, _ := json.Marshal(m)
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:])
+}
+
+func diffManifests(previous contentManifest, next contentManifest) manifestDiff {
+	diff := manifestDiff{upload: []string{}, remove: []string{}}
+	for key, entry := range next {
+		if prev, ok := previous[key]; !ok || prev.withoutLocalPath() != entry.withoutLocalPath() {
+			diff.upload = append(diff.upload, key)
+		}
+	}
+	for key := range previous {
+		if _, ok := next[key]; !ok {
+			diff.remove = append(diff.remove, key)
+		}
+	}
+	sort.Strings(diff.upload)
+	sort.Strings(diff.remove)
+	return diff
+}
+
+func syncContent(ctx context.Context, store contentStore, manifest contentManifest, dryRun bool, mode string) (manifestDiff, error) {
+	// Brings the bucket to the manifest and stores it. With manifest sync the
+	// changed objects are uploaded, with objects sync they are uploaded as
+	// resources and only the removed ones are deleted, as the resources are
+	// retained on delete. In dry run only the planned changes are computed
+	previous, previousMode, err := store.getManifest(ctx)
+	if err != nil {
+		return manifestDiff{}, fmt.Errorf("reading previous manifest: %w", err)
+	}
+	if mode == contentSyncManifest && previousMode == "" && len(previous) > 0 {
+		// Object resources of older deployments are not retained, Pulumi
+		// deletes them at the end of the update, after the sync
+		return manifestDiff{}, fmt.Errorf("content was uploaded as objects which are not retained on delete, deploy once with content-sync %s before switching to %s", contentSyncObjects, contentSyncManifest)
+	}
+	diff := diffManifests(previous, manifest)
+	if mode == contentSyncManifest && previousMode != mode {
+		// Objects uploaded by another mode are uploaded again, so the bucket
+		// matches the manifest whatever the resources left in it
+		diff.upload = diffManifests(contentManifest{}, manifest).upload
+	}
+	log.Printf("Content sync: %d objects changed, %d removed\n", len(diff.upload), len(diff.remove))
+	if dryRun || (diff.empty() && previousMode == mode) {
+		return diff, nil
+	}
+
+	if mode == contentSyncManifest {
+		for _, key := range diff.upload {
+			if err := store.putObject(ctx, key, manifest[key]); err != nil {
+				return diff, fmt.Errorf("uploading %s: %w", key, err)
+			}
+		}
+	}
+	if len(diff.remove) > 0 {
+		if err := store.deleteObjects(ctx, diff.remove); err != nil {
+			return diff, fmt.Errorf("deleting removed objects: %w", err)
+		}
+	}
+	// Manifest is written last, so a failed sync is retried on the next deploy
+	if err := store.putManifest(ctx, manifest, mode); err != nil {
+		return diff, fmt.Errorf("writing manifest: %w", err)
+	}
+	return diff, nil
+}
+
+// siteContent tracks the content manifest of a site in its bucket. With
+// manifest sync it also uploads the changed files, without registering
+// a Pulumi resource per file
+type siteContent struct {
+	pulumi.ResourceState
+
+	ManifestHash pulumi.StringOutput      `pulumi:"manifestHash"`
+	ChangedPaths pulumi.StringArrayOutput `pulumi:"changedPaths"`
+}
+
+func newSiteContent(
+	ctx *pulumi.Context,
+	project staticSiteProject,
+	bucket *s3.Bucket,
+	accessBlock *s3.BucketPublicAccessBlock,
+	settings objectSettings,
+	objects []*s3.BucketObject,
+	opts ...pulumi.ResourceOption) (*siteContent, error) {
+	content := &siteContent{}
+	name := fmt.Sprintf("%s-content", project.name)
+	if err := ctx.RegisterComponentResource("www-infra:index:SiteContent", name, content, opts...); err != nil {
+		return nil, resourceErr(name, err)
+	}
+
+	manifest, err := buildContentManifest(project.dir, settings)
+	if err != nil {
+		return nil, resourceErr(name, err)
+	}
+	log.Printf("Content manifest of %s: %d files, hash %s\n", project.name, len(manifest), manifest.hash())
+
+	// Objects are synced once the bucket access settings are in place,
+	// manifest of objects uploaded as resources is recorded after them
+	dependencies := []interface{}{bucket.Bucket, accessBlock.ID()}
+	for _, object := range objects {
+		dependencies = append(dependencies, object.ID())
+	}
+
+	region := config.Get(ctx, "aws:region")
+	manifestHash := manifest.hash()
+	content.ChangedPaths = pulumi.All(dependencies...).ApplyTWithContext(ctx.Context(), func(goCtx context.Context, args []interface{}) ([]string, error) {
+		bucketName := args[0].(string)
+		store, err := openContentStore(goCtx, region, bucketName, settings.acl)
+		if err != nil {
+			return nil, err
+		}
+		diff, err := syncContent(goCtx, store, manifest, ctx.DryRun(), project.contentSync)
+		if err != nil {
+			return nil, fmt.Errorf("syncing content of %s: %w", project.name, err)
+		}
+		return diff.changedPaths(), nil
+	}).(pulumi.StringArrayOutput)
+	content.ManifestHash = content.ChangedPaths.ApplyT(func(_ []string) string {
+		return manifestHash
+	}).(pulumi.StringOutput)
+
+	if err := ctx.RegisterResourceOutputs(content, pulumi.Map{
+		"manifestHash": content.ManifestHash,
+		"changedPaths": content.ChangedPaths,
+	}); err != nil {
+		return nil, resourceErr(name, err)
+	}
+	return content, nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const (
+	// S3 accepts at most 1000 keys in a single DeleteObjects request
+	maxDeleteBatch = 1000
+	// Metadata of the manifest object with the sync mode it was written in
+	manifestModeMetadata = "content-sync"
+)
+
+// s3ContentStore is a contentStore backed by an S3 bucket
+type s3ContentStore struct {
+	client *awss3.Client
+	bucket string
+	acl    string
+}
+
+func newS3ContentStore(ctx context.Context, region string, bucket string, acl string) (contentStore, error) {
+	cfg, err := awsconfig.LoadDefaultConfig(ctx, awsconfig.WithRegion(region))
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS config: %w", err)
+	}
+	return &s3ContentStore{client: awss3.NewFromConfig(cfg), bucket: bucket, acl: acl}, nil
+}
+
+func (s *s3ContentStore) getManifest(ctx context.Context) (contentManifest, string, error) {
+	// Bucket without manifest was never synced, so all content is new
+	output, err := s.client.GetObject(ctx, &awss3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(manifestKey),
+	})
+	var noSuchKey *types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		return contentManifest{}, "", nil
+	} else if err != nil {
+		return nil, "", err
+	}
+	defer output.Body.Close()
+
+	manifest := contentManifest{}
+	if err := json.NewDecoder(output.Body).Decode(&manifest); err != nil {
+		return nil, "", fmt.Errorf("decoding %s: %w", manifestKey, err)
+	}
+	return manifest, output.Metadata[manifestModeMetadata], nil
+}
+
+func (s *s3ContentStore) putObject(ctx context.Context, key string, entry manifestEntry) error {
+	file, err := os.Open(entry.localPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	input := &awss3.PutObjectInput{
+		Bucket:        aws.String(s.bucket),
+		Key:           aws.String(key),
+		Body:          file,
+		ContentLength: aws.Int64(entry.Size),
+		ContentType:   aws.String(entry.ContentType),
+	}
+	if entry.CacheControl != "" {
+		input.CacheControl = aws.String(entry.CacheControl)
+	}
+	if entry.ContentEncoding != "" {
+		input.ContentEncoding = aws.String(entry.ContentEncoding)
+	}
+	if s.acl != "" {
+		input.ACL = types.ObjectCannedACL(s.acl)
+	}
+	_, err = s.client.PutObject(ctx, input)
+	return err
+}
+
+func (s *s3ContentStore) deleteObjects(ctx context.Context, keys []string) error {
+	for start := 0; start < len(keys); start += maxDeleteBatch {
+		end := min(start+maxDeleteBatch, len(keys))
+		objects := make([]types.ObjectIdentifier, 0, end-start)
+		for _, key := range keys[start:end] {
+			objects = append(objects, types.ObjectIdentifier{Key: aws.String(key)})
+		}
+		output, err := s.client.DeleteObjects(ctx, &awss3.DeleteObjectsInput{
+			Bucket: aws.String(s.bucket),
+			Delete: &types.Delete{Objects: objects, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			return err
+		}
+		if len(output.Errors) > 0 {
+			failed := output.Errors[0]
+			return fmt.Errorf("deleting %s: %s", aws.ToString(failed.Key), aws.ToString(failed.Message))
+		}
+	}
+	return nil
+}
+
+func (s *s3ContentStore) putManifest(ctx context.Context, manifest contentManifest, mode string) error {
+	encoded, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.PutObject(ctx, &awss3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(manifestKey),
+		Body:        bytes.NewReader(encoded),
+		ContentType: aws.String("application/json"),
+		Metadata:    map[string]string{manifestModeMetadata: mode},
+	})
+	return err
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// memoryContentStore is an in-memory contentStore used instead of S3
+type memoryContentStore struct {
+	bucket   string
+	acl      string
+	manifest contentManifest
+	mode     string
+	objects  map[string]manifestEntry
+	uploads  []string
+	deletes  []string
+}
+
+func newMemoryContentStore() *memoryContentStore {
+	return &memoryContentStore{manifest: contentManifest{}, objects: map[string]manifestEntry{}}
+}
+
+func (s *memoryContentStore) getManifest(ctx context.Context) (contentManifest, string, error) {
+	return s.manifest, s.mode, nil
+}
+
+func (s *memoryContentStore) putObject(ctx context.Context, key string, entry manifestEntry) error {
+	s.objects[key] = entry
+	s.uploads = append(s.uploads, key)
+	return nil
+}
+
+func (s *memoryContentStore) deleteObjects(ctx context.Context, keys []string) error {
+	for _, key := range keys {
+		delete(s.objects, key)
+	}
+	s.deletes = append(s.deletes, keys...)
+	return nil
+}
+
+func (s *memoryContentStore) putManifest(ctx context.Context, manifest contentManifest, mode string) error {
+	s.manifest, s.mode = manifest, mode
+	return nil
+}
+
+func useMemoryContentStore(t *testing.T) *memoryContentStore {
+	// Replaces the S3 content store for the duration of the test
+	t.Helper()
+	store := newMemoryContentStore()
+	original := openContentStore
+	openContentStore = func(ctx context.Context, region string, bucket string, acl string) (contentStore, error) {
+		store.bucket, store.acl = bucket, acl
+		return store, nil
+	}
+	t.Cleanup(func() { openContentStore = original })
+	return store
+}
+
+func copyDir(t *testing.T, src string) string {
+	// Copies the directory to a temporary one, so tests can modify its content
+	t.Helper()
+	dst := t.TempDir()
+	err := filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, _ := filepath.Rel(src, path)
+		if info.IsDir() {
+			return os.MkdirAll(filepath.Join(dst, relPath), 0o755)
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(filepath.Join(dst, relPath), content, 0o644)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dst
+}
+
+func manifestKeys(manifest contentManifest) []string {
+	keys := make([]string, 0, len(manifest))
+	for key := range manifest {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestBuildContentManifest(t *testing.T) {
+	manifest, err := buildContentManifest(testSiteDir, objectSettings{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := manifestKeys(manifest); !reflect.DeepEqual(got, []string{"error.html", "images/logo.svg", "index.html"}) {
+		t.Fatalf("manifest keys = %v", got)
+	}
+	index := manifest["index.html"]
+	content, _ := os.ReadFile(filepath.Join(testSiteDir, "index.html"))
+	if index.Size != int64(len(content)) || len(index.SHA256) != 64 || index.ContentType != "text/html; charset=utf-8" {
+		t.Errorf("unexpected index entry %+v", index)
+	}
+
+	again, err := buildContentManifest(testSiteDir, objectSettings{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.hash() != again.hash() {
+		t.Error("manifest hash is not stable")
+	}
+}
+
+func TestDiffManifests(t *testing.T) {
+	previous := contentManifest{
+		"index.html":  {SHA256: "a", Size: 1, ContentType: "text/html"},
+		"old.html":    {SHA256: "b", Size: 1, ContentType: "text/html"},
+		"style.css":   {SHA256: "c", Size: 1, ContentType: "text/css"},
+		"feed.xml":    {SHA256: "d", Size: 1, ContentType: "text/xml"},
+		"same.js":     {SHA256: "e", Size: 1, ContentType: "text/javascript"},
+		"renamed.svg": {SHA256: "f", Size: 1, ContentType: "image/svg+xml"},
+	}
+	next := contentManifest{
+		"index.html": {SHA256: "a2", Size: 1, ContentType: "text/html"},
+		"style.css":  {SHA256: "c", Size: 1, ContentType: "text/css"},
+		"feed.xml":   {SHA256: "d", Size: 1, ContentType: "application/rss+xml"},
+		"same.js":    {SHA256: "e", Size: 1, ContentType: "text/javascript"},
+		"logo.svg":   {SHA256: "f", Size: 1, ContentType: "image/svg+xml"},
+	}
+	diff := diffManifests(previous, next)
+	if want := []string{"feed.xml", "index.html", "logo.svg"}; !reflect.DeepEqual(diff.upload, want) {
+		t.Errorf("upload = %v, want %v", diff.upload, want)
+	}
+	if want := []string{"old.html", "renamed.svg"}; !reflect.DeepEqual(diff.remove, want) {
+		t.Errorf("remove = %v, want %v", diff.remove, want)
+	}
+}
+
+func TestSyncContent(t *testing.T) {
+	dir := copyDir(t, testSiteDir)
+	store := newMemoryContentStore()
+	ctx := context.Background()
+
+	sync := func(dryRun bool) manifestDiff {
+		t.Helper()
+		manifest, err := buildContentManifest(dir, objectSettings{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		diff, err := syncContent(ctx, store, manifest, dryRun, contentSyncManifest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return diff
+	}
+
+	if diff := sync(false); len(diff.upload) != 3 {
+		t.Fatalf("first sync uploads = %v, want all files", diff.upload)
+	}
+	if got := manifestKeys(store.manifest); len(got) != 3 {
+		t.Fatalf("stored manifest = %v", got)
+	}
+
+	store.uploads = nil
+	if diff := sync(false); !diff.empty() || len(store.uploads) != 0 {
+		t.Fatalf("unchanged content was synced again: %+v", diff)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "index.html"), []byte("<html>changed</html>"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, "error.html")); err != nil {
+		t.Fatal(err)
+	}
+	if diff := sync(true); !reflect.DeepEqual(diff.upload, []string{"index.html"}) || len(store.uploads) != 0 {
+		t.Fatalf("dry run must only plan changes: %+v, uploads %v", diff, store.uploads)
+	}
+	diff := sync(false)
+	if !reflect.DeepEqual(store.uploads, []string{"index.html"}) || !reflect.DeepEqual(store.deletes, []string{"error.html"}) {
+		t.Errorf("uploads = %v, deletes = %v", store.uploads, store.deletes)
+	}
+	if !reflect.DeepEqual(diff.remove, []string{"error.html"}) {
+		t.Errorf("diff = %+v", diff)
+	}
+	if _, ok := store.objects["error.html"]; ok {
+		t.Error("removed file is still in the store")
+	}
+}
+
+func TestSyncContentRecordOnly(t *testing.T) {
+	store := newMemoryContentStore()
+	store.manifest, store.mode = contentManifest{"old.html": {SHA256: "a", Size: 1}}, contentSyncObjects
+	manifest, err := buildContentManifest(testSiteDir, objectSettings{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diff, err := syncContent(context.Background(), store, manifest, false, contentSyncObjects)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The resources of removed files are retained, so the sync deletes them
+	if len(store.uploads) != 0 || !reflect.DeepEqual(store.deletes, []string{"old.html"}) {
+		t.Errorf("objects managed as resources were synced: uploads %v, deletes %v", store.uploads, store.deletes)
+	}
+	if store.manifest.hash() != manifest.hash() || store.mode != contentSyncObjects {
+		t.Errorf("manifest was not recorded, mode %q", store.mode)
+	}
+	want := []string{"/", "/error.html", "/images/logo.svg", "/index.html", "/old.html"}
+	if got := diff.changedPaths(); !reflect.DeepEqual(got, want) {
+		t.Errorf("changed paths = %v, want %v", got, want)
+	}
+}
+
+func TestSyncContentModeSwitch(t *testing.T) {
+	manifest, err := buildContentManifest(testSiteDir, objectSettings{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := newMemoryContentStore()
+	if _, err := syncContent(context.Background(), store, manifest, false, contentSyncObjects); err != nil {
+		t.Fatal(err)
+	}
+
+	// The objects are uploaded again even though the manifest is the same,
+	// the store does not know what the retained resources left in the bucket
+	diff, err := syncContent(context.Background(), store, manifest, false, contentSyncManifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := manifestKeys(manifest); !reflect.DeepEqual(diff.upload, want) || !reflect.DeepEqual(store.uploads, want) {
+		t.Errorf("switch to manifest sync uploads %v, diff %+v, want %v", store.uploads, diff, want)
+	}
+	if store.mode != contentSyncManifest {
+		t.Errorf("recorded mode = %q", store.mode)
+	}
+	store.uploads = nil
+	if diff, err := syncContent(context.Background(), store, manifest, false, contentSyncManifest); err != nil || !diff.empty() || len(store.uploads) != 0 {
+		t.Errorf("unchanged content after the switch = %+v, %v, uploads %v", diff, err, store.uploads)
+	}
+
+	// Manifests of deployments which did not retain the objects
+	legacy := newMemoryContentStore()
+	legacy.manifest = manifest
+	if _, err := syncContent(context.Background(), legacy, manifest, true, contentSyncManifest); err == nil || !strings.Contains(err.Error(), "deploy once with content-sync objects") {
+		t.Errorf("switch from objects which are not retained = %v", err)
+	}
+	if _, err := syncContent(context.Background(), legacy, manifest, false, contentSyncObjects); err != nil || legacy.mode != contentSyncObjects {
+		t.Errorf("objects sync of a legacy manifest = %v, mode %q", err, legacy.mode)
+	}
+}
+
+func TestDeployProjectManifestSync(t *testing.T) {
+	store := useMemoryContentStore(t)
+	project := testProject("example.com", "www.example.com")
+	project.contentSync = contentSyncManifest
+
+	mocks, err := runWithMocks(t, "test", nil, deployTestProject(project))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mocks.find(t, "www-infra:index:SiteContent", "test-site-content")
+	if objects := mocks.byType("aws:s3/bucketObject:BucketObject"); len(objects) != 0 {
+		t.Errorf("manifest sync must not register bucket objects, got %s", names(objects))
+	}
+	if store.bucket != "test-site-bucket" || store.acl != "public-read" {
+		t.Errorf("store opened for bucket %q with acl %q", store.bucket, store.acl)
+	}
+	if got := manifestKeys(store.manifest); !reflect.DeepEqual(got, []string{"error.html", "images/logo.svg", "index.html"}) {
+		t.Errorf("synced manifest = %v", got)
+	}
+}
+
+func TestDeployProjectContentSyncSwitch(t *testing.T) {
+	// Objects sync registers retained resources and records the manifest,
+	// switching to manifest sync uploads the content the resources leave
+	store := useMemoryContentStore(t)
+	project := testProject("example.com", "www.example.com")
+	mocks, err := runWithMocks(t, "test", nil, deployTestProject(project))
+	if err != nil {
+		t.Fatal(err)
+	}
+	objects := mocks.byType("aws:s3/bucketObject:BucketObject")
+	if len(objects) == 0 {
+		t.Fatal("objects sync registered no bucket objects")
+	}
+	for _, object := range objects {
+		if !object.RetainOnDelete {
+			t.Errorf("bucket object %s is not retained on delete", object.Name)
+		}
+	}
+	if len(store.uploads) != 0 || store.mode != contentSyncObjects || len(store.manifest) != 3 {
+		t.Fatalf("objects sync uploads %v, recorded %q manifest %v", store.uploads, store.mode, manifestKeys(store.manifest))
+	}
+
+	project.contentSync = contentSyncManifest
+	if _, err := runWithMocks(t, "test", nil, deployTestProject(project)); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"error.html", "images/logo.svg", "index.html"}; !reflect.DeepEqual(store.uploads, want) || store.mode != contentSyncManifest {
+		t.Errorf("switch to manifest sync uploads %v, recorded %q", store.uploads, store.mode)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// Content type of files which could not be determined, S3 serves the same by default
+const unknownContentType = "application/octet-stream"
+
+// defaultContentTypes is the built-in extension table, so the content types do
+// not depend on the mime.types of the host running the deploy
+var defaultContentTypes = contentTypes{
+	// Documents
+	".html": "text/html; charset=utf-8",
+	".htm":  "text/html; charset=utf-8",
+	".txt":  "text/plain; charset=utf-8",
+	".xml":  "text/xml; charset=utf-8",
+	".pdf":  "application/pdf",
+	// Scripts and styles
+	".css":         "text/css; charset=utf-8",
+	".js":          "text/javascript; charset=utf-8",
+	".mjs":         "text/javascript; charset=utf-8",
+	".map":         "application/json",
+	".json":        "application/json",
+	".webmanifest": "application/manifest+json",
+	".wasm":        "application/wasm",
+	// Images
+	".avif": "image/avif",
+	".gif":  "image/gif",
+	".ico":  "image/x-icon",
+	".jpeg": "image/jpeg",
+	".jpg":  "image/jpeg",
+	".png":  "image/png",
+	".svg":  "image/svg+xml",
+	".webp": "image/webp",
+	// Fonts
+	".eot":   "application/vnd.ms-fontobject",
+	".otf":   "font/otf",
+	".ttf":   "font/ttf",
+	".woff":  "font/woff",
+	".woff2": "font/woff2",
+	// Media
+	".mp4":  "video/mp4",
+	".webm": "video/webm",
+	".mp3":  "audio/mpeg",
+}
+
+// contentTypes maps lower case file extensions including the dot to content types
+type contentTypes map[string]string
+
+func (types contentTypes) validate() error {
+	for ext, contentType := range types {
+		if !strings.HasPrefix(ext, ".") || ext != strings.ToLower(ext) {
+			return fmt.Errorf("content-types: extension %q must be lower case and start with a dot", ext)
+		}
+		if _, _, err := mime.ParseMediaType(contentType); err != nil {
+			return fmt.Errorf("content-types: invalid content type %q of %s: %w", contentType, ext, err)
+		}
+	}
+	return nil
+}
+
+func (types contentTypes) lookup(localPath string) (string, bool) {
+	// Site overrides take precedence over the built-in table
+	ext := strings.ToLower(filepath.Ext(localPath))
+	if contentType, ok := types[ext]; ok {
+		return contentType, true
+	}
+	contentType, ok := defaultContentTypes[ext]
+	return contentType, ok
+}
+
+func sniffContentType(localPath string) (string, error) {
+	// Detects the content type from the first 512 bytes of the file
+	file, err := os.Open(localPath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	return http.DetectContentType(head[:n]), nil
+}
+
+func (types contentTypes) contentType(localPath string) (string, bool) {
+	// Content type of the file by its extension, sniffed from the content for
+	// unknown extensions. Reports false when the type could not be determined
+	if contentType, ok := types.lookup(localPath); ok {
+		return contentType, true
+	}
+	contentType, err := sniffContentType(localPath)
+	if err != nil || contentType == unknownContentType {
+		return unknownContentType, false
+	}
+	return contentType, true
+}
+
+func warnUndeterminedContentTypes(ctx *pulumi.Context, project staticSiteProject) error {
+	// Files of unknown type are uploaded as binary, the list is reported as
+	// a deployment warning so an override can be added to the site config
+	undetermined, err := undeterminedContentTypes(project.dir, project.contentTypes)
+	if err != nil {
+		return resourceErr(project.name, fmt.Errorf("detecting content types: %w", err))
+	}
+	if len(undetermined) == 0 {
+		return nil
+	}
+	log.Printf("Unknown content type of %d files of project %s\n", len(undetermined), project.name)
+	return ctx.Log.Warn(fmt.Sprintf("could not determine content type of %s files, uploading as %s: %s",
+		project.name, unknownContentType, strings.Join(undetermined, ", ")), nil)
+}
+
+func undeterminedContentTypes(dir string, types contentTypes) ([]string, error) {
+	// Lists files of the directory whose content type could not be determined
+	undetermined := make([]string, 0)
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.Type().IsRegular() {
+			return err
+		}
+		if _, ok := types.contentType(path); !ok {
+			relPath, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			undetermined = append(undetermined, filepath.ToSlash(relPath))
+		}
+		return nil
+	})
+	return undetermined, err
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	// Writes the files to a temporary directory
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestContentType(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"index.HTML":           "<p>hi</p>",
+		"site.webmanifest":     "{}",
+		"fonts/a.woff2":        "wOF2",
+		"photo.avif":           "",
+		"notes":                "plain text",
+		"data.bin":             "\x00\x01\x02\x03",
+		"feed.rss":             "<?xml version=\"1.0\"?><rss></rss>",
+		"override.webmanifest": "{}",
+	})
+	overrides := contentTypes{".rss": "application/rss+xml", ".webmanifest": "application/json"}
+
+	tests := []struct {
+		file        string
+		types       contentTypes
+		contentType string
+		ok          bool
+	}{
+		{"index.HTML", nil, "text/html; charset=utf-8", true},
+		{"site.webmanifest", nil, "application/manifest+json", true},
+		{"fonts/a.woff2", nil, "font/woff2", true},
+		{"photo.avif", nil, "image/avif", true},
+		{"notes", nil, "text/plain; charset=utf-8", true},
+		{"data.bin", nil, unknownContentType, false},
+		{"feed.rss", nil, "text/xml; charset=utf-8", true},
+		{"feed.rss", overrides, "application/rss+xml", true},
+		{"override.webmanifest", overrides, "application/json", true},
+	}
+	for _, test := range tests {
+		contentType, ok := test.types.contentType(filepath.Join(dir, test.file))
+		if contentType != test.contentType || ok != test.ok {
+			t.Errorf("contentType(%s) = %s, %v, want %s, %v", test.file, contentType, ok, test.contentType, test.ok)
+		}
+	}
+}
+
+func TestUndeterminedContentTypes(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"index.html":     "<p>hi</p>",
+		"assets/blob":    "\x00\x01",
+		"assets/app.dat": "\x00\x02",
+	})
+	undetermined, err := undeterminedContentTypes(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"assets/app.dat", "assets/blob"}; !reflect.DeepEqual(undetermined, want) {
+		t.Errorf("undetermined = %v, want %v", undetermined, want)
+	}
+
+	undetermined, err = undeterminedContentTypes(dir, contentTypes{".dat": "application/x-data"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"assets/blob"}; !reflect.DeepEqual(undetermined, want) {
+		t.Errorf("undetermined with override = %v, want %v", undetermined, want)
+	}
+}
+
+func TestContentTypesValidate(t *testing.T) {
+	valid := contentTypes{".rss": "application/rss+xml", ".txt": "text/plain; charset=utf-8"}
+	if err := valid.validate(); err != nil {
+		t.Errorf("valid content types rejected: %v", err)
+	}
+	for _, invalid := range []contentTypes{
+		{"rss": "application/rss+xml"},
+		{".RSS": "application/rss+xml"},
+		{".rss": "not a type"},
+	} {
+		if err := invalid.validate(); err == nil {
+			t.Errorf("content types %v accepted", invalid)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// siteDomain is a host name served by a site together with the Route53
+// hosted zone its records belong to
+type siteDomain struct {
+	host string
+	zone string
+}
+
+func (d siteDomain) recordName() string {
+	// Record name relative to the hosted zone, apex records use the zone name itself
+	// example: www.example.co.uk in zone example.co.uk -> www
+	// example: example.co.uk in zone example.co.uk -> example.co.uk
+	if d.host == d.zone {
+		return d.zone
+	}
+	return strings.TrimSuffix(d.host, "."+d.zone)
+}
+
+func normalizeHost(host string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(host)), ".")
+}
+
+func parseDomain(host string, hostedZone string) (siteDomain, error) {
+	// Resolves the hosted zone of the host. Without an explicit hosted zone
+	// the registrable domain (public suffix + one label) is used
+	host = normalizeHost(host)
+	if host == "" || strings.ContainsAny(host, " /:*") {
+		return siteDomain{}, fmt.Errorf("invalid domain %q", host)
+	}
+	for _, label := range strings.Split(host, ".") {
+		if label == "" || len(label) > 63 {
+			return siteDomain{}, fmt.Errorf("invalid domain %q: bad label %q", host, label)
+		}
+	}
+
+	registrable, err := publicsuffix.EffectiveTLDPlusOne(host)
+	if err != nil {
+		return siteDomain{}, fmt.Errorf("invalid domain %q: %w", host, err)
+	}
+
+	zone := registrable
+	if hostedZone != "" {
+		zone = normalizeHost(hostedZone)
+		if host != zone && !strings.HasSuffix(host, "."+zone) {
+			return siteDomain{}, fmt.Errorf("domain %q is not part of hosted zone %q", host, zone)
+		}
+		if zone != registrable && !strings.HasSuffix(zone, "."+registrable) {
+			return siteDomain{}, fmt.Errorf("hosted zone %q is above registrable domain %q", zone, registrable)
+		}
+	}
+	return siteDomain{host: host, zone: zone}, nil
+}
+
+func getSiteDomains(project staticSiteProject) ([]siteDomain, error) {
+	// Returns the primary domain of the site followed by its aliases,
+	// a site without domain is served only from the S3 website endpoint
+	if project.domain == "" {
+		if len(project.aliases) > 0 {
+			return nil, fmt.Errorf("aliases %v require a primary domain", project.aliases)
+		}
+		return []siteDomain{}, nil
+	}
+
+	hosts := append([]string{project.domain}, project.aliases...)
+	domains := make([]siteDomain, 0, len(hosts))
+	seen := make(map[string]bool, len(hosts))
+	for _, host := range hosts {
+		domain, err := parseDomain(host, project.hostedZone)
+		if err != nil {
+			return nil, err
+		}
+		if seen[domain.host] {
+			return nil, fmt.Errorf("domain %q is listed more than once", domain.host)
+		}
+		seen[domain.host] = true
+		domains = append(domains, domain)
+	}
+	return domains, nil
+}
+
+func domainHosts(domains []siteDomain) []string {
+	hosts := make([]string, len(domains))
+	for i, domain := range domains {
+		hosts[i] = domain.host
+	}
+	return hosts
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseDomain(t *testing.T) {
+	tests := []struct {
+		host       string
+		hostedZone string
+		want       siteDomain
+		recordName string
+	}{
+		{"example.com", "", siteDomain{"example.com", "example.com"}, "example.com"},
+		{"www.example.com", "", siteDomain{"www.example.com", "example.com"}, "www"},
+		{"WWW.Example.com.", "", siteDomain{"www.example.com", "example.com"}, "www"},
+		{"example.co.uk", "", siteDomain{"example.co.uk", "example.co.uk"}, "example.co.uk"},
+		{"www.example.co.uk", "", siteDomain{"www.example.co.uk", "example.co.uk"}, "www"},
+		{"shop.zahradnictvi-sramek.cz", "", siteDomain{"shop.zahradnictvi-sramek.cz", "zahradnictvi-sramek.cz"}, "shop"},
+		{"a.shop.example.cz", "shop.example.cz", siteDomain{"a.shop.example.cz", "shop.example.cz"}, "a"},
+		{"a.b.example.cz", "", siteDomain{"a.b.example.cz", "example.cz"}, "a.b"},
+	}
+	for _, test := range tests {
+		t.Run(test.host, func(t *testing.T) {
+			got, err := parseDomain(test.host, test.hostedZone)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("parseDomain = %+v, want %+v", got, test.want)
+			}
+			if got.recordName() != test.recordName {
+				t.Errorf("recordName = %s, want %s", got.recordName(), test.recordName)
+			}
+		})
+	}
+}
+
+func TestParseDomainErrors(t *testing.T) {
+	tests := []struct {
+		host       string
+		hostedZone string
+		want       string
+	}{
+		{"", "", "invalid domain"},
+		{"co.uk", "", "cannot derive eTLD+1"},
+		{"exa mple.com", "", "invalid domain"},
+		{"www..example.com", "", "bad label"},
+		{"www.example.com", "other.com", "is not part of hosted zone"},
+		{"www.example.com", "com", "is above registrable domain"},
+	}
+	for _, test := range tests {
+		t.Run(test.host, func(t *testing.T) {
+			_, err := parseDomain(test.host, test.hostedZone)
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("error = %v, want %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestGetSiteDomains(t *testing.T) {
+	domains, err := getSiteDomains(testProject("example.com", "www.example.com", "shop.example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := domainHosts(domains); !reflect.DeepEqual(got, []string{"example.com", "www.example.com", "shop.example.com"}) {
+		t.Errorf("hosts = %v", got)
+	}
+
+	if _, err := getSiteDomains(testProject("example.com", "EXAMPLE.com")); err == nil {
+		t.Error("expected error for duplicate domain")
+	}
+	if _, err := getSiteDomains(testProject("", "www.example.com")); err == nil {
+		t.Error("expected error for aliases without domain")
+	}
+}
@@ -0,0 +1,88 @@
+// Viewer request function of a site, it applies the redirects of the site,
+// redirects to the canonical host, normalizes the trailing slash and rewrites
+// directories of private origins to their index document. The settings are
+// filled in at deploy time
+var config = {{.}};
+
+function queryString(querystring) {
+    // Keys and values are kept encoded the way the viewer sent them
+    var parts = [];
+    for (var key in querystring) {
+        var param = querystring[key];
+        var values = param.multiValue ? param.multiValue.map(function (entry) { return entry.value; }) : [param.value];
+        for (var i = 0; i < values.length; i++) {
+            parts.push(values[i] === '' ? key : key + '=' + values[i]);
+        }
+    }
+    return parts.length > 0 ? '?' + parts.join('&') : '';
+}
+
+function normalizePath(uri) {
+    // Only paths of directories get a slash, files are recognized by their extension
+    var name = uri.substring(uri.lastIndexOf('/') + 1);
+    if (config.trailingSlash === 'add' && name !== '' && name.indexOf('.') === -1) {
+        return uri + '/';
+    }
+    if (config.trailingSlash === 'remove' && uri.length > 1 && name === '') {
+        return uri.replace(/\/+$/, '') || '/';
+    }
+    return uri;
+}
+
+function indexPath(uri) {
+    // Directories are paths ending with a slash, or without an extension when
+    // the slash is removed
+    if (!config.indexDoc) {
+        return uri;
+    }
+    if (uri.charAt(uri.length - 1) === '/') {
+        return uri + config.indexDoc;
+    }
+    var name = uri.substring(uri.lastIndexOf('/') + 1);
+    if (config.trailingSlash === 'remove' && name.indexOf('.') === -1) {
+        return uri + '/' + config.indexDoc;
+    }
+    return uri;
+}
+
+function redirectTarget(uri) {
+    // Exact paths win over prefixes, the prefixes are sorted longest first
+    var exact = config.redirects[uri];
+    if (exact) {
+        return exact;
+    }
+    for (var i = 0; i < config.prefixes.length; i++) {
+        var prefix = config.prefixes[i];
+        if (uri.startsWith(prefix.from)) {
+            var to = prefix.splat ? prefix.to + uri.substring(prefix.from.length) : prefix.to;
+            return { to: to, status: prefix.status };
+        }
+    }
+    return null;
+}
+
+function redirect(statusCode, location) {
+    return {
+        statusCode: statusCode,
+        statusDescription: statusCode === 302 ? 'Found' : 'Moved Permanently',
+        headers: {
+            location: { value: location },
+        },
+    };
+}
+
+function handler(event) {
+    var request = event.request;
+    var target = redirectTarget(request.uri);
+    if (target) {
+        var location = target.to.charAt(0) === '/' ? 'https://' + config.host + target.to : target.to;
+        return redirect(target.status, location + queryString(request.querystring));
+    }
+    var host = request.headers.host ? request.headers.host.value.toLowerCase() : config.host;
+    var uri = normalizePath(request.uri);
+    if (host === config.host && uri === request.uri) {
+        request.uri = indexPath(uri);
+        return request;
+    }
+    return redirect(301, 'https://' + config.host + uri + queryString(request.querystring));
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/mail"
+	"sort"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/cloudwatch"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/dynamodb"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/lambda"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/sns"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/sqs"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+const (
+	// DynamoDB table keeping every submission of the contact forms
+	submissionsTableName = "email-form-submissions"
+	// SQS queue of mails waiting to be sent
+	mailQueueName = "email-form-mail"
+	// Dead-letter queue of mails all attempts failed for
+	mailDeadLetterQueueName = "email-form-mail-dlq"
+	// Seconds a received mail stays hidden from other attempts, well above the sender run time
+	mailQueueVisibilityTimeout = 60
+	// Mails sent by one run of the queue sender
+	mailQueueBatchSize = 10
+	// Attempts to send a mail before it is moved to the dead-letter queue
+	mailMaxReceiveCount = 6
+	// Seconds failed mails are kept, the longest retention SQS allows
+	mailDeadLetterRetention = 14 * 24 * 60 * 60
+	// SNS topic notifying the form recipients about undelivered mails
+	emailFormAlertsTopicName = "email-form-alerts"
+)
+
+// emailFormBackend are the resources shared by the contact forms of all sites
+type emailFormBackend struct {
+	queueUrl pulumi.StringOutput
+}
+
+func submissionsTable(ctx *pulumi.Context) error {
+	// Submissions of a site are keyed by time sortable ids, so the export
+	// queries them by site and time. They are business records, so the table
+	// is protected from deletion and can be restored to any point of the last 35 days
+	log.Printf("emailForm - Setting submissions table %s\n", submissionsTableName)
+	_, err := dynamodb.NewTable(ctx, submissionsTableName, &dynamodb.TableArgs{
+		Name:        pulumi.String(submissionsTableName),
+		BillingMode: pulumi.String("PAY_PER_REQUEST"),
+		HashKey:     pulumi.String("site"),
+		RangeKey:    pulumi.String("id"),
+		Attributes: dynamodb.TableAttributeArray{
+			&dynamodb.TableAttributeArgs{Name: pulumi.String("site"), Type: pulumi.String("S")},
+			&dynamodb.TableAttributeArgs{Name: pulumi.String("id"), Type: pulumi.String("S")},
+		},
+		PointInTimeRecovery: &dynamodb.TablePointInTimeRecoveryArgs{
+			Enabled: pulumi.Bool(true),
+		},
+	}, pulumi.Protect(true))
+	return resourceErr(submissionsTableName, err)
+}
+
+func alertRecipients(sites []staticSiteProject) []string {
+	// Every recipient of a contact form is told about its undelivered mails
+	unique := make(map[string]bool)
+	for _, site := range sites {
+		if site.contactForm == nil {
+			continue
+		}
+		for _, recipient := range site.contactForm.Recipients {
+			if address, err := mail.ParseAddress(recipient); err == nil {
+				unique[address.Address] = true
+			}
+		}
+	}
+	recipients := make([]string, 0, len(unique))
+	for recipient := range unique {
+		recipients = append(recipients, recipient)
+	}
+	sort.Strings(recipients)
+	return recipients
+}
+
+func emailFormAlerts(ctx *pulumi.Context, sites []staticSiteProject) (*sns.Topic, error) {
+	// Email subscriptions are confirmed by the recipients from the first message
+	topic, err := sns.NewTopic(ctx, emailFormAlertsTopicName, &sns.TopicArgs{
+		Name: pulumi.String(emailFormAlertsTopicName),
+	})
+	if err != nil {
+		return nil, resourceErr(emailFormAlertsTopicName, err)
+	}
+	for _, recipient := range alertRecipients(sites) {
+		name := fmt.Sprintf("%s-%s", emailFormAlertsTopicName, recipient)
+		_, err := sns.NewTopicSubscription(ctx, name, &sns.TopicSubscriptionArgs{
+			Topic:    topic.Arn,
+			Protocol: pulumi.String("email"),
+			Endpoint: pulumi.String(recipient),
+		})
+		if err != nil {
+			return nil, resourceErr(name, err)
+		}
+	}
+	return topic, nil
+}
+
+func mailDeadLetterQueue(ctx *pulumi.Context, sites []staticSiteProject) (*sqs.Queue, error) {
+	// Alarms as soon as a mail lands in the queue, they are kept long
+	// enough to be redriven once the cause is fixed
+	log.Printf("emailForm - Setting dead-letter queue %s\n", mailDeadLetterQueueName)
+	queue, err := sqs.NewQueue(ctx, mailDeadLetterQueueName, &sqs.QueueArgs{
+		Name:                    pulumi.String(mailDeadLetterQueueName),
+		MessageRetentionSeconds: pulumi.Int(mailDeadLetterRetention),
+	})
+	if err != nil {
+		return nil, resourceErr(mailDeadLetterQueueName, err)
+	}
+
+	topic, err := emailFormAlerts(ctx, sites)
+	if err != nil {
+		return nil, err
+	}
+	alarmName := fmt.Sprintf("%s-alarm", mailDeadLetterQueueName)
+	_, err = cloudwatch.NewMetricAlarm(ctx, alarmName, &cloudwatch.MetricAlarmArgs{
+		Name:               pulumi.String(alarmName),
+		AlarmDescription:   pulumi.String("Contact form mails failed all attempts, see the dead-letter queue and the submissions export"),
+		Namespace:          pulumi.String("AWS/SQS"),
+		MetricName:         pulumi.String("ApproximateNumberOfMessagesVisible"),
+		Dimensions:         pulumi.StringMap{"QueueName": queue.Name},
+		Statistic:          pulumi.String("Maximum"),
+		Period:             pulumi.Int(300),
+		EvaluationPeriods:  pulumi.Int(1),
+		Threshold:          pulumi.Float64(0),
+		ComparisonOperator: pulumi.String("GreaterThanThreshold"),
+		TreatMissingData:   pulumi.String("notBreaching"),
+		AlarmActions:       pulumi.Array{topic.Arn},
+		OkActions:          pulumi.Array{topic.Arn},
+	})
+	if err != nil {
+		return nil, resourceErr(alarmName, err)
+	}
+	return queue, nil
+}
+
+func redrivePolicy(deadLetterArn string) (string, error) {
+	policy, err := json.Marshal(map[string]interface{}{
+		"deadLetterTargetArn": deadLetterArn,
+		"maxReceiveCount":     mailMaxReceiveCount,
+	})
+	return string(policy), err
+}
+
+func mailQueue(ctx *pulumi.Context, sites []staticSiteProject) (*sqs.Queue, error) {
+	// Form handlers queue the mails, the queue sender sends them and moves
+	// the ones failing all attempts to the dead-letter queue
+	deadLetterQueue, err := mailDeadLetterQueue(ctx, sites)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("emailForm - Setting mail queue %s\n", mailQueueName)
+	queue, err := sqs.NewQueue(ctx, mailQueueName, &sqs.QueueArgs{
+		Name:                     pulumi.String(mailQueueName),
+		VisibilityTimeoutSeconds: pulumi.Int(mailQueueVisibilityTimeout),
+		RedrivePolicy:            deadLetterQueue.Arn.ApplyT(redrivePolicy).(pulumi.StringOutput),
+	})
+	if err != nil {
+		return nil, resourceErr(mailQueueName, err)
+	}
+
+	sender, err := lambdaMailQueueSender(ctx, queue.Url)
+	if err != nil {
+		return nil, err
+	}
+	mappingName := "email-form-mail-sender-queue"
+	_, err = lambda.NewEventSourceMapping(ctx, mappingName, &lambda.EventSourceMappingArgs{
+		EventSourceArn:        queue.Arn,
+		FunctionName:          sender.Arn,
+		BatchSize:             pulumi.Int(mailQueueBatchSize),
+		FunctionResponseTypes: pulumi.StringArray{pulumi.String("ReportBatchItemFailures")},
+	})
+	if err != nil {
+		return nil, resourceErr(mappingName, err)
+	}
+	return queue, nil
+}
+
+func newEmailFormBackend(ctx *pulumi.Context, sites []staticSiteProject) (emailFormBackend, error) {
+	// Creates the tables and the mail queues used by the form handlers
+	_, rateLimitErr := rateLimitTable(ctx)
+	submissionsErr := submissionsTable(ctx)
+	queue, queueErr := mailQueue(ctx, sites)
+	if err := errors.Join(rateLimitErr, submissionsErr, queueErr); err != nil {
+		return emailFormBackend{}, err
+	}
+	return emailFormBackend{queueUrl: queue.Url}, nil
+}
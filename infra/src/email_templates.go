@@ -0,0 +1,278 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"html"
+	htmltemplate "html/template"
+	"net/url"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// Sources of the SES templates, [[ ]] actions are filled in with the site
+// branding at deploy time, {{ }} placeholders by SES from the submission
+//
+//go:embed email_templates
+var emailTemplateFiles embed.FS
+
+const (
+	// Notification of a submission sent to the form recipients
+	notificationTemplate = "notification"
+	// Confirmation sent to the customer who filled in the form
+	autoReplyTemplate = "autoreply"
+	// Header color of mails of sites without their own
+	defaultBrandColor = "#2e3842"
+)
+
+// Kinds of templates rendered for every form, in the order they are created
+var emailTemplateKinds = []string{notificationTemplate, autoReplyTemplate}
+
+// Subjects of the templates, notifications use the subject rendered by the form
+// handler. Subjects are plain text, so the fields are not HTML escaped
+var emailTemplateSubjects = map[string]string{
+	notificationTemplate: "{{{subject}}}",
+	autoReplyTemplate:    "Potvrzení přijetí zprávy – [[.Name]]",
+}
+
+var brandColorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// formBranding is the look of the form mails of a site
+type formBranding struct {
+	// Name shown in the mail header and signature, defaults to the site domain
+	Name string `json:"name"`
+	// Header color as #rrggbb
+	Color string `json:"color"`
+	// Optional https URL of the logo shown in the header
+	LogoUrl string `json:"logo-url"`
+	// Site URL linked from the footer, defaults to https:// of the site domain
+	Url string `json:"url"`
+}
+
+// emailTemplate is an SES template rendered with the branding of a site
+type emailTemplate struct {
+	kind    string
+	subject string
+	html    string
+	text    string
+}
+
+func (branding *formBranding) validate() error {
+	if branding == nil {
+		return nil
+	}
+	for _, value := range []string{branding.Name, branding.LogoUrl, branding.Url} {
+		// SES would take them for placeholders
+		if strings.Contains(value, "{{") || strings.Contains(value, "}}") {
+			return fmt.Errorf("contact-form: branding must not contain {{ or }}")
+		}
+	}
+	if branding.Color != "" && !brandColorPattern.MatchString(branding.Color) {
+		return fmt.Errorf("contact-form: invalid branding color %q, must be #rrggbb", branding.Color)
+	}
+	for _, link := range []string{branding.LogoUrl, branding.Url} {
+		if link == "" {
+			continue
+		}
+		if parsed, err := url.Parse(link); err != nil || parsed.Scheme != "https" || parsed.Host == "" {
+			return fmt.Errorf("contact-form: invalid branding url %q, must be https://", link)
+		}
+	}
+	return nil
+}
+
+func (form *contactForm) branding(domains []siteDomain) (formBranding, error) {
+	// Fills in the defaults from the first domain of the site
+	var branding formBranding
+	if form.Branding != nil {
+		branding = *form.Branding
+	}
+	if branding.Color == "" {
+		branding.Color = defaultBrandColor
+	}
+	if len(domains) > 0 {
+		if branding.Name == "" {
+			branding.Name = domains[0].host
+		}
+		if branding.Url == "" {
+			branding.Url = fmt.Sprintf("https://%s", domains[0].host)
+		}
+	}
+	if branding.Name == "" || branding.Url == "" {
+		return formBranding{}, fmt.Errorf("contact-form: branding name and url are required for sites without a domain")
+	}
+	return branding, nil
+}
+
+func renderEmailTemplates(branding formBranding) ([]emailTemplate, error) {
+	// Fills the branding into the template sources. The rendered templates
+	// are checked with sample submissions, so a broken placeholder fails the
+	// deploy instead of the mails
+	templates := make([]emailTemplate, 0, len(emailTemplateKinds))
+	for _, kind := range emailTemplateKinds {
+		subject, err := renderBranding(emailTemplateSubjects[kind], branding, false)
+		if err != nil {
+			return nil, fmt.Errorf("email template %s subject: %w", kind, err)
+		}
+		htmlSource, err := emailTemplateFiles.ReadFile(fmt.Sprintf("email_templates/%s.html", kind))
+		if err != nil {
+			return nil, err
+		}
+		htmlPart, err := renderBranding(string(htmlSource), branding, true)
+		if err != nil {
+			return nil, fmt.Errorf("email template %s.html: %w", kind, err)
+		}
+		textSource, err := emailTemplateFiles.ReadFile(fmt.Sprintf("email_templates/%s.txt", kind))
+		if err != nil {
+			return nil, err
+		}
+		textPart, err := renderBranding(string(textSource), branding, false)
+		if err != nil {
+			return nil, fmt.Errorf("email template %s.txt: %w", kind, err)
+		}
+
+		rendered := emailTemplate{kind: kind, subject: subject, html: htmlPart, text: textPart}
+		if err := rendered.check(); err != nil {
+			return nil, fmt.Errorf("email template %s: %w", kind, err)
+		}
+		templates = append(templates, rendered)
+	}
+	return templates, nil
+}
+
+func renderBranding(source string, branding formBranding, escapeHtml bool) (string, error) {
+	// HTML parts escape the branding values for their context
+	var out strings.Builder
+	if escapeHtml {
+		tmpl, err := htmltemplate.New("branding").Delims("[[", "]]").Option("missingkey=error").Parse(source)
+		if err != nil {
+			return "", err
+		}
+		err = tmpl.Execute(&out, branding)
+		return out.String(), err
+	}
+	tmpl, err := template.New("branding").Delims("[[", "]]").Option("missingkey=error").Parse(source)
+	if err != nil {
+		return "", err
+	}
+	err = tmpl.Execute(&out, branding)
+	return out.String(), err
+}
+
+func (tmpl emailTemplate) check() error {
+	// Renders every part with all fields and with the optional ones left out.
+	// The auto-reply goes to any address entered in the form, so it gets only
+	// the fields the submitter cannot choose
+	full := sampleTemplateData()
+	sparse := sampleTemplateData()
+	sparse["phone"], sparse["email"] = "", ""
+	if tmpl.kind == autoReplyTemplate {
+		full = autoReplyData(full)
+		sparse = autoReplyData(sparse)
+	}
+	for _, data := range []map[string]string{full, sparse} {
+		for _, part := range []string{tmpl.subject, tmpl.html, tmpl.text} {
+			if _, err := renderSesTemplate(part, data); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func sampleTemplateData() map[string]string {
+	// Submission used to check and preview the templates, it has every field
+	// filled in by the form handler
+	return map[string]string{
+		"subject":     "Zpráva od Jana Nováka",
+		"name":        "Jan Novák",
+		"phone":       "+420 123 456 789",
+		"email":       "jan.novak@example.cz",
+		"message":     "Dobrý den,\nmám zájem o <nabídku> & termín.\n\nDěkuji",
+		"submittedAt": "1. 5. 2024 14:00",
+	}
+}
+
+func autoReplyData(data map[string]string) map[string]string {
+	// Fields of the auto-reply, the form handler passes only these
+	return map[string]string{"submittedAt": data["submittedAt"]}
+}
+
+func renderSesTemplate(source string, data map[string]string) (string, error) {
+	// Renders the subset of the SES (Handlebars) syntax the templates use:
+	// {{field}} is HTML escaped, {{{field}}} is verbatim and {{#if field}}
+	// blocks are left out for empty fields. Unknown fields are errors
+	var out strings.Builder
+	// Whether the blocks of the open ifs are left out
+	var skipped []bool
+	skipping := func() bool {
+		for _, skip := range skipped {
+			if skip {
+				return true
+			}
+		}
+		return false
+	}
+	field := func(name string) (string, error) {
+		value, ok := data[name]
+		if !ok {
+			return "", fmt.Errorf("unknown template field %q", name)
+		}
+		return value, nil
+	}
+
+	rest := source
+	for {
+		start := strings.Index(rest, "{{")
+		if start < 0 {
+			break
+		}
+		if !skipping() {
+			out.WriteString(rest[:start])
+		}
+		raw := strings.HasPrefix(rest[start:], "{{{")
+		open, closing := "{{", "}}"
+		if raw {
+			open, closing = "{{{", "}}}"
+		}
+		rest = rest[start+len(open):]
+		end := strings.Index(rest, closing)
+		if end < 0 {
+			return "", fmt.Errorf("unclosed placeholder %s", open)
+		}
+		tag := strings.TrimSpace(rest[:end])
+		rest = rest[end+len(closing):]
+
+		switch {
+		case strings.HasPrefix(tag, "#if "):
+			value, err := field(strings.TrimSpace(strings.TrimPrefix(tag, "#if ")))
+			if err != nil {
+				return "", err
+			}
+			skipped = append(skipped, value == "")
+		case tag == "/if":
+			if len(skipped) == 0 {
+				return "", fmt.Errorf("{{/if}} without {{#if}}")
+			}
+			skipped = skipped[:len(skipped)-1]
+		default:
+			value, err := field(tag)
+			if err != nil {
+				return "", err
+			}
+			if skipping() {
+				continue
+			}
+			if !raw {
+				value = html.EscapeString(value)
+			}
+			out.WriteString(value)
+		}
+	}
+	if len(skipped) > 0 {
+		return "", fmt.Errorf("{{#if}} without {{/if}}")
+	}
+	out.WriteString(rest)
+	return out.String(), nil
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+func TestRenderSesTemplate(t *testing.T) {
+	data := map[string]string{"name": "Jan <Novák> & syn", "phone": "", "subject": "Zpráva od O'Brien, A&B"}
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{"Dobrý den, {{name}}!", "Dobrý den, Jan &lt;Novák&gt; &amp; syn!"},
+		{"Jméno: {{{ name }}}", "Jméno: Jan <Novák> & syn"},
+		{"{{#if phone}}Telefon: {{phone}}\n{{/if}}konec", "konec"},
+		{"{{#if name}}A{{#if phone}}B{{/if}}C{{/if}}", "AC"},
+		{"bez polí", "bez polí"},
+		{"{{subject}}", "Zpráva od O&#39;Brien, A&amp;B"},
+		{emailTemplateSubjects[notificationTemplate], "Zpráva od O'Brien, A&B"},
+	}
+	for _, test := range tests {
+		if got, err := renderSesTemplate(test.source, data); err != nil || got != test.want {
+			t.Errorf("renderSesTemplate(%q) = %q, %v, want %q", test.source, got, err, test.want)
+		}
+	}
+
+	for _, source := range []string{"{{address}}", "{{#if address}}x{{/if}}", "{{name", "{{#if name}}x", "x{{/if}}"} {
+		if _, err := renderSesTemplate(source, data); err == nil {
+			t.Errorf("renderSesTemplate(%q) accepted", source)
+		}
+	}
+}
+
+func TestFormBranding(t *testing.T) {
+	domains, err := getSiteDomains(testProject("example.com", "www.example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	form := contactForm{}
+	branding, err := form.branding(domains)
+	if err != nil || branding != (formBranding{Name: "example.com", Color: defaultBrandColor, Url: "https://example.com"}) {
+		t.Errorf("default branding = %+v, %v", branding, err)
+	}
+	if _, err := form.branding(nil); err == nil {
+		t.Error("branding of a site without a domain needs a name and url")
+	}
+	form.Branding = &formBranding{Name: "Obchod", Url: "https://obchod.example.com"}
+	if branding, err := form.branding(nil); err != nil || branding.Name != "Obchod" || branding.Color != defaultBrandColor {
+		t.Errorf("explicit branding = %+v, %v", branding, err)
+	}
+
+	tests := []struct {
+		name     string
+		branding formBranding
+		err      string
+	}{
+		{"color", formBranding{Color: "red"}, "invalid branding color"},
+		{"logo scheme", formBranding{LogoUrl: "http://example.com/logo.png"}, "invalid branding url"},
+		{"url", formBranding{Url: "example.com"}, "invalid branding url"},
+		{"placeholder", formBranding{Name: "{{name}}"}, "must not contain"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.branding.validate(); err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("validate() = %v, want error containing %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestRenderEmailTemplatesEscapesBranding(t *testing.T) {
+	templates, err := renderEmailTemplates(formBranding{Name: "Novák & <syn>", Color: "#123456", LogoUrl: "https://example.com/logo.png?a=1&b=2", Url: "https://example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tmpl := range templates {
+		if !strings.Contains(tmpl.html, "Novák &amp; &lt;syn&gt;") || !strings.Contains(tmpl.html, "background:#123456") || !strings.Contains(tmpl.html, `src="https://example.com/logo.png?a=1&amp;b=2"`) {
+			t.Errorf("branding of %s.html not escaped:\n%s", tmpl.kind, tmpl.html)
+		}
+		if !strings.Contains(tmpl.text, "Novák & <syn>") {
+			t.Errorf("branding of %s.txt escaped:\n%s", tmpl.kind, tmpl.text)
+		}
+	}
+}
+
+func TestAutoReplyTemplateCheck(t *testing.T) {
+	// The auto-reply must not mail back what the submitter entered
+	for _, field := range []string{"name", "email", "message"} {
+		tmpl := emailTemplate{kind: autoReplyTemplate, subject: "Potvrzení", html: "<p>{{" + field + "}}</p>", text: "{{submittedAt}}"}
+		if err := tmpl.check(); err == nil || !strings.Contains(err.Error(), field) {
+			t.Errorf("auto-reply with %s: check() = %v", field, err)
+		}
+	}
+	notification := emailTemplate{kind: notificationTemplate, subject: "{{{subject}}}", html: "<p>{{name}}</p>", text: "{{{message}}}"}
+	if err := notification.check(); err != nil {
+		t.Errorf("notification check() = %v", err)
+	}
+}
+
+func TestEmailTemplateSnapshots(t *testing.T) {
+	// Renders the templates of the prod sites with a sample submission the way
+	// SES does, the previews in testdata/golden/email are updated with -update
+	sites, err := parseSites(stackConfig(t, "prod", nil)["www-infra:sites"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, site := range sites {
+		if site.contactForm == nil {
+			continue
+		}
+		domains, err := getSiteDomains(site)
+		if err != nil {
+			t.Fatal(err)
+		}
+		branding, err := site.contactForm.branding(domains)
+		if err != nil {
+			t.Fatal(err)
+		}
+		templates, err := renderEmailTemplates(branding)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data := sampleTemplateData()
+		subject, err := site.contactForm.subjectTemplate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var rendered strings.Builder
+		if err := subject.Execute(&rendered, contactFormFields{Name: data["name"], Phone: data["phone"], Email: data["email"], Message: data["message"]}); err != nil {
+			t.Fatal(err)
+		}
+		data["subject"] = rendered.String()
+
+		for _, tmpl := range templates {
+			tmplData := data
+			if tmpl.kind == autoReplyTemplate {
+				tmplData = autoReplyData(data)
+			}
+			subject, subjectErr := renderSesTemplate(tmpl.subject, tmplData)
+			html, htmlErr := renderSesTemplate(tmpl.html, tmplData)
+			text, textErr := renderSesTemplate(tmpl.text, tmplData)
+			for _, err := range []error{subjectErr, htmlErr, textErr} {
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			previews := map[string]string{
+				".html": html,
+				".txt":  "Subject: " + subject + "\n\n" + text,
+			}
+			for extension, preview := range previews {
+				goldenFile := filepath.Join("testdata", "golden", "email", site.name+"-"+tmpl.kind+extension)
+				if *updateGolden {
+					if err := os.MkdirAll(filepath.Dir(goldenFile), 0o755); err != nil {
+						t.Fatal(err)
+					}
+					if err := os.WriteFile(goldenFile, []byte(preview), 0o644); err != nil {
+						t.Fatal(err)
+					}
+				}
+				golden, err := os.ReadFile(goldenFile)
+				if err != nil {
+					t.Fatalf("%v (run go test -update to create it)", err)
+				}
+				if !bytes.Equal(golden, []byte(preview)) {
+					t.Errorf("rendered mail differs from %s (run go test -update to accept)", goldenFile)
+				}
+			}
+		}
+	}
+}
+
+func TestContactFormTemplates(t *testing.T) {
+	shop := testFormProject("shop", "example.com")
+	shop.contactForm.AutoReply = true
+	mocks, err := runWithMocks(t, "test", nil, func(ctx *pulumi.Context) error {
+		return simpleMailService(ctx, []staticSiteProject{shop, testFormProject("blog", "example.net")}).errOrNil()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	notification := mocks.find(t, "aws:ses/template:Template", "shop-contact-form-notification")
+	if notification.Inputs["subject"] != "{{{subject}}}" || !strings.Contains(notification.Inputs["html"].(string), "https://example.com") {
+		t.Errorf("notification template inputs = %v", notification.Inputs)
+	}
+	mocks.find(t, "aws:ses/template:Template", "shop-contact-form-autoreply")
+	mocks.find(t, "aws:ses/template:Template", "blog-contact-form-notification")
+	for _, tmpl := range mocks.byType("aws:ses/template:Template") {
+		if tmpl.Name == "blog-contact-form-autoreply" {
+			t.Error("auto-reply template of a site without auto-reply")
+		}
+	}
+
+	shopVariables := mocks.find(t, "aws:lambda/function:Function", "shop-contact-form").Inputs["environment"].(map[string]interface{})["variables"].(map[string]interface{})
+	if shopVariables["MAIL_TEMPLATE"] != "shop-contact-form-notification" || shopVariables["AUTO_REPLY_TEMPLATE"] != "shop-contact-form-autoreply" {
+		t.Errorf("shop template environment = %v", shopVariables)
+	}
+	blogVariables := mocks.find(t, "aws:lambda/function:Function", "blog-contact-form").Inputs["environment"].(map[string]interface{})["variables"].(map[string]interface{})
+	if _, ok := blogVariables["AUTO_REPLY_TEMPLATE"]; ok || blogVariables["MAIL_TEMPLATE"] != "blog-contact-form-notification" {
+		t.Errorf("blog template environment = %v", blogVariables)
+	}
+}
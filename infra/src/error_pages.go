@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/cloudfront"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+const (
+	// Seconds CloudFront caches client and server errors before asking the origin again
+	clientErrorCachingTtl = 60
+	serverErrorCachingTtl = 10
+)
+
+// Origin errors CloudFront can answer with a custom page
+var errorPageCodes = []int{400, 403, 404, 405, 414, 416, 500, 501, 502, 503, 504}
+
+// errorPage answers the origin error code with a page of the site
+type errorPage struct {
+	Code int    `json:"code"`
+	Page string `json:"page"`
+	// Status sent to the viewer, defaults to the error code
+	ResponseCode int `json:"response-code"`
+	// Seconds the error is cached, defaults to a short TTL by the error class
+	CachingTtl *int `json:"caching-ttl"`
+}
+
+// errorPages replace the default pages of their codes
+type errorPages []errorPage
+
+func defaultErrorPages(errorDoc string) errorPages {
+	// Every site answers its errors with the error document. S3 denies keys
+	// it cannot list, so a denied object is reported as missing
+	return errorPages{
+		{Code: 403, Page: errorDoc, ResponseCode: 404},
+		{Code: 404, Page: errorDoc},
+		{Code: 500, Page: errorDoc},
+		{Code: 503, Page: errorDoc},
+	}
+}
+
+func containsInt(values []int, value int) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (pages errorPages) validate() error {
+	seen := make(map[int]bool, len(pages))
+	for i, page := range pages {
+		if !containsInt(errorPageCodes, page.Code) {
+			return fmt.Errorf("error page %d: invalid code %d, must be one of %v", i, page.Code, errorPageCodes)
+		}
+		if seen[page.Code] {
+			return fmt.Errorf("error page %d: duplicate code %d", i, page.Code)
+		}
+		seen[page.Code] = true
+		if !fs.ValidPath(page.Page) || page.Page == "." {
+			return fmt.Errorf("error page %d: invalid page %q, must be a path in the site directory", i, page.Page)
+		}
+		if page.ResponseCode != 0 && page.ResponseCode != 200 && !containsInt(errorPageCodes, page.ResponseCode) {
+			return fmt.Errorf("error page %d: invalid response-code %d", i, page.ResponseCode)
+		}
+		if page.CachingTtl != nil && *page.CachingTtl < 0 {
+			return fmt.Errorf("error page %d: caching-ttl must not be negative", i)
+		}
+	}
+	return nil
+}
+
+func (page errorPage) responseCode() int {
+	if page.ResponseCode == 0 {
+		return page.Code
+	}
+	return page.ResponseCode
+}
+
+func (page errorPage) cachingTtl() int {
+	switch {
+	case page.CachingTtl != nil:
+		return *page.CachingTtl
+	case page.Code >= 500:
+		return serverErrorCachingTtl
+	default:
+		return clientErrorCachingTtl
+	}
+}
+
+func siteErrorPages(project staticSiteProject) errorPages {
+	// Error pages of the site config with the defaults of the other codes, ordered by code
+	pages := append(errorPages{}, project.errorPages...)
+	for _, page := range defaultErrorPages(project.errorDoc) {
+		configured := false
+		for _, sitePage := range project.errorPages {
+			configured = configured || sitePage.Code == page.Code
+		}
+		if !configured {
+			pages = append(pages, page)
+		}
+	}
+	sort.Slice(pages, func(i, j int) bool { return pages[i].Code < pages[j].Code })
+	return pages
+}
+
+func checkErrorPages(project staticSiteProject) error {
+	// A missing page would make CloudFront answer the error with another error,
+	// so every page must be built before the site is deployed
+	var missing []string
+	for _, page := range siteErrorPages(project) {
+		info, err := os.Stat(filepath.Join(project.dir, filepath.FromSlash(page.Page)))
+		if err != nil || !info.Mode().IsRegular() {
+			missing = append(missing, fmt.Sprintf("%s (%d)", page.Page, page.Code))
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("error pages not found in %s: %s", project.dir, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+func customErrorResponses(project staticSiteProject) cloudfront.DistributionCustomErrorResponseArray {
+	responses := cloudfront.DistributionCustomErrorResponseArray{}
+	for _, page := range siteErrorPages(project) {
+		responses = append(responses, cloudfront.DistributionCustomErrorResponseArgs{
+			ErrorCode:          pulumi.Int(page.Code),
+			ResponseCode:       pulumi.Int(page.responseCode()),
+			ResponsePagePath:   pulumi.String("/" + page.Page),
+			ErrorCachingMinTtl: pulumi.Int(page.cachingTtl()),
+		})
+	}
+	return responses
+}
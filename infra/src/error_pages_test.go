@@ -0,0 +1,129 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestErrorPagesValidate(t *testing.T) {
+	ttl := 0
+	valid := errorPages{
+		{Code: 404, Page: "404.html"},
+		{Code: 403, Page: "errors/denied.html", ResponseCode: 404},
+		{Code: 503, Page: "maintenance.html", CachingTtl: &ttl},
+	}
+	if err := valid.validate(); err != nil {
+		t.Fatalf("valid error pages rejected: %v", err)
+	}
+
+	negative := -1
+	tests := []struct {
+		name  string
+		pages errorPages
+		err   string
+	}{
+		{"code", errorPages{{Code: 418, Page: "error.html"}}, "invalid code"},
+		{"duplicate", errorPages{{Code: 404, Page: "a.html"}, {Code: 404, Page: "b.html"}}, "duplicate code"},
+		{"missing page", errorPages{{Code: 404}}, "invalid page"},
+		{"absolute page", errorPages{{Code: 404, Page: "/404.html"}}, "invalid page"},
+		{"outside page", errorPages{{Code: 404, Page: "../404.html"}}, "invalid page"},
+		{"response code", errorPages{{Code: 404, Page: "404.html", ResponseCode: 302}}, "invalid response-code"},
+		{"ttl", errorPages{{Code: 404, Page: "404.html", CachingTtl: &negative}}, "caching-ttl"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.pages.validate(); err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("validate() = %v, want error containing %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestSiteErrorPages(t *testing.T) {
+	ttl := 300
+	project := testProject("example.com")
+	project.errorPages = errorPages{
+		{Code: 503, Page: "maintenance.html", CachingTtl: &ttl},
+		{Code: 404, Page: "404.html"},
+	}
+
+	responses := map[int]errorPage{}
+	codes := []int{}
+	for _, page := range siteErrorPages(project) {
+		responses[page.Code] = page
+		codes = append(codes, page.Code)
+	}
+	if got := len(codes); got != 4 || codes[0] != 403 || codes[3] != 503 {
+		t.Fatalf("error page codes = %v, want 403, 404, 500 and 503 in order", codes)
+	}
+	tests := []struct {
+		code         int
+		page         string
+		responseCode int
+		cachingTtl   int
+	}{
+		{403, "error.html", 404, clientErrorCachingTtl},
+		{404, "404.html", 404, clientErrorCachingTtl},
+		{500, "error.html", 500, serverErrorCachingTtl},
+		{503, "maintenance.html", 503, 300},
+	}
+	for _, test := range tests {
+		page := responses[test.code]
+		if page.Page != test.page || page.responseCode() != test.responseCode || page.cachingTtl() != test.cachingTtl {
+			t.Errorf("error page %d = %s %d %ds, want %s %d %ds", test.code, page.Page, page.responseCode(), page.cachingTtl(), test.page, test.responseCode, test.cachingTtl)
+		}
+	}
+}
+
+func TestCheckErrorPages(t *testing.T) {
+	project := testProject("example.com")
+	if err := checkErrorPages(project); err != nil {
+		t.Fatalf("error document of the test site not found: %v", err)
+	}
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "error.html"), []byte("<h1>Error</h1>"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "404.html"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	project.dir = dir
+	project.errorPages = errorPages{{Code: 404, Page: "404.html"}, {Code: 503, Page: "maintenance.html"}}
+	err := checkErrorPages(project)
+	if err == nil || !strings.Contains(err.Error(), "404.html (404), maintenance.html (503)") {
+		t.Errorf("checkErrorPages() = %v, want both missing pages", err)
+	}
+}
+
+func TestDeployProjectMissingErrorPage(t *testing.T) {
+	project := testProject("example.com")
+	project.errorPages = errorPages{{Code: 404, Page: "404.html"}}
+	mocks, err := runWithMocks(t, "test", nil, deployTestProject(project))
+	if err == nil || !strings.Contains(err.Error(), "404.html") {
+		t.Fatalf("deploy with a missing error page = %v", err)
+	}
+	if buckets := mocks.byType("aws:s3/bucket:Bucket"); len(buckets) != 1 {
+		t.Errorf("site resources created before the check: %s", names(buckets))
+	}
+}
+
+func TestDeployProjectErrorResponses(t *testing.T) {
+	project := testProject("example.com")
+	project.errorPages = errorPages{{Code: 404, Page: "index.html", ResponseCode: 200}}
+	mocks, err := runWithMocks(t, "test", nil, deployTestProject(project))
+	if err != nil {
+		t.Fatal(err)
+	}
+	distribution := mocks.find(t, "aws:cloudfront/distribution:Distribution", "example.com-cdn")
+	responses := distribution.Inputs["customErrorResponses"].([]interface{})
+	if len(responses) != 4 {
+		t.Fatalf("error responses = %v", responses)
+	}
+	notFound := responses[1].(map[string]interface{})
+	if notFound["errorCode"] != 404.0 || notFound["responseCode"] != 200.0 || notFound["responsePagePath"] != "/index.html" || notFound["errorCachingMinTtl"] != float64(clientErrorCachingTtl) {
+		t.Errorf("404 error response = %v", notFound)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// resourceError marks the Pulumi resource (or lookup) that failed
+type resourceError struct {
+	resource string
+	err      error
+}
+
+func (e *resourceError) Error() string {
+	return fmt.Sprintf("resource %s: %v", e.resource, e.err)
+}
+
+func (e *resourceError) Unwrap() error {
+	return e.err
+}
+
+func resourceErr(resource string, err error) error {
+	// Wraps err with the name of the resource it belongs to, nil stays nil
+	if err == nil {
+		return nil
+	}
+	return &resourceError{resource: resource, err: err}
+}
+
+// deployError is a single failure of one deployed project
+type deployError struct {
+	project  string
+	resource string
+	err      error
+}
+
+func (e *deployError) Error() string {
+	if e.resource == "" {
+		return fmt.Sprintf("project %s: %v", e.project, e.err)
+	}
+	return fmt.Sprintf("project %s, resource %s: %v", e.project, e.resource, e.err)
+}
+
+func (e *deployError) Unwrap() error {
+	return e.err
+}
+
+// deployErrors collects failures of independent projects, so one broken
+// project does not stop the others from being deployed
+type deployErrors []*deployError
+
+func (e *deployErrors) add(project string, err error) {
+	if err == nil {
+		return
+	}
+	// Independent failures joined by errors.Join are reported one by one
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, inner := range joined.Unwrap() {
+			e.add(project, inner)
+		}
+		return
+	}
+	failure := &deployError{project: project, err: err}
+	var resErr *resourceError
+	if errors.As(err, &resErr) {
+		failure.resource = resErr.resource
+		if err == error(resErr) {
+			failure.err = resErr.err
+		}
+	}
+	*e = append(*e, failure)
+}
+
+func (e deployErrors) Error() string {
+	lines := make([]string, len(e))
+	for i, failure := range e {
+		lines[i] = failure.Error()
+	}
+	return fmt.Sprintf("%d project(s) failed to deploy:\n%s", len(e), strings.Join(lines, "\n"))
+}
+
+func (e deployErrors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, failure := range e {
+		errs[i] = failure
+	}
+	return errs
+}
+
+func (e deployErrors) errOrNil() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	apigateway "github.com/pulumi/pulumi-aws-apigateway/sdk/go/apigateway"
+)
+
+const (
+	// Methods and request headers of the form posts, the form handler answers
+	// its posts with the same CORS headers
+	corsAllowMethods = "OPTIONS,POST"
+	corsAllowHeaders = "Content-Type"
+	// Seconds browsers may cache a preflight response
+	corsMaxAge = 600
+)
+
+// Origins a form accepts posts from, https only without a path
+var formOriginPattern = regexp.MustCompile(`^https://[a-z0-9]([a-z0-9.-]*[a-z0-9])?(:[0-9]+)?$`)
+
+func preflightTemplate(origins []string) string {
+	// Mapping template of the mock integration, it echoes the request origin
+	// when it is allowed. Other origins get the first allowed one, which the
+	// browser rejects. HTTP/2 clients send the header name in lower case
+	conditions := make([]string, len(origins))
+	for i, origin := range origins {
+		conditions[i] = fmt.Sprintf(`$origin == "%s"`, origin)
+	}
+	return strings.Join([]string{
+		`#set($origin = $input.params().header.get("Origin"))`,
+		`#if(!$origin)#set($origin = $input.params().header.get("origin"))#end`,
+		fmt.Sprintf(`#if(%s)#set($context.responseOverride.header.Access-Control-Allow-Origin = $origin)#end`, strings.Join(conditions, " || ")),
+		`{}`,
+	}, "\n")
+}
+
+func preflightOperation(origins []string) map[string]interface{} {
+	// Swagger operation answering the preflight in API Gateway itself, so no
+	// function runs for it
+	headers := map[string]interface{}{
+		"Access-Control-Allow-Origin":  map[string]interface{}{"type": "string"},
+		"Access-Control-Allow-Methods": map[string]interface{}{"type": "string"},
+		"Access-Control-Allow-Headers": map[string]interface{}{"type": "string"},
+		"Access-Control-Max-Age":       map[string]interface{}{"type": "string"},
+		"Vary":                         map[string]interface{}{"type": "string"},
+	}
+	return map[string]interface{}{
+		"responses": map[string]interface{}{
+			"200": map[string]interface{}{"description": "CORS preflight response", "headers": headers},
+		},
+		"x-amazon-apigateway-integration": map[string]interface{}{
+			"type":                "mock",
+			"passthroughBehavior": "when_no_match",
+			"requestTemplates": map[string]interface{}{
+				"application/json": `{"statusCode": 200}`,
+			},
+			"responses": map[string]interface{}{
+				"default": map[string]interface{}{
+					"statusCode": "200",
+					"responseParameters": map[string]interface{}{
+						"method.response.header.Access-Control-Allow-Origin":  fmt.Sprintf("'%s'", origins[0]),
+						"method.response.header.Access-Control-Allow-Methods": fmt.Sprintf("'%s'", corsAllowMethods),
+						"method.response.header.Access-Control-Allow-Headers": fmt.Sprintf("'%s'", corsAllowHeaders),
+						"method.response.header.Access-Control-Max-Age":       fmt.Sprintf("'%d'", corsMaxAge),
+						"method.response.header.Vary":                         "'Origin'",
+					},
+					"responseTemplates": map[string]interface{}{
+						"application/json": preflightTemplate(origins),
+					},
+				},
+			},
+		},
+	}
+}
+
+func preflightRoute(project staticSiteProject, origins []string) apigateway.RouteArgs {
+	optionMethod := apigateway.MethodOPTIONS
+	return apigateway.RouteArgs{
+		Path:   contactFormPath(project),
+		Method: &optionMethod,
+		Data:   preflightOperation(origins),
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPreflightOperation(t *testing.T) {
+	origins := []string{"https://example.com", "https://www.example.com"}
+	integration := preflightOperation(origins)["x-amazon-apigateway-integration"].(map[string]interface{})
+	if integration["type"] != "mock" {
+		t.Errorf("integration type = %v", integration["type"])
+	}
+	response := integration["responses"].(map[string]interface{})["default"].(map[string]interface{})
+	parameters := response["responseParameters"].(map[string]interface{})
+	expected := map[string]string{
+		"method.response.header.Access-Control-Allow-Origin":  "'https://example.com'",
+		"method.response.header.Access-Control-Allow-Methods": "'OPTIONS,POST'",
+		"method.response.header.Access-Control-Allow-Headers": "'Content-Type'",
+		"method.response.header.Vary":                         "'Origin'",
+	}
+	for key, value := range expected {
+		if parameters[key] != value {
+			t.Errorf("%s = %v, want %s", key, parameters[key], value)
+		}
+	}
+
+	template := response["responseTemplates"].(map[string]interface{})["application/json"].(string)
+	want := `#if($origin == "https://example.com" || $origin == "https://www.example.com")#set($context.responseOverride.header.Access-Control-Allow-Origin = $origin)#end`
+	if !strings.Contains(template, want) {
+		t.Errorf("preflight template does not echo allowed origins:\n%s", template)
+	}
+}
+
+func TestContactFormDefaultOriginsAreHttps(t *testing.T) {
+	// Origins derived from the site domains pass the origin validation
+	domains, err := getSiteDomains(testProject("example.com", "www.example.com", "shop.example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	origins, err := (&contactForm{}).allowedOrigins(domains)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(origins, ","); got != "https://example.com,https://www.example.com,https://shop.example.com" {
+		t.Errorf("origins = %s", got)
+	}
+	for _, origin := range origins {
+		if !formOriginPattern.MatchString(origin) {
+			t.Errorf("derived origin %s is not a valid form origin", origin)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/apigateway"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/dynamodb"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+)
+
+const (
+	// DynamoDB table counting submissions of every client, shared by all forms
+	rateLimitTableName = "email-form-rate-limits"
+	// Submissions a client may send per hour
+	defaultSubmissionsPerIp = 5
+	// Requests per second and burst the API accepts on the form route of a site
+	defaultThrottleRate  = 1
+	defaultThrottleBurst = 5
+	// Seconds a person needs at least to fill in a form
+	defaultMinFillSeconds = 3
+)
+
+// Providers verifying CAPTCHA tokens in the send mail handler
+var captchaProviders = map[string]bool{"turnstile": true, "recaptcha": true, "hcaptcha": true, "stub": true}
+
+// formRateLimit limits submissions of a contact form, zero values use the defaults
+type formRateLimit struct {
+	// Submissions per client IP and hour, counted in DynamoDB
+	PerIp int `json:"per-ip"`
+	// API Gateway throttling of the form route
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+// formCaptcha enables CAPTCHA verification of submissions
+type formCaptcha struct {
+	Provider string `json:"provider"`
+	// Stack config key of the provider secret, set with pulumi config set --secret
+	SecretConfig string `json:"secret-config"`
+}
+
+func (limit *formRateLimit) validate() error {
+	if limit == nil {
+		return nil
+	}
+	if limit.PerIp < 0 || limit.Rate < 0 || limit.Burst < 0 {
+		return fmt.Errorf("contact-form: rate-limit values must not be negative")
+	}
+	return nil
+}
+
+func (captcha *formCaptcha) validate() error {
+	if captcha == nil {
+		return nil
+	}
+	if !captchaProviders[captcha.Provider] {
+		return fmt.Errorf("contact-form: unknown captcha provider %q", captcha.Provider)
+	}
+	if captcha.Provider != "stub" && captcha.SecretConfig == "" {
+		return fmt.Errorf("contact-form: captcha provider %s requires secret-config", captcha.Provider)
+	}
+	return nil
+}
+
+func (form *contactForm) rateLimit() formRateLimit {
+	// Returns the limits of the form with defaults in place of unset values
+	limit := formRateLimit{PerIp: defaultSubmissionsPerIp, Rate: defaultThrottleRate, Burst: defaultThrottleBurst}
+	if form.RateLimit == nil {
+		return limit
+	}
+	if form.RateLimit.PerIp > 0 {
+		limit.PerIp = form.RateLimit.PerIp
+	}
+	if form.RateLimit.Rate > 0 {
+		limit.Rate = form.RateLimit.Rate
+	}
+	if form.RateLimit.Burst > 0 {
+		limit.Burst = form.RateLimit.Burst
+	}
+	return limit
+}
+
+func (form *contactForm) minFillSeconds() int {
+	if form.MinFillSeconds == nil {
+		return defaultMinFillSeconds
+	}
+	return *form.MinFillSeconds
+}
+
+func captchaEnvironment(ctx *pulumi.Context, form *contactForm) (map[string]string, map[string]pulumi.StringInput) {
+	// Returns the plain and secret environment of the CAPTCHA verifier
+	if form.Captcha == nil {
+		return nil, nil
+	}
+	environment := map[string]string{"CAPTCHA_PROVIDER": form.Captcha.Provider}
+	if form.Captcha.SecretConfig == "" {
+		return environment, nil
+	}
+	return environment, map[string]pulumi.StringInput{
+		"CAPTCHA_SECRET": config.RequireSecret(ctx, form.Captcha.SecretConfig),
+	}
+}
+
+func rateLimitTable(ctx *pulumi.Context) (*dynamodb.Table, error) {
+	// Counters expire with their window, so the table stays small
+	log.Printf("emailForm - Setting rate limit table %s\n", rateLimitTableName)
+	table, err := dynamodb.NewTable(ctx, rateLimitTableName, &dynamodb.TableArgs{
+		Name:        pulumi.String(rateLimitTableName),
+		BillingMode: pulumi.String("PAY_PER_REQUEST"),
+		HashKey:     pulumi.String("key"),
+		Attributes: dynamodb.TableAttributeArray{
+			&dynamodb.TableAttributeArgs{Name: pulumi.String("key"), Type: pulumi.String("S")},
+		},
+		Ttl: &dynamodb.TableTtlArgs{
+			AttributeName: pulumi.String("expires"),
+			Enabled:       pulumi.Bool(true),
+		},
+	})
+	if err != nil {
+		return nil, resourceErr(rateLimitTableName, err)
+	}
+	return table, nil
+}
+
+func formThrottle(ctx *pulumi.Context, restApi pulumi.StringInput, stageName pulumi.StringInput, project staticSiteProject) error {
+	// Throttles the POST route of the site on the stage, so a flood of
+	// requests is rejected before it reaches the lambda
+	limit := project.contactForm.rateLimit()
+	name := fmt.Sprintf("%s-contact-form-throttle", project.name)
+	log.Printf("emailForm - Throttling %s to %v requests per second\n", project.name, limit.Rate)
+	_, err := apigateway.NewMethodSettings(ctx, name, &apigateway.MethodSettingsArgs{
+		RestApi:    restApi,
+		StageName:  stageName,
+		MethodPath: pulumi.String(fmt.Sprintf("%s/POST", project.name)),
+		Settings: &apigateway.MethodSettingsSettingsArgs{
+			ThrottlingRateLimit:  pulumi.Float64(limit.Rate),
+			ThrottlingBurstLimit: pulumi.Int(limit.Burst),
+		},
+	})
+	return resourceErr(name, err)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+func TestContactFormRateLimit(t *testing.T) {
+	form := contactForm{}
+	if got := form.rateLimit(); got != (formRateLimit{PerIp: defaultSubmissionsPerIp, Rate: defaultThrottleRate, Burst: defaultThrottleBurst}) {
+		t.Errorf("default rate limit = %+v", got)
+	}
+	form.RateLimit = &formRateLimit{PerIp: 10, Rate: 0.5}
+	if got := form.rateLimit(); got != (formRateLimit{PerIp: 10, Rate: 0.5, Burst: defaultThrottleBurst}) {
+		t.Errorf("partial rate limit = %+v", got)
+	}
+
+	if got := form.minFillSeconds(); got != defaultMinFillSeconds {
+		t.Errorf("default min fill = %d", got)
+	}
+	disabled := 0
+	form.MinFillSeconds = &disabled
+	if got := form.minFillSeconds(); got != 0 {
+		t.Errorf("disabled min fill = %d", got)
+	}
+}
+
+func TestSimpleMailServiceProtection(t *testing.T) {
+	project := testFormProject("shop", "example.com")
+	project.contactForm.RateLimit = &formRateLimit{PerIp: 3, Rate: 2, Burst: 10}
+	project.contactForm.Captcha = &formCaptcha{Provider: "turnstile", SecretConfig: "turnstileSecret"}
+	mocks, err := runWithMocks(t, "test", map[string]string{"www-infra:turnstileSecret": "secret"}, func(ctx *pulumi.Context) error {
+		return simpleMailService(ctx, []staticSiteProject{project, testFormProject("blog", "example.net")}).errOrNil()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mocks.find(t, "aws:dynamodb/table:Table", rateLimitTableName)
+	throttle := mocks.find(t, "aws:apigateway/methodSettings:MethodSettings", "shop-contact-form-throttle")
+	settings := throttle.Inputs["settings"].(map[string]interface{})
+	if throttle.Inputs["methodPath"] != "shop/POST" || throttle.Inputs["stageName"] != "prod" || throttle.Inputs["restApi"] != "email-form-id" {
+		t.Errorf("throttle inputs = %v", throttle.Inputs)
+	}
+	if settings["throttlingRateLimit"] != 2.0 || settings["throttlingBurstLimit"] != 10.0 {
+		t.Errorf("throttle settings = %v", settings)
+	}
+	mocks.find(t, "aws:apigateway/methodSettings:MethodSettings", "blog-contact-form-throttle")
+
+	mail := mocks.find(t, "aws:lambda/function:Function", "shop-contact-form")
+	variables := mail.Inputs["environment"].(map[string]interface{})["variables"].(map[string]interface{})
+	expected := map[string]string{
+		"RATE_LIMIT_TABLE": rateLimitTableName,
+		"SITE":             "shop",
+		"RATE_LIMIT_MAX":   "3",
+		"MIN_FILL_SECONDS": "3",
+		"CAPTCHA_PROVIDER": "turnstile",
+	}
+	for key, value := range expected {
+		if variables[key] != value {
+			t.Errorf("environment %s = %v, want %s", key, variables[key], value)
+		}
+	}
+	if _, ok := variables["CAPTCHA_SECRET"]; !ok {
+		t.Error("captcha secret not passed to the handler")
+	}
+	blog := mocks.find(t, "aws:lambda/function:Function", "blog-contact-form")
+	if _, ok := blog.Inputs["environment"].(map[string]interface{})["variables"].(map[string]interface{})["CAPTCHA_PROVIDER"]; ok {
+		t.Error("captcha enabled for a form without it")
+	}
+}
+
+func TestFormProtectionValidate(t *testing.T) {
+	negative := -1
+	tests := []struct {
+		name string
+		form contactForm
+		err  string
+	}{
+		{"negative fill time", contactForm{MinFillSeconds: &negative}, "min-fill-seconds"},
+		{"negative rate", contactForm{RateLimit: &formRateLimit{Rate: -1}}, "rate-limit"},
+		{"unknown provider", contactForm{Captcha: &formCaptcha{Provider: "puzzle"}}, "unknown captcha provider"},
+		{"missing secret", contactForm{Captcha: &formCaptcha{Provider: "recaptcha"}}, "requires secret-config"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			form := test.form
+			form.Recipients = []string{"orders@example.com"}
+			form.Sender = "form@example.com"
+			if err := form.validate(); err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("validate() = %v, want error containing %q", err, test.err)
+			}
+		})
+	}
+	stub := contactForm{Recipients: []string{"orders@example.com"}, Sender: "form@example.com", Captcha: &formCaptcha{Provider: "stub"}}
+	if err := stub.validate(); err != nil {
+		t.Errorf("stub captcha rejected: %v", err)
+	}
+}
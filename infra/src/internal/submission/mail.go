@@ -0,0 +1,68 @@
+package submission
+
+import (
+	"encoding/json"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sesv2"
+	"github.com/aws/aws-sdk-go-v2/service/sesv2/types"
+)
+
+// Mail is a mail of a submission, it travels through the queue so the mail
+// can be retried without the settings of the site
+type Mail struct {
+	From    string   `json:"from"`
+	To      []string `json:"to"`
+	ReplyTo []string `json:"replyTo,omitempty"`
+	// SES template rendering the mail with the data, the subject and text are
+	// sent as they are without it
+	Template string            `json:"template,omitempty"`
+	Data     map[string]string `json:"data,omitempty"`
+	Subject  string            `json:"subject,omitempty"`
+	Text     string            `json:"text,omitempty"`
+}
+
+// Delivery is the queued mail of a submission
+type Delivery struct {
+	Site string `json:"site"`
+	// Id of the stored submission, empty when storing it failed and for
+	// mails not tracked with the submission such as auto-replies
+	Id   string `json:"id,omitempty"`
+	Mail Mail   `json:"mail"`
+}
+
+// SendEmailInput returns the SES request sending the mail
+func (m Mail) SendEmailInput() *sesv2.SendEmailInput {
+	input := &sesv2.SendEmailInput{
+		FromEmailAddress: aws.String(m.From),
+		Destination:      &types.Destination{ToAddresses: m.To},
+		ReplyToAddresses: m.ReplyTo,
+	}
+	if m.Template != "" {
+		// A map of strings always encodes
+		data, _ := json.Marshal(m.Data)
+		input.Content = &types.EmailContent{
+			Template: &types.Template{
+				TemplateName: aws.String(m.Template),
+				TemplateData: aws.String(string(data)),
+			},
+		}
+		return input
+	}
+	utf8 := aws.String("UTF-8")
+	input.Content = &types.EmailContent{
+		Simple: &types.Message{
+			Subject: &types.Content{
+				Data:    aws.String(m.Subject),
+				Charset: utf8,
+			},
+			Body: &types.Body{
+				Text: &types.Content{
+					Data:    aws.String(m.Text),
+					Charset: utf8,
+				},
+			},
+		},
+	}
+	return input
+}
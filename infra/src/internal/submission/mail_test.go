@@ -0,0 +1,23 @@
+package submission
+
+import "testing"
+
+func TestMailSendEmailInput(t *testing.T) {
+	plain := Mail{From: "form@example.com", To: []string{"orders@example.com"}, Subject: "Order", Text: "Ahoj"}.SendEmailInput()
+	if plain.Content.Template != nil || *plain.Content.Simple.Subject.Data != "Order" || *plain.Content.Simple.Body.Text.Data != "Ahoj" {
+		t.Errorf("plain mail content %+v", plain.Content)
+	}
+
+	templated := Mail{
+		From:     "form@example.com",
+		To:       []string{"orders@example.com"},
+		Template: "shop-notification",
+		Data:     map[string]string{"name": "Jan \"Honza\" Novák"},
+	}.SendEmailInput()
+	if templated.Content.Simple != nil || *templated.Content.Template.TemplateName != "shop-notification" {
+		t.Errorf("templated mail content %+v", templated.Content)
+	}
+	if data := *templated.Content.Template.TemplateData; data != `{"name":"Jan \"Honza\" Novák"}` {
+		t.Errorf("template data = %s", data)
+	}
+}
@@ -0,0 +1,37 @@
+package submission
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+)
+
+// MessageSender is the part of the SQS client used by the queue, replaced in tests
+type MessageSender interface {
+	SendMessage(ctx context.Context, params *sqs.SendMessageInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageOutput, error)
+}
+
+// Queue passes deliveries to the mail sender
+type Queue struct {
+	Sqs MessageSender
+	Url string
+}
+
+// Enqueue sends the delivery to the queue
+func (q Queue) Enqueue(ctx context.Context, delivery Delivery) error {
+	body, err := json.Marshal(delivery)
+	if err != nil {
+		return fmt.Errorf("encoding delivery of %s: %w", delivery.Id, err)
+	}
+	_, err = q.Sqs.SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:    aws.String(q.Url),
+		MessageBody: aws.String(string(body)),
+	})
+	if err != nil {
+		return fmt.Errorf("queueing delivery of %s: %w", delivery.Id, err)
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package submission
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+)
+
+// recordingSqs records the sent messages
+type recordingSqs struct {
+	sent []*sqs.SendMessageInput
+}
+
+func (r *recordingSqs) SendMessage(ctx context.Context, params *sqs.SendMessageInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageOutput, error) {
+	r.sent = append(r.sent, params)
+	return &sqs.SendMessageOutput{}, nil
+}
+
+func TestQueueEnqueue(t *testing.T) {
+	client := &recordingSqs{}
+	queue := Queue{Sqs: client, Url: "https://sqs.example.com/mail"}
+	delivery := Delivery{Site: "shop", Id: "1", Mail: Mail{From: "form@example.com", To: []string{"orders@example.com"}, Subject: "Order", Text: "Ahoj"}}
+	if err := queue.Enqueue(context.Background(), delivery); err != nil {
+		t.Fatal(err)
+	}
+	if *client.sent[0].QueueUrl != "https://sqs.example.com/mail" {
+		t.Errorf("queue url = %s", *client.sent[0].QueueUrl)
+	}
+	var queued Delivery
+	if err := json.Unmarshal([]byte(*client.sent[0].MessageBody), &queued); err != nil {
+		t.Fatal(err)
+	}
+	if queued.Id != "1" || queued.Mail.Subject != "Order" {
+		t.Errorf("queued %+v", queued)
+	}
+
+	input := queued.Mail.SendEmailInput()
+	if *input.FromEmailAddress != "form@example.com" || *input.Content.Simple.Body.Text.Data != "Ahoj" {
+		t.Errorf("send email input from %s with body %s", *input.FromEmailAddress, *input.Content.Simple.Body.Text.Data)
+	}
+}
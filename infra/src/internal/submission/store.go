@@ -0,0 +1,129 @@
+package submission
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Client is the part of the DynamoDB client used by the store, replaced in tests
+type Client interface {
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+	UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error)
+	Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error)
+	Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error)
+}
+
+// Store keeps submissions in a table keyed by the site and the id
+type Store struct {
+	Db    Client
+	Table string
+}
+
+func key(site string, id string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"site": &types.AttributeValueMemberS{Value: site},
+		"id":   &types.AttributeValueMemberS{Value: id},
+	}
+}
+
+// Put stores a new submission, an existing one with the same id is never replaced
+func (s Store) Put(ctx context.Context, submission Submission) error {
+	item, err := attributevalue.MarshalMap(submission)
+	if err != nil {
+		return fmt.Errorf("encoding submission %s: %w", submission.Id, err)
+	}
+	_, err = s.Db.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(s.Table),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(id)"),
+	})
+	if err != nil {
+		return fmt.Errorf("storing submission %s: %w", submission.Id, err)
+	}
+	return nil
+}
+
+// Attempted records a send attempt of the submission and its resulting status,
+// the error is kept for the export and cleared by a successful attempt
+func (s Store) Attempted(ctx context.Context, site string, id string, status string, sendErr error) error {
+	lastError := ""
+	if sendErr != nil {
+		lastError = sendErr.Error()
+	}
+	_, err := s.Db.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(s.Table),
+		Key:                 key(site, id),
+		ConditionExpression: aws.String("attribute_exists(id)"),
+		UpdateExpression:    aws.String("SET #status = :status, #lastError = :lastError ADD #attempts :one"),
+		ExpressionAttributeNames: map[string]string{
+			"#status":    "status",
+			"#lastError": "lastError",
+			"#attempts":  "attempts",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":status":    &types.AttributeValueMemberS{Value: status},
+			":lastError": &types.AttributeValueMemberS{Value: lastError},
+			":one":       &types.AttributeValueMemberN{Value: strconv.Itoa(1)},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("updating submission %s: %w", id, err)
+	}
+	return nil
+}
+
+// List returns submissions made at or after since, of the site or of all
+// sites when it is empty. Submissions of a site are sorted by time
+func (s Store) List(ctx context.Context, site string, since time.Time) ([]Submission, error) {
+	items := make([]map[string]types.AttributeValue, 0)
+	if site != "" {
+		paginator := dynamodb.NewQueryPaginator(s.Db, &dynamodb.QueryInput{
+			TableName:              aws.String(s.Table),
+			KeyConditionExpression: aws.String("#site = :site AND #id >= :since"),
+			ExpressionAttributeNames: map[string]string{
+				"#site": "site",
+				"#id":   "id",
+			},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":site":  &types.AttributeValueMemberS{Value: site},
+				":since": &types.AttributeValueMemberS{Value: IdsSince(since)},
+			},
+		})
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("listing submissions of %s: %w", site, err)
+			}
+			items = append(items, page.Items...)
+		}
+	} else {
+		paginator := dynamodb.NewScanPaginator(s.Db, &dynamodb.ScanInput{
+			TableName:                aws.String(s.Table),
+			FilterExpression:         aws.String("#id >= :since"),
+			ExpressionAttributeNames: map[string]string{"#id": "id"},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":since": &types.AttributeValueMemberS{Value: IdsSince(since)},
+			},
+		})
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("listing submissions: %w", err)
+			}
+			items = append(items, page.Items...)
+		}
+	}
+
+	submissions := make([]Submission, 0, len(items))
+	if err := attributevalue.UnmarshalListOfMaps(items, &submissions); err != nil {
+		return nil, fmt.Errorf("decoding submissions: %w", err)
+	}
+	return submissions, nil
+}
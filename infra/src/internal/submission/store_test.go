@@ -0,0 +1,148 @@
+package submission
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// fakeClient records the requests and serves queries and scans in pages of one item
+type fakeClient struct {
+	puts    []*dynamodb.PutItemInput
+	updates []*dynamodb.UpdateItemInput
+	queries []*dynamodb.QueryInput
+	scans   []*dynamodb.ScanInput
+	items   []map[string]types.AttributeValue
+	err     error
+}
+
+func (c *fakeClient) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	c.puts = append(c.puts, params)
+	return &dynamodb.PutItemOutput{}, c.err
+}
+
+func (c *fakeClient) UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	c.updates = append(c.updates, params)
+	return &dynamodb.UpdateItemOutput{}, c.err
+}
+
+func (c *fakeClient) page(start map[string]types.AttributeValue) ([]map[string]types.AttributeValue, map[string]types.AttributeValue) {
+	index := 0
+	if start != nil {
+		index, _ = strconv.Atoi(start["index"].(*types.AttributeValueMemberN).Value)
+	}
+	if index >= len(c.items) {
+		return nil, nil
+	}
+	var next map[string]types.AttributeValue
+	if index+1 < len(c.items) {
+		next = map[string]types.AttributeValue{"index": &types.AttributeValueMemberN{Value: strconv.Itoa(index + 1)}}
+	}
+	return c.items[index : index+1], next
+}
+
+func (c *fakeClient) Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+	c.queries = append(c.queries, params)
+	items, next := c.page(params.ExclusiveStartKey)
+	return &dynamodb.QueryOutput{Items: items, LastEvaluatedKey: next}, c.err
+}
+
+func (c *fakeClient) Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+	c.scans = append(c.scans, params)
+	items, next := c.page(params.ExclusiveStartKey)
+	return &dynamodb.ScanOutput{Items: items, LastEvaluatedKey: next}, c.err
+}
+
+func testSubmission(id string) Submission {
+	return Submission{
+		Site:        "shop",
+		Id:          id,
+		SubmittedAt: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		Name:        "Jan",
+		Message:     "Ahoj",
+		Status:      StatusPending,
+	}
+}
+
+func TestStorePut(t *testing.T) {
+	client := &fakeClient{}
+	store := Store{Db: client, Table: "submissions"}
+	if err := store.Put(context.Background(), testSubmission("1")); err != nil {
+		t.Fatal(err)
+	}
+	put := client.puts[0]
+	if *put.TableName != "submissions" || *put.ConditionExpression != "attribute_not_exists(id)" {
+		t.Errorf("put %s with condition %s", *put.TableName, *put.ConditionExpression)
+	}
+	if _, ok := put.Item["phone"]; ok {
+		t.Error("empty phone stored")
+	}
+	if site := put.Item["site"].(*types.AttributeValueMemberS).Value; site != "shop" {
+		t.Errorf("site = %s", site)
+	}
+
+	client.err = errors.New("throttled")
+	if err := store.Put(context.Background(), testSubmission("2")); err == nil {
+		t.Error("put error not returned")
+	}
+}
+
+func TestStoreAttempted(t *testing.T) {
+	client := &fakeClient{}
+	store := Store{Db: client, Table: "submissions"}
+	if err := store.Attempted(context.Background(), "shop", "1", StatusRetrying, errors.New("throttled")); err != nil {
+		t.Fatal(err)
+	}
+	update := client.updates[0]
+	if id := update.Key["id"].(*types.AttributeValueMemberS).Value; id != "1" {
+		t.Errorf("updated id = %s", id)
+	}
+	values := update.ExpressionAttributeValues
+	if values[":status"].(*types.AttributeValueMemberS).Value != StatusRetrying || values[":lastError"].(*types.AttributeValueMemberS).Value != "throttled" {
+		t.Errorf("update values = %v", values)
+	}
+
+	if err := store.Attempted(context.Background(), "shop", "1", StatusSent, nil); err != nil {
+		t.Fatal(err)
+	}
+	if lastError := client.updates[1].ExpressionAttributeValues[":lastError"].(*types.AttributeValueMemberS).Value; lastError != "" {
+		t.Errorf("successful attempt kept error %q", lastError)
+	}
+}
+
+func TestStoreList(t *testing.T) {
+	client := &fakeClient{}
+	for _, id := range []string{"1", "2", "3"} {
+		item, err := attributevalue.MarshalMap(testSubmission(id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		client.items = append(client.items, item)
+	}
+	store := Store{Db: client, Table: "submissions"}
+	since := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	submissions, err := store.List(context.Background(), "shop", since)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(submissions) != 3 || submissions[2].Id != "3" || submissions[0].Name != "Jan" {
+		t.Errorf("listed %+v", submissions)
+	}
+	if len(client.queries) != 3 || len(client.scans) != 0 {
+		t.Errorf("%d queries and %d scans, want all pages queried", len(client.queries), len(client.scans))
+	}
+	if since := client.queries[0].ExpressionAttributeValues[":since"].(*types.AttributeValueMemberS).Value; since != "20240501T000000.000000Z" {
+		t.Errorf("since = %s", since)
+	}
+
+	if all, err := store.List(context.Background(), "", since); err != nil || len(all) != 3 || len(client.scans) != 3 {
+		t.Errorf("listing all sites = %d submissions, %v, %d scans", len(all), err, len(client.scans))
+	}
+}
@@ -0,0 +1,70 @@
+// Package submission stores contact form submissions and describes the mails
+// delivered for them, it is shared by the form handlers and the export command
+package submission
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Delivery states of a submission
+const (
+	StatusPending  = "pending"  // stored, the mail waits for its first attempt
+	StatusSent     = "sent"     // the mail was accepted by SES
+	StatusRetrying = "retrying" // sending failed, the mail waits for another attempt
+	StatusFailed   = "failed"   // all attempts failed, the mail is in the dead-letter queue or was never queued
+)
+
+// Layout of the time part of ids, ids of a site sort by the submission time
+const idTimeLayout = "20060102T150405.000000Z"
+
+// Submission is a contact form submission as stored in the table
+type Submission struct {
+	Site        string    `dynamodbav:"site"`
+	Id          string    `dynamodbav:"id"`
+	SubmittedAt time.Time `dynamodbav:"submittedAt"`
+	Name        string    `dynamodbav:"name"`
+	Phone       string    `dynamodbav:"phone,omitempty"`
+	Email       string    `dynamodbav:"email,omitempty"`
+	Message     string    `dynamodbav:"message"`
+	Status      string    `dynamodbav:"status"`
+	// Send attempts so far, including the failed ones
+	Attempts  int    `dynamodbav:"attempts"`
+	LastError string `dynamodbav:"lastError,omitempty"`
+}
+
+// NewId returns a unique id sorting by the submission time, the random
+// suffix keeps ids of simultaneous submissions apart
+func NewId(submittedAt time.Time, random io.Reader) (string, error) {
+	suffix := make([]byte, 4)
+	if _, err := io.ReadFull(random, suffix); err != nil {
+		return "", fmt.Errorf("generating submission id: %w", err)
+	}
+	return fmt.Sprintf("%s-%s", submittedAt.UTC().Format(idTimeLayout), hex.EncodeToString(suffix)), nil
+}
+
+// New returns a pending submission of the site with a new id
+func New(site string, submittedAt time.Time, name string, phone string, email string, message string) (Submission, error) {
+	id, err := NewId(submittedAt, rand.Reader)
+	if err != nil {
+		return Submission{}, err
+	}
+	return Submission{
+		Site:        site,
+		Id:          id,
+		SubmittedAt: submittedAt.UTC(),
+		Name:        name,
+		Phone:       phone,
+		Email:       email,
+		Message:     message,
+		Status:      StatusPending,
+	}, nil
+}
+
+// IdsSince returns the lowest id of submissions made at or after since
+func IdsSince(since time.Time) string {
+	return since.UTC().Format(idTimeLayout)
+}
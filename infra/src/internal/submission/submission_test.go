@@ -0,0 +1,56 @@
+package submission
+
+import (
+	"bytes"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestNewId(t *testing.T) {
+	at := time.Date(2024, 5, 1, 14, 30, 5, 123456789, time.FixedZone("CEST", 2*60*60))
+	id, err := NewId(at, bytes.NewReader([]byte{0xde, 0xad, 0xbe, 0xef}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "20240501T123005.123456Z-deadbeef" {
+		t.Errorf("id = %s", id)
+	}
+	if later, _ := NewId(at.Add(time.Second), bytes.NewReader(make([]byte, 4))); later <= id {
+		t.Errorf("id %s of a later submission sorts before %s", later, id)
+	}
+	if since := IdsSince(at); since > id {
+		t.Errorf("ids since %s exclude %s", since, id)
+	}
+	if _, err := NewId(at, bytes.NewReader(nil)); err == nil {
+		t.Error("id generated without randomness")
+	}
+}
+
+func TestNew(t *testing.T) {
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	s, err := New("shop", at, "Jan", "", "jan@example.cz", "Ahoj")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`^20240501T120000\.000000Z-[0-9a-f]{8}$`).MatchString(s.Id) {
+		t.Errorf("id = %s", s.Id)
+	}
+	if s.Site != "shop" || s.Status != StatusPending || s.Attempts != 0 || !s.SubmittedAt.Equal(at) {
+		t.Errorf("submission = %+v", s)
+	}
+}
+
+// failingReader fails every read
+type failingReader struct{}
+
+func (failingReader) Read(p []byte) (int, error) {
+	return 0, errors.New("no entropy")
+}
+
+func TestNewIdReadError(t *testing.T) {
+	if _, err := NewId(time.Now(), failingReader{}); err == nil {
+		t.Error("read error not returned")
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	awscloudfront "github.com/aws/aws-sdk-go-v2/service/cloudfront"
+	"github.com/aws/aws-sdk-go-v2/service/cloudfront/types"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/cloudfront"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// Above this number of changed paths the whole distribution is invalidated,
+// a wildcard path is billed as a single one
+const maxInvalidationPaths = 15
+
+// cdnInvalidator removes outdated paths from the CloudFront cache
+type cdnInvalidator interface {
+	invalidate(ctx context.Context, distributionId string, reference string, paths []string) (string, error)
+}
+
+// openInvalidator creates the CloudFront invalidator, replaced in tests
+var openInvalidator = newCloudfrontInvalidator
+
+// cloudfrontInvalidator is a cdnInvalidator backed by the CloudFront API
+type cloudfrontInvalidator struct {
+	client *awscloudfront.Client
+}
+
+func newCloudfrontInvalidator(ctx context.Context) (cdnInvalidator, error) {
+	// CloudFront is a global service with the API in us-east-1
+	cfg, err := awsconfig.LoadDefaultConfig(ctx, awsconfig.WithRegion("us-east-1"))
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS config: %w", err)
+	}
+	return &cloudfrontInvalidator{client: awscloudfront.NewFromConfig(cfg)}, nil
+}
+
+func (i *cloudfrontInvalidator) invalidate(ctx context.Context, distributionId string, reference string, paths []string) (string, error) {
+	output, err := i.client.CreateInvalidation(ctx, &awscloudfront.CreateInvalidationInput{
+		DistributionId: aws.String(distributionId),
+		InvalidationBatch: &types.InvalidationBatch{
+			// Same reference and paths return the already created invalidation,
+			// see invalidationReference
+			CallerReference: aws.String(reference),
+			Paths: &types.Paths{
+				Items:    paths,
+				Quantity: aws.Int32(int32(len(paths))),
+			},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(output.Invalidation.Id), nil
+}
+
+func invalidationPaths(changedPaths []string) []string {
+	// Invalidates only changed paths unless there are too many of them
+	if len(changedPaths) > maxInvalidationPaths {
+		return []string{"/*"}
+	}
+	return changedPaths
+}
+
+func invalidationReference(project staticSiteProject, manifestHash string, now time.Time) string {
+	// Unique per deploy, a deploy going back to an earlier manifest must not
+	// get the invalidation of the earlier deploy returned by CloudFront
+	return fmt.Sprintf("%s-%s-%d", project.name, manifestHash, now.UnixNano())
+}
+
+func invalidateChangedContent(ctx *pulumi.Context, project staticSiteProject, content *siteContent, distribution *cloudfront.Distribution) pulumi.StringOutput {
+	// Invalidates cached paths changed by this deploy, returns the invalidation ID
+	// or an empty string when nothing changed
+	return pulumi.All(content.ChangedPaths, content.ManifestHash, distribution.ID()).ApplyTWithContext(ctx.Context(), func(goCtx context.Context, args []interface{}) (string, error) {
+		changedPaths, manifestHash, distributionId := args[0].([]string), args[1].(string), string(args[2].(pulumi.ID))
+		if len(changedPaths) == 0 {
+			return "", nil
+		}
+		paths := invalidationPaths(changedPaths)
+		if ctx.DryRun() {
+			log.Printf("Cloudfront invalidation of %s planned for paths %v\n", project.name, paths)
+			return "", nil
+		}
+
+		invalidator, err := openInvalidator(goCtx)
+		if err != nil {
+			return "", err
+		}
+		invalidationId, err := invalidator.invalidate(goCtx, distributionId, invalidationReference(project, manifestHash, time.Now()), paths)
+		if err != nil {
+			return "", fmt.Errorf("invalidating %s: %w", project.name, err)
+		}
+		log.Printf("Cloudfront invalidation %s of %s for paths %v\n", invalidationId, project.name, paths)
+		return invalidationId, nil
+	}).(pulumi.StringOutput)
+}
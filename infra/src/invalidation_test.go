@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recordingInvalidator is a cdnInvalidator which records requested invalidations
+type recordingInvalidator struct {
+	distributionId string
+	reference      string
+	paths          []string
+}
+
+func (i *recordingInvalidator) invalidate(ctx context.Context, distributionId string, reference string, paths []string) (string, error) {
+	i.distributionId, i.reference, i.paths = distributionId, reference, paths
+	return "I" + distributionId, nil
+}
+
+func useRecordingInvalidator(t *testing.T) *recordingInvalidator {
+	// Replaces the CloudFront invalidator for the duration of the test
+	t.Helper()
+	invalidator := &recordingInvalidator{}
+	original := openInvalidator
+	openInvalidator = func(ctx context.Context) (cdnInvalidator, error) {
+		return invalidator, nil
+	}
+	t.Cleanup(func() { openInvalidator = original })
+	return invalidator
+}
+
+func TestInvalidationPaths(t *testing.T) {
+	few := []string{"/index.html", "/style.css"}
+	if got := invalidationPaths(few); !reflect.DeepEqual(got, few) {
+		t.Errorf("paths = %v, want %v", got, few)
+	}
+	many := make([]string, maxInvalidationPaths+1)
+	for i := range many {
+		many[i] = fmt.Sprintf("/images/%d.webp", i)
+	}
+	if got := invalidationPaths(many); !reflect.DeepEqual(got, []string{"/*"}) {
+		t.Errorf("paths = %v, want /*", got)
+	}
+}
+
+func TestDeployProjectInvalidatesChangedContent(t *testing.T) {
+	store := useMemoryContentStore(t)
+	manifest, err := buildContentManifest(testSiteDir, objectSettings{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only index.html differs from the previously deployed content
+	store.manifest = contentManifest{}
+	for key, entry := range manifest {
+		store.manifest[key] = entry
+	}
+	store.manifest["index.html"] = manifestEntry{SHA256: "outdated"}
+	invalidator := useRecordingInvalidator(t)
+
+	_, err = runWithMocks(t, "test", nil, deployTestProject(testProject("example.com", "www.example.com")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if invalidator.distributionId != "example.com-cdn-id" {
+		t.Errorf("invalidated distribution %q", invalidator.distributionId)
+	}
+	if want := []string{"/", "/index.html"}; !reflect.DeepEqual(invalidator.paths, want) {
+		t.Errorf("invalidated paths = %v, want %v", invalidator.paths, want)
+	}
+	if want := "test-site-" + manifest.hash() + "-"; !strings.HasPrefix(invalidator.reference, want) {
+		t.Errorf("caller reference = %s, want prefix %s", invalidator.reference, want)
+	}
+	if len(store.uploads) != 0 {
+		t.Errorf("objects mode must not upload through the store: %v", store.uploads)
+	}
+}
+
+func TestInvalidationReferenceUniquePerDeploy(t *testing.T) {
+	// Reverting to the content of an earlier deploy invalidates again
+	project := testProject("example.com")
+	deployed := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	earlier := invalidationReference(project, "hash", deployed)
+	reverted := invalidationReference(project, "hash", deployed.Add(time.Hour))
+	if earlier == reverted {
+		t.Errorf("invalidations of the same manifest share the reference %s", earlier)
+	}
+}
+
+func TestDeployProjectWithoutChangesSkipsInvalidation(t *testing.T) {
+	store := useMemoryContentStore(t)
+	manifest, err := buildContentManifest(testSiteDir, objectSettings{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.manifest = manifest
+	invalidator := useRecordingInvalidator(t)
+
+	_, err = runWithMocks(t, "test", nil, deployTestProject(testProject("example.com")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if invalidator.paths != nil {
+		t.Errorf("unexpected invalidation of %v", invalidator.paths)
+	}
+}
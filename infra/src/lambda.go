@@ -3,283 +3,148 @@ package main
 import (
 	"fmt"
 	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/pulumi/pulumi-archive/sdk/go/archive"
-	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws"
 	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/lambda"
 	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws/iam"
 	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
 )
 
-func lambdaRedirect(ctx *pulumi.Context) *lambda.Function {
-	lambdaName := "lambda-redirect"
-	lambdaArchive := fmt.Sprintf("../dist/%s.zip", lambdaName)
+// Runtime of the Go handlers, which are built as the bootstrap executable
+const goHandlerRuntime = "provided.al2023"
 
-	eastRegion, err := aws.NewProvider(ctx, fmt.Sprintf("lambda-redirect-east"), &aws.ProviderArgs{
-		// Cloudfront control plane resides in us-east-1 region, so edge lambdas must be deployed there
-		Region: pulumi.String("us-east-1"),
-	})
-
-	assumeRole, err := iam.GetPolicyDocument(ctx, &iam.GetPolicyDocumentArgs{
-		Statements: []iam.GetPolicyDocumentStatement{
-			{
-				Effect: pulumi.StringRef("Allow"),
-				Principals: []iam.GetPolicyDocumentStatementPrincipal{
-					{
-						Type: "Service",
-						Identifiers: []string{
-							"lambda.amazonaws.com",
-							"edgelambda.amazonaws.com",
-						},
-					},
-				},
-				Actions: []string{
-					"sts:AssumeRole",
-				},
-			},
-		},
-	}, nil)
-	handleErr(err)
-
-	log.Println("Creating IAM for redirect lambda")
-	iamName := fmt.Sprintf("%s-iam", lambdaName)
-	iamForLambda, err := iam.NewRole(ctx, iamName, &iam.RoleArgs{
-		Name:             pulumi.String(iamName),
-		AssumeRolePolicy: pulumi.String(assumeRole.Json),
-	})
-	handleErr(err)
-
-	log.Println("Updating lambda policies to allow sending mails")
-
-	cloudfrontPolicy, err := iam.NewPolicy(ctx, "cloudfront_policy", &iam.PolicyArgs{
-		Description: pulumi.String("Policy to allow lambda edge execution"),
-		Policy: pulumi.String(`{
-			"Version": "2012-10-17",
-			"Statement": [
-				{
-					"Effect": "Allow",
-					"Action": [
-						"lambda:GetFunction",
-						"lambda:EnableReplication*",
-						"lambda:DisableReplication*",
-						"iam:CreateServiceLinkedRole",
-						"cloudfront:UpdateDistribution",
-						"cloudfront:UpdateDistribution"
-					],
-					"Resource": "*"
-				}
-			]
-		}`),
-	})
-	handleErr(err)
+// buildHandler compiles a Go handler package, replaced in tests
+var buildHandler = goBuildHandler
 
-	_, err = iam.NewRolePolicyAttachment(ctx, "cloudfront_policy_attachment", &iam.RolePolicyAttachmentArgs{
-		Role:      iamForLambda.Name,
-		PolicyArn: cloudfrontPolicy.Arn,
-	})
-	handleErr(err)
+func goBuildHandler(pkg string, outDir string) error {
+	// Build is reproducible, so unchanged handlers keep their source code hash
+	log.Printf("Building Go lambda handler %s\n", pkg)
+	cmd := exec.Command("go", "build", "-trimpath", "-buildvcs=false", "-ldflags=-s -w -buildid=",
+		"-tags", "lambda.norpc", "-o", filepath.Join(outDir, "bootstrap"), pkg)
+	cmd.Env = append(os.Environ(), "GOOS=linux", "GOARCH=arm64", "CGO_ENABLED=0")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("building %s: %w\n%s", pkg, err, out)
+	}
+	return nil
+}
 
-	log.Println("Archiving redirect mjs lambda")
-	lambda_lookup_file, err := archive.LookupFile(ctx, &archive.LookupFileArgs{
+func goHandlerArchive(ctx *pulumi.Context, name string, pkg string, opts ...pulumi.InvokeOption) (string, *archive.LookupFileResult, error) {
+	// Builds the handler package and zips it for the provided.al2023 runtime
+	buildDir := fmt.Sprintf("../dist/%s", name)
+	lambdaArchive := fmt.Sprintf("../dist/%s.zip", name)
+	if err := buildHandler(pkg, buildDir); err != nil {
+		return "", nil, resourceErr(name, err)
+	}
+	lookupFile, err := archive.LookupFile(ctx, &archive.LookupFileArgs{
 		Type:       "zip",
-		SourceFile: pulumi.StringRef("./lambda/lambda_redirect.mjs"),
+		SourceDir:  pulumi.StringRef(buildDir),
 		OutputPath: lambdaArchive,
-	}, nil)
-	handleErr(err)
-
-	lambdaLogging := lambdaLogs(ctx, iamForLambda, lambdaName)
-
-	// Create the Lambda Function itself
-	log.Println("Creating redirect lambda")
-	lambdaFunction, err := lambda.NewFunction(ctx, lambdaName, &lambda.FunctionArgs{
-		Code:           pulumi.NewFileArchive(lambdaArchive),
-		Name:           pulumi.String(lambdaName),
-		Role:           iamForLambda.Arn,
-		Handler:        pulumi.String("lambda_redirect.handler"),
-		SourceCodeHash: pulumi.String(lambda_lookup_file.OutputBase64sha256),
-		Runtime:        pulumi.String("nodejs20.x"),
-		Publish:        pulumi.Bool(true),
-		MemorySize:     pulumi.Int(128),
-	}, pulumi.DependsOn([]pulumi.Resource{lambdaLogging}), pulumi.Provider(eastRegion))
+	}, opts...)
+	if err != nil {
+		return "", nil, resourceErr(lambdaArchive, err)
+	}
+	return lambdaArchive, lookupFile, nil
+}
 
+func lambdaPrecompress(ctx *pulumi.Context) (*lambda.Function, error) {
+	// CloudFront Functions cannot run on origin requests, so the variants are
+	// still picked by Lambda@Edge. It supports only the Node.js and Python
+	// runtimes, so unlike the form handlers it stays in JavaScript. It is the
+	// replicated lambda-redirect function deployed before the redirects moved
+	// to CloudFront Functions, so it is kept even without pre-compressed sites:
+	// deleting it would fail the update detaching it from the distributions
+	log.Println("Creating precompress lambda")
+	precompress, err := newSiteFunction(ctx, "lambda-precompress", siteFunctionArgs{
+		source:         "./lambda/lambda_precompress.mjs",
+		handler:        "lambda_precompress.handler",
+		runtime:        "nodejs20.x",
+		region:         edgeRegion,
+		edge:           true,
+		memorySize:     128,
+		legacyName:     "lambda-redirect",
+		legacyProvider: "lambda-redirect-east",
+	})
 	if err != nil {
-		handleErr(err)
+		return nil, err
 	}
 
 	// Export outputs
-	ctx.Export("lambda_redirect_arn", lambdaFunction.QualifiedArn)
+	ctx.Export("lambda_precompress_arn", precompress.QualifiedArn)
 
-	return lambdaFunction
+	return precompress.function, nil
 }
 
-func lambdaEmailForm(ctx *pulumi.Context) *lambda.Function {
-	lambdaArchive := "../dist/lambda_send_mail.zip"
-
-	assumeRole, err := iam.GetPolicyDocument(ctx, &iam.GetPolicyDocumentArgs{
-		Statements: []iam.GetPolicyDocumentStatement{
-			{
-				Effect: pulumi.StringRef("Allow"),
-				Principals: []iam.GetPolicyDocumentStatementPrincipal{
-					{
-						Type: "Service",
-						Identifiers: []string{
-							"lambda.amazonaws.com",
-						},
-					},
-				},
-				Actions: []string{
-					"sts:AssumeRole",
-				},
-			},
+func lambdaEmailForm(ctx *pulumi.Context, project staticSiteProject, origins []string, templates formTemplates, backend emailFormBackend) (*lambda.Function, error) {
+	// Form settings of the site are passed to the shared handler as environment
+	form := project.contactForm
+	log.Printf("Creating contact form lambda of %s\n", project.name)
+	environment := map[string]string{
+		"SITE":              project.name,
+		"MAIL_FROM":         form.Sender,
+		"MAIL_TO":           strings.Join(form.Recipients, ","),
+		"SUBJECT_TEMPLATE":  form.subject(),
+		"ALLOW_ORIGINS":     strings.Join(origins, ","),
+		"MIN_FILL_SECONDS":  strconv.Itoa(form.minFillSeconds()),
+		"RATE_LIMIT_TABLE":  rateLimitTableName,
+		"RATE_LIMIT_MAX":    strconv.Itoa(form.rateLimit().PerIp),
+		"SUBMISSIONS_TABLE": submissionsTableName,
+		"MAIL_TEMPLATE":     templates.notification,
+	}
+	if templates.autoReply != "" {
+		environment["AUTO_REPLY_TEMPLATE"] = templates.autoReply
+	}
+	captcha, secrets := captchaEnvironment(ctx, form)
+	for key, value := range captcha {
+		environment[key] = value
+	}
+	outputs := map[string]pulumi.StringInput{"MAIL_QUEUE_URL": backend.queueUrl}
+	for key, value := range secrets {
+		outputs[key] = value
+	}
+	mail, err := newSiteFunction(ctx, fmt.Sprintf("%s-contact-form", project.name), siteFunctionArgs{
+		source:      "./lambda/sendmail",
+		runtime:     goHandlerRuntime,
+		environment: environment,
+		outputs:     outputs,
+		statements: []iam.GetPolicyDocumentStatement{
+			policyStatement("ses:SendEmail", "ses:SendRawEmail", "ses:SendTemplatedEmail"),
+			tableStatement(rateLimitTableName, "dynamodb:UpdateItem"),
+			tableStatement(submissionsTableName, "dynamodb:PutItem", "dynamodb:UpdateItem"),
+			queueStatement(mailQueueName, "sqs:SendMessage"),
 		},
-	}, nil)
-	handleErr(err)
-
-	log.Println("Creating IAM for sending mail lambda")
-	iamForLambda, err := iam.NewRole(ctx, "iam_for_lambda", &iam.RoleArgs{
-		Name:             pulumi.String("iam_for_lambda"),
-		AssumeRolePolicy: pulumi.String(assumeRole.Json),
-	})
-	handleErr(err)
-
-	log.Println("Updating lambda policies to allow sending mails")
-
-	sesPolicy, err := iam.NewPolicy(ctx, "ses_policy", &iam.PolicyArgs{
-		Description: pulumi.String("Policy to allow sending mails through lambda"),
-		Policy: pulumi.String(`{
-            "Version": "2012-10-17",
-            "Statement": [
-                {
-                    "Effect": "Allow",
-                    "Action": [
-                        "ses:SendEmail",
-                        "ses:SendRawEmail"
-                    ],
-                    "Resource": "*"
-                }
-            ]
-        }`),
 	})
-	handleErr(err)
-
-	_, err = iam.NewRolePolicyAttachment(ctx, "ses_policy_attachment", &iam.RolePolicyAttachmentArgs{
-		Role:      iamForLambda.Name,
-		PolicyArn: sesPolicy.Arn,
-	})
-	handleErr(err)
-
-	log.Println("Archiving send_mail.js lambda")
-	lambda_lookup_file, err := archive.LookupFile(ctx, &archive.LookupFileArgs{
-		Type:       "zip",
-		SourceFile: pulumi.StringRef("./lambda/lambda_send_mail.mjs"),
-		OutputPath: lambdaArchive,
-	}, nil)
-	handleErr(err)
-
-	lambdaLogging := lambdaLogs(ctx, iamForLambda, "email_form")
-
-	// Create the Lambda Function itself
-	log.Println("Creating email_form lambda")
-	lambdaFunction, err := lambda.NewFunction(ctx, "email_form", &lambda.FunctionArgs{
-		Code:           pulumi.NewFileArchive(lambdaArchive),
-		Name:           pulumi.String("lambda-email-form"),
-		Role:           iamForLambda.Arn,
-		Handler:        pulumi.String("lambda_send_mail.handler"),
-		SourceCodeHash: pulumi.String(lambda_lookup_file.OutputBase64sha256),
-		Runtime:        pulumi.String(lambda.RuntimeNodeJS18dX),
-	}, pulumi.DependsOn([]pulumi.Resource{lambdaLogging}))
-
 	if err != nil {
-		handleErr(err)
+		return nil, err
 	}
-	return lambdaFunction
+	return mail.function, nil
 }
 
-func lambdaEmailFormCors(ctx *pulumi.Context) *lambda.Function {
-	lambdaArchive := "../dist/lambda_cors.zip"
-
-	assumeRole, err := iam.GetPolicyDocument(ctx, &iam.GetPolicyDocumentArgs{
-		Statements: []iam.GetPolicyDocumentStatement{
-			{
-				Effect: pulumi.StringRef("Allow"),
-				Principals: []iam.GetPolicyDocumentStatementPrincipal{
-					{
-						Type: "Service",
-						Identifiers: []string{
-							"lambda.amazonaws.com",
-						},
-					},
-				},
-				Actions: []string{
-					"sts:AssumeRole",
-				},
-			},
+func lambdaMailQueueSender(ctx *pulumi.Context, queueUrl pulumi.StringOutput) (*lambda.Function, error) {
+	// Sends the queued mails of all sites, they carry their template and data
+	log.Println("Creating mail queue sender lambda")
+	sender, err := newSiteFunction(ctx, "email-form-mail-sender", siteFunctionArgs{
+		source:  "./lambda/mailqueue",
+		runtime: goHandlerRuntime,
+		environment: map[string]string{
+			"SUBMISSIONS_TABLE": submissionsTableName,
+			"MAX_RECEIVE_COUNT": strconv.Itoa(mailMaxReceiveCount),
 		},
-	}, nil)
-	handleErr(err)
-
-	log.Println("Creating IAM for cors lambda")
-	iamForLambda, err := iam.NewRole(ctx, "lambda_cors_iam", &iam.RoleArgs{
-		Name:             pulumi.String("lambda_cors_iam"),
-		AssumeRolePolicy: pulumi.String(assumeRole.Json),
-	})
-	handleErr(err)
-
-	log.Println("Archiving cors.mjs lambda")
-	lambda_lookup_file, err := archive.LookupFile(ctx, &archive.LookupFileArgs{
-		Type:       "zip",
-		SourceFile: pulumi.StringRef("./lambda/lambda_cors.mjs"),
-		OutputPath: lambdaArchive,
-	}, nil)
-	handleErr(err)
-
-	log.Println("Creating cors lambda")
-	lambdaFunction, err := lambda.NewFunction(ctx, "cors", &lambda.FunctionArgs{
-		Code:           pulumi.NewFileArchive(lambdaArchive),
-		Name:           pulumi.String("lambda-cors"),
-		Role:           iamForLambda.Arn,
-		Handler:        pulumi.String("lambda_cors.handler"),
-		SourceCodeHash: pulumi.String(lambda_lookup_file.OutputBase64sha256),
-		Runtime:        pulumi.String(lambda.RuntimeNodeJS18dX),
-	})
-	handleErr(err)
-	return lambdaFunction
-}
-
-func lambdaLogs(ctx *pulumi.Context, iamRole *iam.Role, name string) *iam.RolePolicyAttachment {
-	// Create log group for lambda
-	lambdaLoggingPolicyDocument, err := iam.GetPolicyDocument(ctx, &iam.GetPolicyDocumentArgs{
-		Statements: []iam.GetPolicyDocumentStatement{
-			{
-				Effect: pulumi.StringRef("Allow"),
-				Actions: []string{
-					"logs:CreateLogGroup",
-					"logs:CreateLogStream",
-					"logs:PutLogEvents",
-				},
-				Resources: []string{
-					"arn:aws:logs:*:*:*",
-				},
-			},
+		outputs: map[string]pulumi.StringInput{
+			"MAIL_QUEUE_URL": queueUrl,
+		},
+		statements: []iam.GetPolicyDocumentStatement{
+			policyStatement("ses:SendEmail", "ses:SendRawEmail", "ses:SendTemplatedEmail"),
+			tableStatement(submissionsTableName, "dynamodb:UpdateItem"),
+			queueStatement(mailQueueName, "sqs:ReceiveMessage", "sqs:DeleteMessage", "sqs:GetQueueAttributes", "sqs:ChangeMessageVisibility"),
 		},
-	}, nil)
-	handleErr(err)
-
-	loggingPolicyName := fmt.Sprintf("%s-logging", name)
-
-	lambdaLoggingPolicy, err := iam.NewPolicy(ctx, loggingPolicyName, &iam.PolicyArgs{
-		Name:        pulumi.String(loggingPolicyName),
-		Path:        pulumi.String("/"),
-		Description: pulumi.String("IAM policy for logging from a lambda"),
-		Policy:      pulumi.String(lambdaLoggingPolicyDocument.Json),
-	})
-	handleErr(err)
-
-	lambdaLogs, err := iam.NewRolePolicyAttachment(ctx, name, &iam.RolePolicyAttachmentArgs{
-		Role:      pulumi.Any(iamRole.Name),
-		PolicyArn: lambdaLoggingPolicy.Arn,
 	})
-	handleErr(err)
-
-	return lambdaLogs
+	if err != nil {
+		return nil, err
+	}
+	return sender.function, nil
 }
@@ -0,0 +1,91 @@
+// Package response builds API Gateway proxy responses shared by the form handlers
+package response
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// CorsHeaders allows the origin of the request to call the form endpoint when
+// it is one of the allowed origins, other origins get the first allowed one.
+// They match the preflight response API Gateway answers for the endpoint
+func CorsHeaders(allowedOrigins []string, request events.APIGatewayProxyRequest) map[string]string {
+	allowOrigin := ""
+	if len(allowedOrigins) > 0 {
+		allowOrigin = allowedOrigins[0]
+	}
+	if origin, allowed := AllowedOrigin(allowedOrigins, request); allowed && origin != "" {
+		allowOrigin = origin
+	}
+	return map[string]string{
+		"Access-Control-Allow-Origin":  allowOrigin,
+		"Access-Control-Allow-Headers": "Content-Type",
+		"Access-Control-Allow-Methods": "OPTIONS,POST",
+		"Vary":                         "Origin",
+	}
+}
+
+// AllowedOrigin returns the origin of the request and whether it may call the
+// endpoint. Requests without an origin do not come from a browser page, so
+// they are allowed
+func AllowedOrigin(allowedOrigins []string, request events.APIGatewayProxyRequest) (string, bool) {
+	origin := requestOrigin(request)
+	if origin == "" {
+		return "", true
+	}
+	for _, allowed := range allowedOrigins {
+		if strings.EqualFold(allowed, origin) {
+			return origin, true
+		}
+	}
+	return origin, false
+}
+
+func requestOrigin(request events.APIGatewayProxyRequest) string {
+	// Header names keep the case sent by the client
+	for name, value := range request.Headers {
+		if strings.EqualFold(name, "Origin") {
+			return value
+		}
+	}
+	return ""
+}
+
+// SplitList splits a comma separated environment variable
+func SplitList(value string) []string {
+	list := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// JSON returns a response with the body serialized as JSON
+func JSON(statusCode int, headers map[string]string, body interface{}) events.APIGatewayProxyResponse {
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		log.Printf("Encoding response body: %v\n", err)
+		statusCode, encoded = 500, []byte(`{"message":"Internal error"}`)
+	}
+	withType := map[string]string{"Content-Type": "application/json"}
+	for key, value := range headers {
+		withType[key] = value
+	}
+	return events.APIGatewayProxyResponse{
+		StatusCode: statusCode,
+		Headers:    withType,
+		Body:       string(encoded),
+	}
+}
+
+// Message is the body of responses without any data, the code is stable
+// so clients can map it to their own localized messages
+type Message struct {
+	Code    string `json:"code,omitempty"`
+	Message string `json:"message"`
+}
@@ -0,0 +1,39 @@
+package response
+
+import (
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+var testOrigins = []string{"https://example.com", "https://www.example.com"}
+
+func TestCorsHeaders(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    string
+	}{
+		{"allowed", map[string]string{"Origin": "https://www.example.com"}, "https://www.example.com"},
+		{"lower case header", map[string]string{"origin": "https://www.example.com"}, "https://www.example.com"},
+		{"unknown", map[string]string{"Origin": "https://evil.example"}, "https://example.com"},
+		{"none", nil, "https://example.com"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			headers := CorsHeaders(testOrigins, events.APIGatewayProxyRequest{Headers: test.headers})
+			if headers["Access-Control-Allow-Origin"] != test.want || headers["Access-Control-Allow-Methods"] != "OPTIONS,POST" || headers["Vary"] != "Origin" {
+				t.Errorf("headers = %v, want origin %s", headers, test.want)
+			}
+		})
+	}
+}
+
+func TestAllowedOrigin(t *testing.T) {
+	if _, allowed := AllowedOrigin(testOrigins, events.APIGatewayProxyRequest{Headers: map[string]string{"Origin": "https://evil.example"}}); allowed {
+		t.Error("unknown origin allowed")
+	}
+	if origin, allowed := AllowedOrigin(testOrigins, events.APIGatewayProxyRequest{}); !allowed || origin != "" {
+		t.Errorf("request without origin = %q, %v", origin, allowed)
+	}
+}
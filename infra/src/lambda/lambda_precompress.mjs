@@ -0,0 +1,82 @@
+// Extensions with pre-compressed variants uploaded next to the original object
+const compressibleExtensions = ['.html', '.css', '.js', '.mjs', '.json', '.svg', '.xml'];
+
+// Preferred encodings first, suffixes match the uploaded variants
+const encodings = [
+    { name: 'br', suffix: '.br' },
+    { name: 'gzip', suffix: '.gz' },
+];
+
+const acceptedEncodings = (request) => {
+    const accepted = new Set();
+    for (const header of request.headers['accept-encoding'] || []) {
+        for (const part of header.value.split(',')) {
+            const [name, ...params] = part.trim().toLowerCase().split(';');
+            const refused = params.some((param) => /^\s*q\s*=\s*0(\.0*)?\s*$/.test(param));
+            if (name && !refused) {
+                accepted.add(name.trim());
+            }
+        }
+    }
+    return accepted;
+};
+
+// Origin custom header with the index document of the site, the function is
+// shared by the sites so it cannot be configured otherwise
+const indexDocumentHeader = 'x-index-document';
+
+const indexDocument = (request) => {
+    const origin = request.origin ? request.origin.custom || request.origin.s3 : null;
+    const header = origin && origin.customHeaders ? origin.customHeaders[indexDocumentHeader] : null;
+    return header && header.length > 0 ? header[0].value : '';
+};
+
+const compressible = (uri) => {
+    // Directory requests without an index document are left to the origin
+    return !uri.endsWith('/') && compressibleExtensions.some((ext) => uri.toLowerCase().endsWith(ext));
+};
+
+const originRequest = (request) => {
+    // Directories are resolved to their index document, so its variant is
+    // picked and the response varies by Accept-Encoding like the document's
+    const index = indexDocument(request);
+    if (index && request.uri.endsWith('/')) {
+        request.uri = `${request.uri}${index}`;
+    }
+    const uri = request.uri;
+    if (!compressible(uri)) {
+        return request;
+    }
+
+    const accepted = acceptedEncodings(request);
+    const encoding = encodings.find((candidate) => accepted.has(candidate.name));
+    if (encoding) {
+        request.uri = `${uri}${encoding.suffix}`;
+    }
+    return request;
+};
+
+const originResponse = (request, response) => {
+    // The body depends on the Accept-Encoding of the viewer, browser and proxy
+    // caches must not hand a compressed body to clients which cannot decode it.
+    // The request carries the uri of the variant picked on the origin request
+    const uri = request.uri.replace(/\.(br|gz)$/, '');
+    if (!compressible(uri)) {
+        return response;
+    }
+    const vary = (response.headers['vary'] || []).map((header) => header.value);
+    if (!vary.some((value) => value.toLowerCase().split(',').some((name) => name.trim() === 'accept-encoding'))) {
+        vary.push('Accept-Encoding');
+    }
+    response.headers['vary'] = [{ key: 'Vary', value: vary.join(', ') }];
+    return response;
+};
+
+export const handler = async (event) => {
+    // Origin request and response handler of the sites with pre-compressed variants
+    const { config, request, response } = event.Records[0].cf;
+    if (config.eventType === 'origin-response') {
+        return originResponse(request, response);
+    }
+    return originRequest(request);
+};
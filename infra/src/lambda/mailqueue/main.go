@@ -0,0 +1,141 @@
+// Command mailqueue sends the queued mails of contact form submissions through SES
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/sesv2"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+
+	"sramek.com/m/v2/internal/submission"
+)
+
+const (
+	// Delay before the second attempt, it doubles with every further one
+	baseRetryDelay = 30 * time.Second
+	// Longest delay between two attempts
+	maxRetryDelay = 15 * time.Minute
+)
+
+// mailSender is the part of the SES client used by the handler, replaced in tests
+type mailSender interface {
+	SendEmail(ctx context.Context, params *sesv2.SendEmailInput, optFns ...func(*sesv2.Options)) (*sesv2.SendEmailOutput, error)
+}
+
+// visibilityChanger is the part of the SQS client used by the handler, replaced in tests
+type visibilityChanger interface {
+	ChangeMessageVisibility(ctx context.Context, params *sqs.ChangeMessageVisibilityInput, optFns ...func(*sqs.Options)) (*sqs.ChangeMessageVisibilityOutput, error)
+}
+
+// attemptRecorder records send attempts of the stored submissions
+type attemptRecorder interface {
+	Attempted(ctx context.Context, site string, id string, status string, sendErr error) error
+}
+
+// handler sends the queued mails, failed ones are returned to the queue
+// with a growing delay until SQS moves them to the dead-letter queue
+type handler struct {
+	ses         mailSender
+	sqs         visibilityChanger
+	queueUrl    string
+	submissions attemptRecorder
+	// Receives after which SQS moves a message to the dead-letter queue
+	maxReceives int
+}
+
+func retryDelay(receiveCount int) time.Duration {
+	// Exponential backoff of the attempts following the received one
+	delay := baseRetryDelay
+	for i := 1; i < receiveCount && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxRetryDelay)
+}
+
+func receiveCount(record events.SQSMessage) int {
+	count, err := strconv.Atoi(record.Attributes["ApproximateReceiveCount"])
+	if err != nil {
+		return 1
+	}
+	return count
+}
+
+func (h handler) backOff(ctx context.Context, record events.SQSMessage, count int) {
+	// Without the change the message returns after the visibility timeout of the queue
+	delay := retryDelay(count)
+	_, err := h.sqs.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          aws.String(h.queueUrl),
+		ReceiptHandle:     aws.String(record.ReceiptHandle),
+		VisibilityTimeout: int32(delay.Seconds()),
+	})
+	if err != nil {
+		log.Printf("Delaying message %s: %v\n", record.MessageId, err)
+	}
+}
+
+func (h handler) send(ctx context.Context, record events.SQSMessage) bool {
+	// Returns whether the message is done with, malformed messages are
+	// dropped as no attempt could ever send them
+	var delivery submission.Delivery
+	if err := json.Unmarshal([]byte(record.Body), &delivery); err != nil {
+		log.Printf("Dropping malformed message %s: %v\n", record.MessageId, err)
+		return true
+	}
+	_, sendErr := h.ses.SendEmail(ctx, delivery.Mail.SendEmailInput())
+	status := submission.StatusSent
+	if sendErr != nil {
+		count := receiveCount(record)
+		log.Printf("Sending email of submission %s, attempt %d of %d: %v\n", delivery.Id, count, h.maxReceives, sendErr)
+		status = submission.StatusRetrying
+		if count >= h.maxReceives {
+			status = submission.StatusFailed
+		} else {
+			h.backOff(ctx, record, count)
+		}
+	}
+	if delivery.Id != "" {
+		if err := h.submissions.Attempted(ctx, delivery.Site, delivery.Id, status, sendErr); err != nil {
+			log.Printf("Recording send attempt: %v\n", err)
+		}
+	}
+	return sendErr == nil
+}
+
+func (h handler) handle(ctx context.Context, event events.SQSEvent) (events.SQSEventResponse, error) {
+	// Only failed messages are reported, so the sent ones of the batch are not repeated
+	var failures []events.SQSBatchItemFailure
+	for _, record := range event.Records {
+		if !h.send(ctx, record) {
+			failures = append(failures, events.SQSBatchItemFailure{ItemIdentifier: record.MessageId})
+		}
+	}
+	return events.SQSEventResponse{BatchItemFailures: failures}, nil
+}
+
+func main() {
+	cfg, err := config.LoadDefaultConfig(context.Background())
+	if err != nil {
+		log.Fatalf("Loading AWS config: %v", err)
+	}
+	maxReceives, err := strconv.Atoi(os.Getenv("MAX_RECEIVE_COUNT"))
+	if err != nil {
+		log.Fatalf("Parsing MAX_RECEIVE_COUNT: %v", err)
+	}
+	lambda.Start(handler{
+		ses:         sesv2.NewFromConfig(cfg),
+		sqs:         sqs.NewFromConfig(cfg),
+		queueUrl:    os.Getenv("MAIL_QUEUE_URL"),
+		submissions: submission.Store{Db: dynamodb.NewFromConfig(cfg), Table: os.Getenv("SUBMISSIONS_TABLE")},
+		maxReceives: maxReceives,
+	}.handle)
+}
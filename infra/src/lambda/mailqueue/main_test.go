@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/sesv2"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+
+	"sramek.com/m/v2/internal/submission"
+)
+
+// failingSender fails the mails with the given subjects
+type failingSender struct {
+	failing map[string]bool
+	sent    []string
+}
+
+func (s *failingSender) SendEmail(ctx context.Context, params *sesv2.SendEmailInput, optFns ...func(*sesv2.Options)) (*sesv2.SendEmailOutput, error) {
+	subject := *params.Content.Simple.Subject.Data
+	if s.failing[subject] {
+		return nil, errors.New("throttled")
+	}
+	s.sent = append(s.sent, subject)
+	return &sesv2.SendEmailOutput{}, nil
+}
+
+// recordedAttempts keeps the recorded attempts
+type recordedAttempts []string
+
+func (r *recordedAttempts) Attempted(ctx context.Context, site string, id string, status string, sendErr error) error {
+	*r = append(*r, site+"/"+id+" "+status)
+	return nil
+}
+
+// recordingVisibility records the changed message visibility timeouts
+type recordingVisibility struct {
+	changes map[string]int32
+}
+
+func (r *recordingVisibility) ChangeMessageVisibility(ctx context.Context, params *sqs.ChangeMessageVisibilityInput, optFns ...func(*sqs.Options)) (*sqs.ChangeMessageVisibilityOutput, error) {
+	r.changes[*params.ReceiptHandle] = params.VisibilityTimeout
+	return &sqs.ChangeMessageVisibilityOutput{}, nil
+}
+
+func message(t *testing.T, messageId string, id string, receiveCount int) events.SQSMessage {
+	body, err := json.Marshal(submission.Delivery{
+		Site: "shop",
+		Id:   id,
+		Mail: submission.Mail{From: "form@example.com", To: []string{"orders@example.com"}, Subject: "Order " + messageId, Text: "Ahoj"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return events.SQSMessage{
+		MessageId:     messageId,
+		ReceiptHandle: "receipt-" + messageId,
+		Body:          string(body),
+		Attributes:    map[string]string{"ApproximateReceiveCount": strconv.Itoa(receiveCount)},
+	}
+}
+
+func TestHandleReportsFailedMessages(t *testing.T) {
+	sender := &failingSender{failing: map[string]bool{"Order m2": true, "Order m3": true, "Order m4": true}}
+	attempts := &recordedAttempts{}
+	visibility := &recordingVisibility{changes: make(map[string]int32)}
+	h := handler{ses: sender, sqs: visibility, queueUrl: "https://sqs.example.com/mail", submissions: attempts, maxReceives: 6}
+	resp, err := h.handle(context.Background(), events.SQSEvent{Records: []events.SQSMessage{
+		message(t, "m1", "1", 1),
+		message(t, "m2", "2", 1),
+		message(t, "m3", "3", 3),
+		message(t, "m4", "4", 6),
+		message(t, "m5", "", 1),
+		{MessageId: "m6", Body: "not json"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failed := make([]string, 0)
+	for _, failure := range resp.BatchItemFailures {
+		failed = append(failed, failure.ItemIdentifier)
+	}
+	if got := strings.Join(failed, ","); got != "m2,m3,m4" {
+		t.Errorf("batch item failures = %s", got)
+	}
+	if got := strings.Join(sender.sent, ","); got != "Order m1,Order m5" {
+		t.Errorf("sent %s", got)
+	}
+	want := "shop/1 sent,shop/2 retrying,shop/3 retrying,shop/4 failed"
+	if got := strings.Join(*attempts, ","); got != want {
+		t.Errorf("attempts %s, want %s", got, want)
+	}
+	// The last attempt is left to the redrive policy of the queue
+	if len(visibility.changes) != 2 || visibility.changes["receipt-m2"] != 30 || visibility.changes["receipt-m3"] != 120 {
+		t.Errorf("visibility changes = %v", visibility.changes)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	for count, want := range map[int]time.Duration{
+		1:   30 * time.Second,
+		2:   time.Minute,
+		4:   4 * time.Minute,
+		6:   maxRetryDelay,
+		100: maxRetryDelay,
+	} {
+		if got := retryDelay(count); got != want {
+			t.Errorf("retryDelay(%d) = %v, want %v", count, got, want)
+		}
+	}
+}
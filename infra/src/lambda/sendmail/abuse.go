@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// rateLimiter counts submissions of a client and tells whether another one is allowed
+type rateLimiter interface {
+	allow(ctx context.Context, client string) (bool, error)
+}
+
+// itemUpdater is the part of the DynamoDB client used by the rate limiter, replaced in tests
+type itemUpdater interface {
+	UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error)
+}
+
+// dynamoRateLimiter counts submissions of every client in fixed windows, the
+// counters expire through the TTL of the table
+type dynamoRateLimiter struct {
+	db     itemUpdater
+	table  string
+	site   string // counters of sites sharing the table are kept apart
+	max    int
+	window time.Duration
+	now    func() time.Time
+}
+
+func (l dynamoRateLimiter) allow(ctx context.Context, client string) (bool, error) {
+	start := l.now().Truncate(l.window)
+	out, err := l.db.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(l.table),
+		Key: map[string]types.AttributeValue{
+			"key": &types.AttributeValueMemberS{Value: fmt.Sprintf("%s#%s#%d", l.site, client, start.Unix())},
+		},
+		UpdateExpression: aws.String("ADD #count :one SET #expires = if_not_exists(#expires, :expires)"),
+		ExpressionAttributeNames: map[string]string{
+			"#count":   "count",
+			"#expires": "expires",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":one":     &types.AttributeValueMemberN{Value: "1"},
+			":expires": &types.AttributeValueMemberN{Value: strconv.FormatInt(start.Add(l.window).Unix(), 10)},
+		},
+		ReturnValues: types.ReturnValueUpdatedNew,
+	})
+	if err != nil {
+		return false, fmt.Errorf("counting submissions: %w", err)
+	}
+	count, ok := out.Attributes["count"].(*types.AttributeValueMemberN)
+	if !ok {
+		return false, fmt.Errorf("counting submissions: no count returned")
+	}
+	submissions, err := strconv.Atoi(count.Value)
+	if err != nil {
+		return false, fmt.Errorf("counting submissions: %w", err)
+	}
+	return submissions <= l.max, nil
+}
+
+func filledTooFast(form formRequest, minFill time.Duration) bool {
+	// Bots post the form right after loading it, forms without the elapsed
+	// time are treated the same way
+	if minFill <= 0 {
+		return false
+	}
+	return form.Elapsed <= 0 || time.Duration(form.Elapsed)*time.Millisecond < minFill
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// countingTable keeps the counters of the updated items in memory
+type countingTable struct {
+	counts  map[string]int
+	updates []*dynamodb.UpdateItemInput
+	err     error
+}
+
+func (c *countingTable) UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	c.updates = append(c.updates, params)
+	if c.err != nil {
+		return nil, c.err
+	}
+	key := params.Key["key"].(*types.AttributeValueMemberS).Value
+	c.counts[key]++
+	return &dynamodb.UpdateItemOutput{Attributes: map[string]types.AttributeValue{
+		"count": &types.AttributeValueMemberN{Value: strconv.Itoa(c.counts[key])},
+	}}, nil
+}
+
+func TestDynamoRateLimiter(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+	table := &countingTable{counts: make(map[string]int)}
+	limiter := dynamoRateLimiter{db: table, table: "limits", site: "shop", max: 2, window: time.Hour, now: func() time.Time { return now }}
+
+	for i, want := range []bool{true, true, false} {
+		allowed, err := limiter.allow(context.Background(), "192.0.2.1")
+		if err != nil || allowed != want {
+			t.Errorf("submission %d allowed = %v, %v, want %v", i+1, allowed, err, want)
+		}
+	}
+	if allowed, _ := limiter.allow(context.Background(), "192.0.2.2"); !allowed {
+		t.Error("other client limited")
+	}
+	now = now.Add(time.Hour)
+	if allowed, _ := limiter.allow(context.Background(), "192.0.2.1"); !allowed {
+		t.Error("client limited in the next window")
+	}
+
+	update := table.updates[0]
+	if key := update.Key["key"].(*types.AttributeValueMemberS).Value; key != "shop#192.0.2.1#1714564800" {
+		t.Errorf("counter key = %s", key)
+	}
+	if expires := update.ExpressionAttributeValues[":expires"].(*types.AttributeValueMemberN).Value; expires != "1714568400" {
+		t.Errorf("counter expires = %s", expires)
+	}
+	if *update.TableName != "limits" {
+		t.Errorf("table = %s", *update.TableName)
+	}
+
+	table.err = errors.New("throttled")
+	if _, err := limiter.allow(context.Background(), "192.0.2.1"); err == nil {
+		t.Error("table error not returned")
+	}
+}
+
+func TestFilledTooFast(t *testing.T) {
+	tests := []struct {
+		name    string
+		elapsed int64
+		minFill time.Duration
+		want    bool
+	}{
+		{"slow enough", 5000, 3 * time.Second, false},
+		{"too fast", 1000, 3 * time.Second, true},
+		{"negative", -60000, 3 * time.Second, true},
+		{"check disabled", 0, 0, false},
+	}
+	for _, test := range tests {
+		form := formRequest{Elapsed: test.elapsed}
+		if got := filledTooFast(form, test.minFill); got != test.want {
+			t.Errorf("%s: filledTooFast = %v, want %v", test.name, got, test.want)
+		}
+	}
+	if !filledTooFast(formRequest{}, time.Second) {
+		t.Error("form without elapsed time accepted")
+	}
+}
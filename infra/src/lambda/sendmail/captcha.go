@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Provider accepting any token, for local runs without a CAPTCHA widget
+const stubCaptchaProvider = "stub"
+
+// Verification endpoints of the supported providers, they share the same API
+var captchaEndpoints = map[string]string{
+	"turnstile": "https://challenges.cloudflare.com/turnstile/v0/siteverify",
+	"recaptcha": "https://www.google.com/recaptcha/api/siteverify",
+	"hcaptcha":  "https://api.hcaptcha.com/siteverify",
+}
+
+// captchaVerifier checks the CAPTCHA token solved by the client
+type captchaVerifier interface {
+	verify(ctx context.Context, token string, remoteIp string) (bool, error)
+}
+
+// siteVerifier verifies tokens with the siteverify API of the provider
+type siteVerifier struct {
+	client   *http.Client
+	endpoint string
+	secret   string
+}
+
+// stubVerifier accepts every non-empty token
+type stubVerifier struct{}
+
+func (stubVerifier) verify(ctx context.Context, token string, remoteIp string) (bool, error) {
+	return token != "", nil
+}
+
+func (v siteVerifier) verify(ctx context.Context, token string, remoteIp string) (bool, error) {
+	if token == "" {
+		return false, nil
+	}
+	form := url.Values{"secret": {v.secret}, "response": {token}}
+	if remoteIp != "" {
+		form.Set("remoteip", remoteIp)
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, v.endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return false, fmt.Errorf("verifying captcha: %w", err)
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := v.client.Do(request)
+	if err != nil {
+		return false, fmt.Errorf("verifying captcha: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("verifying captcha: status %s", resp.Status)
+	}
+	var result struct {
+		Success bool `json:"success"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return false, fmt.Errorf("verifying captcha: %w", err)
+	}
+	return result.Success, nil
+}
+
+func newCaptchaVerifier(provider string, secret string, client *http.Client) (captchaVerifier, error) {
+	// Returns no verifier without a provider, CAPTCHA is optional
+	switch {
+	case provider == "":
+		return nil, nil
+	case provider == stubCaptchaProvider:
+		return stubVerifier{}, nil
+	case captchaEndpoints[provider] == "":
+		return nil, fmt.Errorf("unknown captcha provider %q", provider)
+	case secret == "":
+		return nil, fmt.Errorf("captcha provider %s requires a secret", provider)
+	}
+	return siteVerifier{client: client, endpoint: captchaEndpoints[provider], secret: secret}, nil
+}
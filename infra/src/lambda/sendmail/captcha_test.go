@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSiteVerifier(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Fatal(err)
+		}
+		if r.Form.Get("secret") != "secret" || r.Form.Get("remoteip") != "192.0.2.1" {
+			t.Errorf("unexpected verification request %v", r.Form)
+		}
+		if r.Form.Get("response") == "down" {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if r.Form.Get("response") == "valid" {
+			w.Write([]byte(`{"success": true}`))
+		} else {
+			w.Write([]byte(`{"success": false, "error-codes": ["invalid-input-response"]}`))
+		}
+	}))
+	defer server.Close()
+
+	verifier := siteVerifier{client: server.Client(), endpoint: server.URL, secret: "secret"}
+	for token, want := range map[string]bool{"valid": true, "invalid": false, "": false} {
+		if verified, err := verifier.verify(context.Background(), token, "192.0.2.1"); err != nil || verified != want {
+			t.Errorf("verify(%q) = %v, %v, want %v", token, verified, err, want)
+		}
+	}
+	if _, err := verifier.verify(context.Background(), "down", "192.0.2.1"); err == nil {
+		t.Error("failed verification request not reported")
+	}
+}
+
+func TestNewCaptchaVerifier(t *testing.T) {
+	if verifier, err := newCaptchaVerifier("", "", nil); verifier != nil || err != nil {
+		t.Errorf("verifier without provider = %v, %v", verifier, err)
+	}
+	stub, err := newCaptchaVerifier(stubCaptchaProvider, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verified, _ := stub.verify(context.Background(), "anything", ""); !verified {
+		t.Error("stub rejected a token")
+	}
+	turnstile, err := newCaptchaVerifier("turnstile", "secret", http.DefaultClient)
+	if err != nil || turnstile.(siteVerifier).endpoint != captchaEndpoints["turnstile"] {
+		t.Errorf("turnstile verifier = %v, %v", turnstile, err)
+	}
+	if _, err := newCaptchaVerifier("recaptcha", "", nil); err == nil {
+		t.Error("provider without secret accepted")
+	}
+	if _, err := newCaptchaVerifier("puzzle", "secret", nil); err == nil {
+		t.Error("unknown provider accepted")
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"net/mail"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Maximum field lengths in characters, they mirror the maxlength of the kontakt.html inputs
+const (
+	maxNameLength    = 50
+	maxPhoneLength   = 30
+	maxEmailLength   = 100
+	maxMessageLength = 750
+)
+
+// Stable codes of invalid fields, the frontend maps them to localized messages
+const (
+	fieldRequired     = "required"
+	fieldTooLong      = "too_long"
+	fieldInvalidEmail = "invalid_email"
+	fieldInvalidPhone = "invalid_phone"
+)
+
+// formRequest is the JSON body posted by the contact form
+type formRequest struct {
+	Name    string `json:"name"`
+	Phone   string `json:"phone"`
+	Email   string `json:"email"`
+	Message string `json:"message"`
+	// Honeypot input hidden from people, only bots fill it in
+	Website string `json:"website"`
+	// Milliseconds the page with the form was open, measured by the browser
+	// so the clocks of the device and the Lambda do not need to agree
+	Elapsed int64 `json:"elapsed"`
+	// CAPTCHA token of the widget, required only when a verifier is configured
+	Captcha string `json:"captcha"`
+}
+
+// fieldError describes why a single form field was rejected
+type fieldError struct {
+	Field string `json:"field"`
+	Code  string `json:"code"`
+}
+
+func singleLine(value string) string {
+	// Removes line breaks and other control characters, so values are safe
+	// to use in mail headers
+	return strings.TrimSpace(strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return ' '
+		}
+		return r
+	}, value))
+}
+
+func multiLine(value string) string {
+	// Keeps line breaks and tabs of the message, drops other control characters
+	value = strings.ReplaceAll(value, "\r\n", "\n")
+	return strings.TrimSpace(strings.Map(func(r rune) rune {
+		if r == '\n' || r == '\t' {
+			return r
+		}
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, value))
+}
+
+func (form formRequest) sanitized() formRequest {
+	return formRequest{
+		Name:    singleLine(form.Name),
+		Phone:   singleLine(form.Phone),
+		Email:   singleLine(form.Email),
+		Message: multiLine(form.Message),
+		Website: singleLine(form.Website),
+		Elapsed: form.Elapsed,
+		Captcha: strings.TrimSpace(form.Captcha),
+	}
+}
+
+func validPhone(phone string) bool {
+	// Digits with the usual separators and an optional international prefix
+	digits := 0
+	for i, r := range phone {
+		switch {
+		case r >= '0' && r <= '9':
+			digits++
+		case r == '+' && i == 0:
+		case r == ' ' || r == '-' || r == '(' || r == ')' || r == '/':
+		default:
+			return false
+		}
+	}
+	return digits >= 6
+}
+
+func validEmail(email string) bool {
+	// Only a bare address is accepted, display names would end up in Reply-To
+	address, err := mail.ParseAddress(email)
+	return err == nil && address.Name == "" && address.Address == email
+}
+
+func checkLength(errs []fieldError, field string, value string, max int) []fieldError {
+	if utf8.RuneCountInString(value) > max {
+		return append(errs, fieldError{Field: field, Code: fieldTooLong})
+	}
+	return errs
+}
+
+func (form formRequest) validate() []fieldError {
+	// Validates a sanitized form, every invalid field is reported once
+	errs := make([]fieldError, 0)
+	if form.Name == "" {
+		errs = append(errs, fieldError{Field: "name", Code: fieldRequired})
+	} else {
+		errs = checkLength(errs, "name", form.Name, maxNameLength)
+	}
+	if utf8.RuneCountInString(form.Phone) > maxPhoneLength {
+		errs = append(errs, fieldError{Field: "phone", Code: fieldTooLong})
+	} else if form.Phone != "" && !validPhone(form.Phone) {
+		errs = append(errs, fieldError{Field: "phone", Code: fieldInvalidPhone})
+	}
+	if utf8.RuneCountInString(form.Email) > maxEmailLength {
+		errs = append(errs, fieldError{Field: "email", Code: fieldTooLong})
+	} else if form.Email != "" && !validEmail(form.Email) {
+		errs = append(errs, fieldError{Field: "email", Code: fieldInvalidEmail})
+	}
+	if form.Message == "" {
+		errs = append(errs, fieldError{Field: "message", Code: fieldRequired})
+	} else {
+		errs = checkLength(errs, "message", form.Message, maxMessageLength)
+	}
+	return errs
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFormValidate(t *testing.T) {
+	valid := formRequest{Name: "Jan Novák", Phone: "+420 123 456 789", Email: "jan@example.cz", Message: "Dobrý den"}
+	if errs := valid.validate(); len(errs) != 0 {
+		t.Fatalf("valid form rejected: %v", errs)
+	}
+
+	tests := []struct {
+		name string
+		edit func(form *formRequest)
+		want []fieldError
+	}{
+		{"optional contacts", func(form *formRequest) { form.Phone, form.Email = "", "" }, []fieldError{}},
+		{"missing name", func(form *formRequest) { form.Name = "" }, []fieldError{{"name", fieldRequired}}},
+		{"missing message", func(form *formRequest) { form.Message = "" }, []fieldError{{"message", fieldRequired}}},
+		{"long name", func(form *formRequest) { form.Name = strings.Repeat("ř", maxNameLength+1) }, []fieldError{{"name", fieldTooLong}}},
+		{"name at limit", func(form *formRequest) { form.Name = strings.Repeat("ř", maxNameLength) }, []fieldError{}},
+		{"long message", func(form *formRequest) { form.Message = strings.Repeat("a", maxMessageLength+1) }, []fieldError{{"message", fieldTooLong}}},
+		{"long email", func(form *formRequest) { form.Email = strings.Repeat("a", maxEmailLength) + "@example.cz" }, []fieldError{{"email", fieldTooLong}}},
+		{"invalid email", func(form *formRequest) { form.Email = "jan.example.cz" }, []fieldError{{"email", fieldInvalidEmail}}},
+		{"email with name", func(form *formRequest) { form.Email = "Jan <jan@example.cz>" }, []fieldError{{"email", fieldInvalidEmail}}},
+		{"invalid phone", func(form *formRequest) { form.Phone = "call me" }, []fieldError{{"phone", fieldInvalidPhone}}},
+		{"short phone", func(form *formRequest) { form.Phone = "123" }, []fieldError{{"phone", fieldInvalidPhone}}},
+		{"long phone", func(form *formRequest) { form.Phone = strings.Repeat("1", maxPhoneLength+1) }, []fieldError{{"phone", fieldTooLong}}},
+		{"all invalid", func(form *formRequest) { *form = formRequest{Phone: "x", Email: "x"} }, []fieldError{
+			{"name", fieldRequired}, {"phone", fieldInvalidPhone}, {"email", fieldInvalidEmail}, {"message", fieldRequired},
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			form := valid
+			test.edit(&form)
+			if errs := form.validate(); !reflect.DeepEqual(errs, test.want) {
+				t.Errorf("validate() = %v, want %v", errs, test.want)
+			}
+		})
+	}
+}
+
+func TestFormSanitized(t *testing.T) {
+	form := formRequest{
+		Name:    " Jan\r\nBcc: x@example.org ",
+		Phone:   "123\t456",
+		Email:   "jan@example.cz\n",
+		Message: "Line 1\r\n\tLine 2\x00\x1b\n",
+	}
+	want := formRequest{
+		Name:    "Jan  Bcc: x@example.org",
+		Phone:   "123 456",
+		Email:   "jan@example.cz",
+		Message: "Line 1\n\tLine 2",
+	}
+	if got := form.sanitized(); got != want {
+		t.Errorf("sanitized() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,384 @@
+// Command sendmail forwards contact form submissions to the site owner through SES
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/sesv2"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+
+	"sramek.com/m/v2/internal/submission"
+	"sramek.com/m/v2/lambda/internal/response"
+)
+
+// Stable codes of the responses, the frontend maps them to localized messages
+const (
+	codeSent            = "sent"
+	codeReceived        = "received"
+	codeOriginDenied    = "origin_not_allowed"
+	codeInvalidEncoding = "invalid_encoding"
+	codeInvalidJson     = "invalid_json"
+	codeInvalidFields   = "invalid_fields"
+	codeTooFast         = "too_fast"
+	codeRateLimited     = "rate_limited"
+	codeCaptchaFailed   = "captcha_failed"
+	codeCaptchaDown     = "captcha_unavailable"
+	codeSendFailed      = "send_failed"
+)
+
+// Window of the per client submission limit
+const rateLimitWindow = time.Hour
+
+// Time zone and layout of the submission time in templated mails
+const (
+	mailTimeZone   = "Europe/Prague"
+	mailTimeLayout = "2. 1. 2006 15:04"
+)
+
+// invalidFields is the body of responses to forms failing validation
+type invalidFields struct {
+	Code    string       `json:"code"`
+	Message string       `json:"message"`
+	Fields  []fieldError `json:"fields"`
+}
+
+// mailSender is the part of the SES client used by the handler, replaced in tests
+type mailSender interface {
+	SendEmail(ctx context.Context, params *sesv2.SendEmailInput, optFns ...func(*sesv2.Options)) (*sesv2.SendEmailOutput, error)
+}
+
+// handler sends the form from the site address to the site owners
+type handler struct {
+	ses            mailSender
+	from           string
+	to             []string
+	subject        *template.Template // executed with the form request
+	allowedOrigins []string
+	minFill        time.Duration   // forms filled faster are rejected, zero disables the check
+	limiter        rateLimiter     // optional
+	captcha        captchaVerifier // optional
+	now            func() time.Time
+	site           string
+	submissions    submissionStore // optional
+	queue          deliveryQueue   // optional, mails are sent right away without it
+	template       string          // optional SES template of the notification, it is plain text without it
+	autoReply      string          // optional SES template of the confirmation mailed to the customer
+	zone           *time.Location  // of the submission time in templated mails, UTC when nil
+}
+
+// submissionStore keeps the submissions and their delivery status
+type submissionStore interface {
+	Put(ctx context.Context, stored submission.Submission) error
+	Attempted(ctx context.Context, site string, id string, status string, sendErr error) error
+}
+
+// deliveryQueue passes mails to the queue consumer sending them later
+type deliveryQueue interface {
+	Enqueue(ctx context.Context, delivery submission.Delivery) error
+}
+
+func decodeBody(request events.APIGatewayProxyRequest) ([]byte, error) {
+	// API Gateway passes the body base64 encoded when it is treated as binary
+	if !request.IsBase64Encoded {
+		return []byte(request.Body), nil
+	}
+	return base64.StdEncoding.DecodeString(request.Body)
+}
+
+func mailBody(form formRequest) string {
+	// The phone is listed only when it was filled in
+	var body strings.Builder
+	fmt.Fprintf(&body, "Name: %s\n", form.Name)
+	if form.Phone != "" {
+		fmt.Fprintf(&body, "Phone: %s\n", form.Phone)
+	}
+	fmt.Fprintf(&body, "Email: %s\n\nMessage: %s", form.Email, form.Message)
+	return body.String()
+}
+
+func (h handler) mail(form formRequest) (submission.Mail, error) {
+	// Expects a sanitized and validated form, so none of its fields can break
+	// out of the mail headers
+	var subject strings.Builder
+	if err := h.subject.Execute(&subject, form); err != nil {
+		return submission.Mail{}, fmt.Errorf("rendering subject: %w", err)
+	}
+	var replyTo []string
+	if form.Email != "" {
+		replyTo = []string{(&mail.Address{Name: form.Name, Address: form.Email}).String()}
+	}
+	if h.template != "" {
+		return submission.Mail{
+			From:     h.from,
+			To:       h.to,
+			ReplyTo:  replyTo,
+			Template: h.template,
+			Data:     h.templateData(form, singleLine(subject.String())),
+		}, nil
+	}
+	return submission.Mail{
+		From:    h.from,
+		To:      h.to,
+		ReplyTo: replyTo,
+		Subject: singleLine(subject.String()),
+		Text:    mailBody(form),
+	}, nil
+}
+
+func (h handler) templateData(form formRequest, subject string) map[string]string {
+	// Fields of the SES templates, SES escapes them in the HTML part
+	zone := h.zone
+	if zone == nil {
+		zone = time.UTC
+	}
+	return map[string]string{
+		"subject":     subject,
+		"name":        form.Name,
+		"phone":       form.Phone,
+		"email":       form.Email,
+		"message":     form.Message,
+		"submittedAt": h.now().In(zone).Format(mailTimeLayout),
+	}
+}
+
+func (h handler) confirm(ctx context.Context, form formRequest, notification submission.Mail) {
+	// Mails the auto-reply to customers who filled in their email. It is best
+	// effort, the customer already has the response of the form. Replies to
+	// it reach the form recipients. Anyone can enter any address, so nothing
+	// the submitter entered is mailed back, bots cannot send their text with it
+	if h.autoReply == "" || form.Email == "" {
+		return
+	}
+	data := h.templateData(form, notification.Subject)
+	confirmation := submission.Mail{
+		From:     h.from,
+		To:       []string{form.Email},
+		ReplyTo:  h.to,
+		Template: h.autoReply,
+		Data:     map[string]string{"submittedAt": data["submittedAt"]},
+	}
+	if h.queue != nil {
+		err := h.queue.Enqueue(ctx, submission.Delivery{Site: h.site, Mail: confirmation})
+		if err == nil {
+			return
+		}
+		log.Printf("Queueing auto-reply, sending it right away: %v\n", err)
+	}
+	if _, err := h.ses.SendEmail(ctx, confirmation.SendEmailInput()); err != nil {
+		log.Printf("Sending auto-reply: %v\n", err)
+	}
+}
+
+func (h handler) store(ctx context.Context, form formRequest) (string, bool) {
+	// Stores the submission before it is mailed, so it is kept even when
+	// sending fails. Returns its id and whether it was stored
+	if h.submissions == nil {
+		return "", false
+	}
+	stored, err := submission.New(h.site, h.now(), form.Name, form.Phone, form.Email, form.Message)
+	if err == nil {
+		err = h.submissions.Put(ctx, stored)
+	}
+	if err != nil {
+		log.Printf("Storing submission: %v\n", err)
+		return "", false
+	}
+	return stored.Id, true
+}
+
+func (h handler) attempted(ctx context.Context, id string, status string, sendErr error) {
+	// Status updates are best effort, the mail outcome does not depend on them
+	if err := h.submissions.Attempted(ctx, h.site, id, status, sendErr); err != nil {
+		log.Printf("Recording send attempt: %v\n", err)
+	}
+}
+
+func (h handler) deliver(ctx context.Context, form formRequest) (int, response.Message) {
+	// Delivers the notification and confirms the accepted forms to the customer
+	mail, err := h.mail(form)
+	if err != nil {
+		log.Printf("Rendering email: %v\n", err)
+		return http.StatusInternalServerError, response.Message{Code: codeSendFailed, Message: "Failed to send email"}
+	}
+	status, message := h.notify(ctx, form, mail)
+	if status < http.StatusBadRequest {
+		h.confirm(ctx, form, mail)
+	}
+	return status, message
+}
+
+func (h handler) notify(ctx context.Context, form formRequest, mail submission.Mail) (int, response.Message) {
+	// Queues the notification for the queue sender and reports the outcome.
+	// It is sent right away only when it cannot be queued, a stored
+	// submission is not lost even when that fails
+	id, stored := h.store(ctx, form)
+	if h.queue != nil {
+		err := h.queue.Enqueue(ctx, submission.Delivery{Site: h.site, Id: id, Mail: mail})
+		if err == nil {
+			return http.StatusAccepted, response.Message{Code: codeReceived, Message: "Form received"}
+		}
+		log.Printf("Queueing email, sending it right away: %v\n", err)
+	}
+
+	_, sendErr := h.ses.SendEmail(ctx, mail.SendEmailInput())
+	if sendErr == nil {
+		if stored {
+			h.attempted(ctx, id, submission.StatusSent, nil)
+		}
+		return http.StatusOK, response.Message{Code: codeSent, Message: "Email sent successfully"}
+	}
+	log.Printf("Sending email: %v\n", sendErr)
+	if !stored {
+		return http.StatusInternalServerError, response.Message{Code: codeSendFailed, Message: "Failed to send email"}
+	}
+	h.attempted(ctx, id, submission.StatusFailed, sendErr)
+	return http.StatusAccepted, response.Message{Code: codeReceived, Message: "Form received"}
+}
+
+func (h handler) handle(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	headers := response.CorsHeaders(h.allowedOrigins, request)
+	if origin, allowed := response.AllowedOrigin(h.allowedOrigins, request); !allowed {
+		// Browsers would hide the response anyway, the form is not sent for it
+		log.Printf("Rejected form request from origin %q\n", origin)
+		return response.JSON(http.StatusForbidden, headers, response.Message{Code: codeOriginDenied, Message: "Origin not allowed"}), nil
+	}
+	body, err := decodeBody(request)
+	if err != nil {
+		return response.JSON(http.StatusBadRequest, headers, response.Message{Code: codeInvalidEncoding, Message: "Invalid request body encoding"}), nil
+	}
+	var form formRequest
+	if err := json.Unmarshal(body, &form); err != nil {
+		return response.JSON(http.StatusBadRequest, headers, response.Message{Code: codeInvalidJson, Message: "Invalid form data"}), nil
+	}
+	form = form.sanitized()
+	if form.Website != "" {
+		// Bots get the response of a sent form, so they do not adapt
+		log.Printf("Dropped form request with filled honeypot from %s\n", clientIp(request))
+		return response.JSON(http.StatusOK, headers, response.Message{Code: codeSent, Message: "Email sent successfully"}), nil
+	}
+	if filledTooFast(form, h.minFill) {
+		log.Printf("Rejected form request filled too fast from %s\n", clientIp(request))
+		return response.JSON(http.StatusBadRequest, headers, response.Message{Code: codeTooFast, Message: "Form submitted too fast"}), nil
+	}
+	if errs := form.validate(); len(errs) > 0 {
+		log.Printf("Rejected form request: %v\n", errs)
+		return response.JSON(http.StatusBadRequest, headers, invalidFields{Code: codeInvalidFields, Message: "Invalid form fields", Fields: errs}), nil
+	}
+	if rejected, ok := h.protect(ctx, request, form, headers); !ok {
+		return rejected, nil
+	}
+	log.Printf("Form request from %q\n", form.Email)
+
+	status, message := h.deliver(ctx, form)
+	return response.JSON(status, headers, message), nil
+}
+
+func clientIp(request events.APIGatewayProxyRequest) string {
+	return request.RequestContext.Identity.SourceIP
+}
+
+func (h handler) protect(ctx context.Context, request events.APIGatewayProxyRequest, form formRequest, headers map[string]string) (events.APIGatewayProxyResponse, bool) {
+	// Applies the per client limit and the CAPTCHA check. The limit fails open,
+	// so an outage of the table does not lose customer messages
+	ip := clientIp(request)
+	if h.limiter != nil {
+		allowed, err := h.limiter.allow(ctx, ip)
+		if err != nil {
+			log.Printf("Rate limit of %s not applied: %v\n", ip, err)
+		} else if !allowed {
+			log.Printf("Rejected form request over the rate limit from %s\n", ip)
+			return response.JSON(http.StatusTooManyRequests, headers, response.Message{Code: codeRateLimited, Message: "Too many submissions"}), false
+		}
+	}
+	if h.captcha != nil {
+		verified, err := h.captcha.verify(ctx, form.Captcha, ip)
+		if err != nil {
+			log.Printf("Verifying captcha: %v\n", err)
+			return response.JSON(http.StatusServiceUnavailable, headers, response.Message{Code: codeCaptchaDown, Message: "Captcha verification unavailable"}), false
+		}
+		if !verified {
+			log.Printf("Rejected form request with invalid captcha from %s\n", ip)
+			return response.JSON(http.StatusBadRequest, headers, response.Message{Code: codeCaptchaFailed, Message: "Captcha verification failed"}), false
+		}
+	}
+	return events.APIGatewayProxyResponse{}, true
+}
+
+func envInt(name string) int {
+	// Unset variables are zero, malformed ones stop the function
+	value := os.Getenv(name)
+	if value == "" {
+		return 0
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		log.Fatalf("Parsing %s: %v", name, err)
+	}
+	return number
+}
+
+func main() {
+	cfg, err := config.LoadDefaultConfig(context.Background())
+	if err != nil {
+		log.Fatalf("Loading AWS config: %v", err)
+	}
+	subject, err := template.New("subject").Parse(os.Getenv("SUBJECT_TEMPLATE"))
+	if err != nil {
+		log.Fatalf("Parsing subject template: %v", err)
+	}
+	captcha, err := newCaptchaVerifier(os.Getenv("CAPTCHA_PROVIDER"), os.Getenv("CAPTCHA_SECRET"), &http.Client{Timeout: 5 * time.Second})
+	if err != nil {
+		log.Fatalf("Configuring captcha: %v", err)
+	}
+	zone, err := time.LoadLocation(mailTimeZone)
+	if err != nil {
+		log.Fatalf("Loading time zone: %v", err)
+	}
+	h := handler{
+		ses:            sesv2.NewFromConfig(cfg),
+		from:           os.Getenv("MAIL_FROM"),
+		to:             response.SplitList(os.Getenv("MAIL_TO")),
+		subject:        subject,
+		allowedOrigins: response.SplitList(os.Getenv("ALLOW_ORIGINS")),
+		minFill:        time.Duration(envInt("MIN_FILL_SECONDS")) * time.Second,
+		captcha:        captcha,
+		now:            time.Now,
+		site:           os.Getenv("SITE"),
+		template:       os.Getenv("MAIL_TEMPLATE"),
+		autoReply:      os.Getenv("AUTO_REPLY_TEMPLATE"),
+		zone:           zone,
+	}
+	if table := os.Getenv("SUBMISSIONS_TABLE"); table != "" {
+		h.submissions = submission.Store{Db: dynamodb.NewFromConfig(cfg), Table: table}
+	}
+	if queueUrl := os.Getenv("MAIL_QUEUE_URL"); queueUrl != "" {
+		h.queue = submission.Queue{Sqs: sqs.NewFromConfig(cfg), Url: queueUrl}
+	}
+	if table := os.Getenv("RATE_LIMIT_TABLE"); table != "" {
+		h.limiter = dynamoRateLimiter{
+			db:     dynamodb.NewFromConfig(cfg),
+			table:  table,
+			site:   h.site,
+			max:    envInt("RATE_LIMIT_MAX"),
+			window: rateLimitWindow,
+			now:    time.Now,
+		}
+	}
+	lambda.Start(h.handle)
+}
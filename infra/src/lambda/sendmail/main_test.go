@@ -0,0 +1,412 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/sesv2"
+
+	"sramek.com/m/v2/internal/submission"
+)
+
+// recordingSender records sent emails instead of calling SES
+type recordingSender struct {
+	sent []*sesv2.SendEmailInput
+	err  error
+}
+
+func (s *recordingSender) SendEmail(ctx context.Context, params *sesv2.SendEmailInput, optFns ...func(*sesv2.Options)) (*sesv2.SendEmailOutput, error) {
+	s.sent = append(s.sent, params)
+	return &sesv2.SendEmailOutput{}, s.err
+}
+
+var testNow = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func testHandler(sender mailSender) handler {
+	return handler{
+		ses:            sender,
+		from:           "form@example.com",
+		to:             []string{"orders@example.com", "owner@example.com"},
+		subject:        template.Must(template.New("subject").Parse("Order from {{.Name}}")),
+		allowedOrigins: []string{"https://www.example.com"},
+		now:            func() time.Time { return testNow },
+	}
+}
+
+func formEvent(body string) events.APIGatewayProxyRequest {
+	return events.APIGatewayProxyRequest{
+		HTTPMethod:      "POST",
+		Body:            base64.StdEncoding.EncodeToString([]byte(body)),
+		IsBase64Encoded: true,
+	}
+}
+
+func TestHandleSendsForm(t *testing.T) {
+	sender := &recordingSender{}
+	resp, err := testHandler(sender).handle(context.Background(), formEvent(`{"name": "Jan Novák", "email": "jan@example.cz", "message": "Dobrý den"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 || resp.Headers["Access-Control-Allow-Origin"] != "https://www.example.com" || !strings.Contains(resp.Body, `"code":"sent"`) {
+		t.Errorf("unexpected response %+v", resp)
+	}
+	if len(sender.sent) != 1 {
+		t.Fatalf("sent %d emails, want 1", len(sender.sent))
+	}
+
+	mail := sender.sent[0]
+	if *mail.FromEmailAddress != "form@example.com" || strings.Join(mail.Destination.ToAddresses, ",") != "orders@example.com,owner@example.com" {
+		t.Errorf("mail from %s to %v", *mail.FromEmailAddress, mail.Destination.ToAddresses)
+	}
+	if subject := *mail.Content.Simple.Subject.Data; subject != "Order from Jan Novák" {
+		t.Errorf("subject = %s", subject)
+	}
+	if text := *mail.Content.Simple.Body.Text.Data; !strings.Contains(text, "Email: jan@example.cz") || !strings.Contains(text, "Message: Dobrý den") || strings.Contains(text, "Phone:") {
+		t.Errorf("body = %s", text)
+	}
+	if replyTo := strings.Join(mail.ReplyToAddresses, ","); replyTo != "=?utf-8?q?Jan_Nov=C3=A1k?= <jan@example.cz>" {
+		t.Errorf("reply to = %s", replyTo)
+	}
+}
+
+func TestHandleSanitizesHeaders(t *testing.T) {
+	sender := &recordingSender{}
+	body := `{"name": "Jan\r\nBcc: victim@example.org", "phone": "+420 123 456 789", "message": "Řádek 1\r\nŘádek 2\u0000"}`
+	resp, err := testHandler(sender).handle(context.Background(), formEvent(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 || len(sender.sent) != 1 {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+	mail := sender.sent[0]
+	if subject := *mail.Content.Simple.Subject.Data; subject != "Order from Jan  Bcc: victim@example.org" {
+		t.Errorf("subject = %q", subject)
+	}
+	if len(mail.ReplyToAddresses) != 0 {
+		t.Errorf("reply to without email = %v", mail.ReplyToAddresses)
+	}
+	text := *mail.Content.Simple.Body.Text.Data
+	if !strings.Contains(text, "Phone: +420 123 456 789\n") || !strings.HasSuffix(text, "Message: Řádek 1\nŘádek 2") {
+		t.Errorf("body = %q", text)
+	}
+}
+
+func TestHandleInvalidFields(t *testing.T) {
+	sender := &recordingSender{}
+	resp, err := testHandler(sender).handle(context.Background(), formEvent(`{"name": " ", "email": "jan@", "message": "Ahoj"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"code":"invalid_fields","message":"Invalid form fields","fields":[{"field":"name","code":"required"},{"field":"email","code":"invalid_email"}]}`
+	if resp.StatusCode != 400 || resp.Body != want {
+		t.Errorf("response %d %s, want 400 %s", resp.StatusCode, resp.Body, want)
+	}
+	if len(sender.sent) != 0 {
+		t.Errorf("invalid form sent %d emails", len(sender.sent))
+	}
+}
+
+func TestHandlePlainBody(t *testing.T) {
+	sender := &recordingSender{}
+	request := events.APIGatewayProxyRequest{HTTPMethod: "POST", Body: `{"name": "Jan", "message": "Ahoj"}`}
+	resp, err := testHandler(sender).handle(context.Background(), request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 || len(sender.sent) != 1 {
+		t.Errorf("plain body not sent, response %+v", resp)
+	}
+}
+
+func TestHandleInvalidBody(t *testing.T) {
+	sender := &recordingSender{}
+	for code, request := range map[string]events.APIGatewayProxyRequest{
+		codeInvalidJson:     formEvent("not json"),
+		codeInvalidEncoding: {HTTPMethod: "POST", Body: "%%%", IsBase64Encoded: true},
+	} {
+		resp, err := testHandler(sender).handle(context.Background(), request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != 400 || !strings.Contains(resp.Body, `"code":"`+code+`"`) {
+			t.Errorf("response %d %s, want 400 with code %s", resp.StatusCode, resp.Body, code)
+		}
+	}
+	if len(sender.sent) != 0 {
+		t.Errorf("invalid requests sent %d emails", len(sender.sent))
+	}
+}
+
+func TestHandleSendFailure(t *testing.T) {
+	sender := &recordingSender{err: errors.New("throttled")}
+	resp, err := testHandler(sender).handle(context.Background(), formEvent(`{"name": "Jan", "message": "Ahoj"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 500 || strings.Contains(resp.Body, "throttled") || !strings.Contains(resp.Body, `"code":"send_failed"`) {
+		t.Errorf("unexpected response %+v", resp)
+	}
+}
+
+// fixedLimiter allows submissions according to its fields
+type fixedLimiter struct {
+	allowed bool
+	err     error
+	clients []string
+}
+
+func (l *fixedLimiter) allow(ctx context.Context, client string) (bool, error) {
+	l.clients = append(l.clients, client)
+	return l.allowed, l.err
+}
+
+// fixedVerifier accepts the pass token only
+type fixedVerifier struct {
+	err error
+}
+
+func (v fixedVerifier) verify(ctx context.Context, token string, remoteIp string) (bool, error) {
+	return token == "pass", v.err
+}
+
+func TestHandleAbuseProtection(t *testing.T) {
+	tests := []struct {
+		name    string
+		edit    func(h *handler)
+		body    string
+		status  int
+		code    string
+		sent    int
+		limited int
+	}{
+		{"honeypot", nil, `{"name": "Bot", "message": "Buy", "website": "spam.example"}`, 200, codeSent, 0, 0},
+		{"too fast", func(h *handler) { h.minFill = 3 * time.Second },
+			`{"name": "Jan", "message": "Ahoj", "elapsed": 1000}`, 400, codeTooFast, 0, 0},
+		{"no elapsed time", func(h *handler) { h.minFill = 3 * time.Second }, `{"name": "Jan", "message": "Ahoj"}`, 400, codeTooFast, 0, 0},
+		{"filled in time", func(h *handler) { h.minFill = 3 * time.Second },
+			`{"name": "Jan", "message": "Ahoj", "elapsed": 60000}`, 200, codeSent, 1, 0},
+		{"rate limited", func(h *handler) { h.limiter = &fixedLimiter{} }, `{"name": "Jan", "message": "Ahoj"}`, 429, codeRateLimited, 0, 1},
+		{"limiter down", func(h *handler) { h.limiter = &fixedLimiter{err: errors.New("throttled")} },
+			`{"name": "Jan", "message": "Ahoj"}`, 200, codeSent, 1, 1},
+		{"invalid form not counted", func(h *handler) { h.limiter = &fixedLimiter{allowed: true} }, `{"name": "Jan"}`, 400, codeInvalidFields, 0, 0},
+		{"captcha missing", func(h *handler) { h.captcha = fixedVerifier{} }, `{"name": "Jan", "message": "Ahoj"}`, 400, codeCaptchaFailed, 0, 0},
+		{"captcha solved", func(h *handler) { h.captcha = fixedVerifier{} }, `{"name": "Jan", "message": "Ahoj", "captcha": "pass"}`, 200, codeSent, 1, 0},
+		{"captcha down", func(h *handler) { h.captcha = fixedVerifier{err: errors.New("timeout")} },
+			`{"name": "Jan", "message": "Ahoj", "captcha": "pass"}`, 503, codeCaptchaDown, 0, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sender := &recordingSender{}
+			h := testHandler(sender)
+			if test.edit != nil {
+				test.edit(&h)
+			}
+			request := formEvent(test.body)
+			request.RequestContext.Identity.SourceIP = "192.0.2.1"
+			resp, err := h.handle(context.Background(), request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != test.status || !strings.Contains(resp.Body, `"code":"`+test.code+`"`) {
+				t.Errorf("response %d %s, want %d with code %s", resp.StatusCode, resp.Body, test.status, test.code)
+			}
+			if resp.Headers["Access-Control-Allow-Origin"] == "" || resp.Headers["Content-Type"] != "application/json" {
+				t.Errorf("response headers %v", resp.Headers)
+			}
+			if len(sender.sent) != test.sent {
+				t.Errorf("sent %d emails, want %d", len(sender.sent), test.sent)
+			}
+			if limiter, ok := h.limiter.(*fixedLimiter); ok && (len(limiter.clients) != test.limited || (test.limited > 0 && limiter.clients[0] != "192.0.2.1")) {
+				t.Errorf("limited clients %v, want %d", limiter.clients, test.limited)
+			}
+		})
+	}
+}
+
+// memoryStore keeps the submissions and their attempts in memory
+type memoryStore struct {
+	stored   []submission.Submission
+	attempts []string
+	putErr   error
+}
+
+func (m *memoryStore) Put(ctx context.Context, stored submission.Submission) error {
+	if m.putErr != nil {
+		return m.putErr
+	}
+	m.stored = append(m.stored, stored)
+	return nil
+}
+
+func (m *memoryStore) Attempted(ctx context.Context, site string, id string, status string, sendErr error) error {
+	m.attempts = append(m.attempts, site+"/"+id+" "+status)
+	return nil
+}
+
+// memoryQueue keeps the queued deliveries in memory
+type memoryQueue struct {
+	queued []submission.Delivery
+	err    error
+}
+
+func (q *memoryQueue) Enqueue(ctx context.Context, delivery submission.Delivery) error {
+	if q.err != nil {
+		return q.err
+	}
+	q.queued = append(q.queued, delivery)
+	return nil
+}
+
+func TestHandleQueuesSubmission(t *testing.T) {
+	tests := []struct {
+		name     string
+		sendErr  error
+		putErr   error
+		queueErr error
+		status   int
+		code     string
+		attempt  string
+		queued   int
+		sent     int
+	}{
+		{"queued", nil, nil, nil, 202, codeReceived, "", 1, 0},
+		{"queue failed", nil, nil, errors.New("down"), 200, codeSent, submission.StatusSent, 0, 1},
+		{"queue and send failed", errors.New("throttled"), nil, errors.New("down"), 202, codeReceived, submission.StatusFailed, 0, 1},
+		{"store failed", nil, errors.New("down"), nil, 202, codeReceived, "", 1, 0},
+		{"everything failed", errors.New("throttled"), errors.New("down"), errors.New("down"), 500, codeSendFailed, "", 0, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := &memoryStore{putErr: test.putErr}
+			queue := &memoryQueue{err: test.queueErr}
+			sender := &recordingSender{err: test.sendErr}
+			h := testHandler(sender)
+			h.site, h.submissions, h.queue = "shop", store, queue
+
+			resp, err := h.handle(context.Background(), formEvent(`{"name": "Jan", "phone": "123 456 789", "message": "Ahoj"}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != test.status || !strings.Contains(resp.Body, `"code":"`+test.code+`"`) {
+				t.Errorf("response %d %s, want %d with code %s", resp.StatusCode, resp.Body, test.status, test.code)
+			}
+			if len(queue.queued) != test.queued || len(sender.sent) != test.sent {
+				t.Errorf("queued %d and sent %d mails, want %d and %d", len(queue.queued), len(sender.sent), test.queued, test.sent)
+			}
+
+			id := ""
+			if test.putErr == nil {
+				stored := store.stored[0]
+				if stored.Site != "shop" || stored.Phone != "123 456 789" || stored.Status != submission.StatusPending || !stored.SubmittedAt.Equal(testNow) {
+					t.Errorf("stored %+v", stored)
+				}
+				id = stored.Id
+			}
+			if test.queued > 0 && (queue.queued[0].Id != id || queue.queued[0].Site != "shop" || queue.queued[0].Mail.Subject != "Order from Jan") {
+				t.Errorf("queued %+v", queue.queued[0])
+			}
+			if test.attempt == "" {
+				if len(store.attempts) != 0 {
+					t.Errorf("unexpected attempts %v", store.attempts)
+				}
+			} else if want := "shop/" + id + " " + test.attempt; len(store.attempts) != 1 || store.attempts[0] != want {
+				t.Errorf("attempts %v, want %s", store.attempts, want)
+			}
+		})
+	}
+}
+
+func TestHandleTemplatedMail(t *testing.T) {
+	zone, err := time.LoadLocation(mailTimeZone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		body     string
+		queueErr error
+		sendErr  error
+		status   int
+		mails    int
+	}{
+		{"auto-reply", `{"name": "Jan Novák", "email": "jan@example.cz", "message": "Dobrý den"}`, nil, nil, 202, 2},
+		{"no email", `{"name": "Jan Novák", "phone": "123 456 789", "message": "Dobrý den"}`, nil, nil, 202, 1},
+		{"queue failed", `{"name": "Jan Novák", "email": "jan@example.cz", "message": "Dobrý den"}`, errors.New("down"), nil, 200, 2},
+		{"send failed", `{"name": "Jan Novák", "email": "jan@example.cz", "message": "Dobrý den"}`, errors.New("down"), errors.New("throttled"), 500, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			queue := &memoryQueue{err: test.queueErr}
+			sender := &recordingSender{err: test.sendErr}
+			h := testHandler(sender)
+			h.site, h.queue, h.zone = "shop", queue, zone
+			h.template, h.autoReply = "shop-notification", "shop-autoreply"
+
+			resp, err := h.handle(context.Background(), formEvent(test.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != test.status {
+				t.Errorf("response %d %s, want %d", resp.StatusCode, resp.Body, test.status)
+			}
+			var mails []*sesv2.SendEmailInput
+			for _, delivery := range queue.queued {
+				mails = append(mails, delivery.Mail.SendEmailInput())
+			}
+			mails = append(mails, sender.sent...)
+			if len(mails) != test.mails {
+				t.Fatalf("delivered %d mails, want %d", len(mails), test.mails)
+			}
+
+			notification := mails[0].Content.Template
+			if *notification.TemplateName != "shop-notification" || mails[0].Content.Simple != nil {
+				t.Errorf("notification content %+v", mails[0].Content)
+			}
+			var data map[string]string
+			if err := json.Unmarshal([]byte(*notification.TemplateData), &data); err != nil {
+				t.Fatal(err)
+			}
+			if data["subject"] != "Order from Jan Novák" || data["name"] != "Jan Novák" || data["message"] != "Dobrý den" || data["submittedAt"] != "1. 5. 2024 14:00" {
+				t.Errorf("template data %v", data)
+			}
+			if test.mails < 2 {
+				return
+			}
+			confirmation := mails[1]
+			if *confirmation.Content.Template.TemplateName != "shop-autoreply" || strings.Join(confirmation.Destination.ToAddresses, ",") != "jan@example.cz" || strings.Join(confirmation.ReplyToAddresses, ",") != "orders@example.com,owner@example.com" {
+				t.Errorf("auto-reply to %v replying to %v with %+v", confirmation.Destination.ToAddresses, confirmation.ReplyToAddresses, confirmation.Content.Template)
+			}
+			var confirmationData map[string]string
+			if err := json.Unmarshal([]byte(*confirmation.Content.Template.TemplateData), &confirmationData); err != nil {
+				t.Fatal(err)
+			}
+			if len(confirmationData) != 1 || confirmationData["submittedAt"] != "1. 5. 2024 14:00" {
+				t.Errorf("auto-reply data %v, want only the submission time", confirmationData)
+			}
+			if len(queue.queued) == 2 && queue.queued[1].Id != "" {
+				t.Errorf("auto-reply tracked as submission %s", queue.queued[1].Id)
+			}
+		})
+	}
+}
+
+func TestHandleRejectsUnknownOrigin(t *testing.T) {
+	sender := &recordingSender{}
+	request := formEvent(`{"name": "Jan", "message": "Ahoj"}`)
+	request.Headers = map[string]string{"origin": "https://evil.example"}
+	resp, err := testHandler(sender).handle(context.Background(), request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 403 || !strings.Contains(resp.Body, `"code":"origin_not_allowed"`) || len(sender.sent) != 0 {
+		t.Errorf("response %d %s, sent %d emails", resp.StatusCode, resp.Body, len(sender.sent))
+	}
+}
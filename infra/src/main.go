@@ -10,34 +10,33 @@ import (
 	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/lambda"
 	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/s3"
 	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
-	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
-)
-
-var (
-	projects = []string{"sramek-garden-center", "sramek-transportation"}
 )
 
 type staticSiteProject struct {
 	name       string
 	dir        string
 	bucketPath string
-	domain     string
+	domain     string   // primary host of the site
+	aliases    []string // extra hosts served by the same distribution
+	hostedZone string   // Route53 zone of the hosts, defaults to the registrable domain
 	indexDoc   string
 	errorDoc   string
 	cors       string
-}
 
-func getProjectConfig(ctx *pulumi.Context, projectName string) staticSiteProject {
-	projectConfig := config.New(ctx, projectName)
-	return staticSiteProject{
-		name:       projectName,
-		dir:        projectConfig.Require("dir"),
-		domain:     projectConfig.Get("domain"),
-		bucketPath: projectConfig.Get("bucket-path"),
-		indexDoc:   projectConfig.Require("index-doc"),
-		errorDoc:   projectConfig.Require("error-doc"),
-		cors:       projectConfig.Get("cors"), // TODO needed?
-	}
+	privateOrigin bool   // content bucket is private and readable only by CloudFront
+	contentSync   string // contentSyncObjects or contentSyncManifest
+	cacheControl  cacheControlRules
+	contentTypes  contentTypes
+	precompress   bool // serve pre-compressed variants of text files
+	contactForm   *contactForm
+
+	securityHeaders *securityHeaders // relaxations of the strict defaults, nil keeps them
+	cacheBehaviors  cacheBehaviors   // ordered behaviors of the distribution, defaults when empty
+	errorPages      errorPages       // custom error responses replacing the defaults of their codes
+	canonicalHost   string           // canonicalHostWww, canonicalHostApex or the primary domain when empty
+	trailingSlash   string           // trailingSlashAdd, trailingSlashRemove or no normalization when empty
+	redirects       redirectRules    // redirects of the config, checked together with the redirects file
+	redirectsFile   string           // file with a redirect per line, its path is relative to dir
 }
 
 func main() {
@@ -49,162 +48,253 @@ func main() {
 
 	log.Println("Deploying static website infrastructure")
 
-	pulumi.Run(func(ctx *pulumi.Context) error {
-		logsBucket := createBucket(ctx, "request-logs-sramek-infra")
+	pulumi.Run(deployInfrastructure)
+}
 
-		log.Println("Deploying global lambda functions")
-		redirectLambda := lambdaRedirect(ctx)
+func deployInfrastructure(ctx *pulumi.Context) error {
+	sites, err := getSitesConfig(ctx)
+	if err != nil {
+		return err
+	}
 
-		log.Println("Deploying websites")
-		for _, projectName := range projects {
-			projectConfig := getProjectConfig(ctx, projectName)
-			deployProject(ctx, projectConfig, logsBucket, redirectLambda)
-		}
+	// Shared resources are needed by every project, so their failure stops the deployment
+	logsBucket, err := createBucket(ctx, "request-logs-sramek-infra")
+	if err != nil {
+		return err
+	}
 
-		// TODO: Read domain from config (after migration domain to AWS)
-		simpleMailService(ctx, "sramek-autodoprava.cz")
-		return nil
-	})
+	log.Println("Deploying global lambda functions")
+	precompressLambda, err := lambdaPrecompress(ctx)
+	if err != nil {
+		return err
+	}
+
+	log.Println("Deploying cache policies")
+	policies, err := createCachePolicies(ctx)
+	if err != nil {
+		return err
+	}
+
+	log.Println("Deploying websites")
+	var errs deployErrors
+	for _, site := range sites {
+		errs.add(site.name, deployProject(ctx, site, logsBucket, precompressLambda, policies))
+	}
+
+	errs = append(errs, simpleMailService(ctx, sites)...)
+	return errs.errOrNil()
 }
 
-func deployProject(ctx *pulumi.Context, project staticSiteProject, logsBucket *s3.Bucket, redirectLambda *lambda.Function) {
+func deployProject(ctx *pulumi.Context, project staticSiteProject, logsBucket *s3.Bucket, precompressLambda *lambda.Function, policies cachePolicies) error {
 	log.Printf("Deploy WWW id: %s, dir: %s, domain: %s", project.name, project.dir, project.domain)
 
-	domains, err := getDomainWithSubdomains(project.domain)
-	log.Println("Used domains: ", domains)
-	handleErr(err)
+	domains, err := getSiteDomains(project)
+	if err != nil {
+		return err
+	}
+	log.Println("Used domains: ", domainHosts(domains))
+	if project.privateOrigin && len(domains) == 0 {
+		return fmt.Errorf("private origin requires a domain, the bucket is not reachable without Cloudfront")
+	}
+	redirects, err := siteRedirects(project)
+	if err != nil {
+		return err
+	}
+	if len(redirects) > 0 && len(domains) == 0 {
+		return fmt.Errorf("redirects require a domain, they are served by Cloudfront")
+	}
+	if len(domains) > 0 {
+		if err := checkErrorPages(project); err != nil {
+			return err
+		}
+		if err := checkRedirects(redirects, domainHosts(domains), project.trailingSlash); err != nil {
+			return err
+		}
+	}
 
-	contentBucket := createContentBucket(ctx, project, false)
+	contentBucket, content, err := createContentBucket(ctx, project, project.privateOrigin)
+	if err != nil {
+		return err
+	}
+	ctx.Export(fmt.Sprintf("%s-contentManifestHash", project.name), content.ManifestHash)
 
 	if len(domains) > 0 {
-		cdn := instantiateCloudfront(ctx, contentBucket, logsBucket, domains, project.indexDoc, project.name, redirectLambda)
-		createAliasRecords(ctx, cdn, domains)
+		cdn, err := instantiateCloudfront(ctx, project, contentBucket, logsBucket, domains, redirects, precompressLambda, policies)
+		if err != nil {
+			return err
+		}
+		if project.privateOrigin {
+			if err := allowDistributionRead(ctx, project, contentBucket, cdn); err != nil {
+				return err
+			}
+		}
+		if err := createAliasRecords(ctx, cdn, domains); err != nil {
+			return err
+		}
 		ctx.Export(fmt.Sprintf("%s-cloudfrontDomain", project.name), cdn.DomainName)
+		ctx.Export(fmt.Sprintf("%s-invalidationId", project.name), invalidateChangedContent(ctx, project, content, cdn))
 	} else {
 		log.Println("No domains provided, skipping Cloudfront distribution")
 	}
 
 	ctx.Export(fmt.Sprintf("%s-bucketName", project.name), contentBucket.ID())
-	ctx.Export(fmt.Sprintf("%s-bucketEndpoint", project.name), contentBucket.WebsiteEndpoint.ApplyT(func(websiteEndpoint string) (string, error) {
-		return fmt.Sprintf("http://%v", websiteEndpoint), nil
-	}).(pulumi.StringOutput))
+	if !project.privateOrigin {
+		ctx.Export(fmt.Sprintf("%s-bucketEndpoint", project.name), contentBucket.WebsiteEndpoint.ApplyT(func(websiteEndpoint string) (string, error) {
+			return fmt.Sprintf("http://%v", websiteEndpoint), nil
+		}).(pulumi.StringOutput))
+	}
+	return nil
 }
 
-func getArnCertificate(ctx *pulumi.Context, domains []string) pulumi.StringOutput {
-	mainDomain := domains[0]
+func getArnCertificate(ctx *pulumi.Context, domains []siteDomain) (pulumi.StringOutput, error) {
+	mainDomain := domains[0].host
 
-	eastRegion, err := aws.NewProvider(ctx, fmt.Sprintf("%s-east", mainDomain), &aws.ProviderArgs{
+	providerName := fmt.Sprintf("%s-east", mainDomain)
+	eastRegion, err := aws.NewProvider(ctx, providerName, &aws.ProviderArgs{
 		Region: pulumi.String("us-east-1"), // AWS Certificate Manager is available only in us east region
 	})
+	if err != nil {
+		return pulumi.StringOutput{}, resourceErr(providerName, err)
+	}
 
 	// generate certificate for our domain
-	certificate, err := acm.NewCertificate(ctx, fmt.Sprintf("%s-certificate", mainDomain), &acm.CertificateArgs{
+	certificateName := fmt.Sprintf("%s-certificate", mainDomain)
+	certificate, err := acm.NewCertificate(ctx, certificateName, &acm.CertificateArgs{
 		DomainName:              pulumi.String(mainDomain),
 		ValidationMethod:        pulumi.String("DNS"),
-		SubjectAlternativeNames: stringArrayToPulumiStringArray(domains),
+		SubjectAlternativeNames: stringArrayToPulumiStringArray(domainHosts(domains)),
 	}, pulumi.Provider(eastRegion))
-	handleErr(err)
-
-	zoneId, err := getRoute53HostedZone(ctx, mainDomain)
-	handleErr(err)
-	log.Printf("DNS Hosted zone: %s", zoneId)
+	if err != nil {
+		return pulumi.StringOutput{}, resourceErr(certificateName, err)
+	}
 
-	validationRecords := createValidationRecords(ctx, domains, certificate, zoneId)
+	validationRecords, err := createValidationRecords(ctx, domains, certificate)
+	if err != nil {
+		return pulumi.StringOutput{}, err
+	}
 
-	certValidation, err := acm.NewCertificateValidation(ctx, fmt.Sprintf("%s-certificate-validation", mainDomain), &acm.CertificateValidationArgs{
+	validationName := fmt.Sprintf("%s-certificate-validation", mainDomain)
+	certValidation, err := acm.NewCertificateValidation(ctx, validationName, &acm.CertificateValidationArgs{
 		CertificateArn:        certificate.Arn,
 		ValidationRecordFqdns: mapValidationRecordsFqdn(validationRecords),
 	}, pulumi.Provider(eastRegion))
-	handleErr(err)
+	if err != nil {
+		return pulumi.StringOutput{}, resourceErr(validationName, err)
+	}
 
-	return certValidation.CertificateArn
+	return certValidation.CertificateArn, nil
 }
 
 func instantiateCloudfront(
 	ctx *pulumi.Context,
+	project staticSiteProject,
 	contentBucket *s3.Bucket,
 	logsBucket *s3.Bucket,
-	domains []string,
-	indexDoc string,
-	projectName string,
-	redirectLambda *lambda.Function) *cloudfront.Distribution {
-	mainDomain := domains[0]
-	log.Printf("Creating Cloudfront distribution for project: %s\n", projectName)
+	domains []siteDomain,
+	redirects redirectRules,
+	precompressLambda *lambda.Function,
+	policies cachePolicies) (*cloudfront.Distribution, error) {
+	mainDomain := domains[0].host
+	log.Printf("Creating Cloudfront distribution for project: %s\n", project.name)
 
-	viewerLambdaAssociation := cloudfront.DistributionDefaultCacheBehaviorLambdaFunctionAssociationArgs{
-		// Redirect lambda handles redirecting from non www domain to www domain
-		EventType:   pulumi.String("viewer-request"),
-		LambdaArn:   redirectLambda.QualifiedArn,
-		IncludeBody: pulumi.Bool(false),
+	certificateArn, err := getArnCertificate(ctx, domains)
+	if err != nil {
+		return nil, err
 	}
 
-	distribution, err := cloudfront.NewDistribution(ctx, fmt.Sprintf("%s-cdn", mainDomain), &cloudfront.DistributionArgs{
-		Enabled:           pulumi.Bool(true),
-		Aliases:           stringArrayToPulumiStringArray(domains),
-		DefaultRootObject: pulumi.String(indexDoc),
-		DefaultCacheBehavior: cloudfront.DistributionDefaultCacheBehaviorArgs{
-			TargetOriginId: contentBucket.Arn,
-			LambdaFunctionAssociations: cloudfront.DistributionDefaultCacheBehaviorLambdaFunctionAssociationArray{
-				viewerLambdaAssociation,
-			},
+	origin, err := distributionOrigin(ctx, project, contentBucket)
+	if err != nil {
+		return nil, err
+	}
+
+	// Every behavior sends the same security headers
+	headersPolicy, err := securityHeadersPolicy(ctx, project)
+	if err != nil {
+		return nil, err
+	}
+
+	// Every behavior redirects to the canonical host
+	viewerFunction, err := viewerRequestFunction(ctx, project, domains, redirects)
+	if err != nil {
+		return nil, err
+	}
+
+	var lambdaAssociations cloudfront.DistributionDefaultCacheBehaviorLambdaFunctionAssociationArray
+	var orderedLambdaAssociations cloudfront.DistributionOrderedCacheBehaviorLambdaFunctionAssociationArray
+	if project.precompress {
+		// On cache miss the lambda picks the pre-compressed variant by the
+		// Accept-Encoding the cache policies normalize into the cache key, and
+		// adds Vary: Accept-Encoding to the response for the downstream caches
+		for _, eventType := range []string{"origin-request", "origin-response"} {
+			lambdaAssociations = append(lambdaAssociations, cloudfront.DistributionDefaultCacheBehaviorLambdaFunctionAssociationArgs{
+				EventType:   pulumi.String(eventType),
+				LambdaArn:   precompressLambda.QualifiedArn,
+				IncludeBody: pulumi.Bool(false),
+			})
+			orderedLambdaAssociations = append(orderedLambdaAssociations, cloudfront.DistributionOrderedCacheBehaviorLambdaFunctionAssociationArgs{
+				EventType:   pulumi.String(eventType),
+				LambdaArn:   precompressLambda.QualifiedArn,
+				IncludeBody: pulumi.Bool(false),
+			})
+		}
+	}
+
+	orderedBehaviors := cloudfront.DistributionOrderedCacheBehaviorArray{}
+	for _, behavior := range siteCacheBehaviors(project) {
+		orderedBehaviors = append(orderedBehaviors, &cloudfront.DistributionOrderedCacheBehaviorArgs{
+			PathPattern:          pulumi.String(behavior.PathPattern),
+			TargetOriginId:       contentBucket.Arn,
 			ViewerProtocolPolicy: pulumi.String("redirect-to-https"),
 			AllowedMethods:       pulumi.StringArray{pulumi.String("GET"), pulumi.String("HEAD")},
 			CachedMethods:        pulumi.StringArray{pulumi.String("GET"), pulumi.String("HEAD")},
-			ForwardedValues: cloudfront.DistributionDefaultCacheBehaviorForwardedValuesArgs{
-				Cookies: cloudfront.DistributionDefaultCacheBehaviorForwardedValuesCookiesArgs{
-					Forward: pulumi.String("none"),
+			FunctionAssociations: cloudfront.DistributionOrderedCacheBehaviorFunctionAssociationArray{
+				cloudfront.DistributionOrderedCacheBehaviorFunctionAssociationArgs{
+					EventType:   pulumi.String("viewer-request"),
+					FunctionArn: viewerFunction.Arn,
 				},
-				QueryString: pulumi.Bool(false),
 			},
-			MinTtl:     pulumi.Int(60 * 60 * 24 * 7), // 1 week
-			MaxTtl:     pulumi.Int(60 * 60 * 24 * 7), // 1 week
-			DefaultTtl: pulumi.Int(60 * 60 * 24 * 7), // 1 week
-			Compress:   pulumi.Bool(true),
-		},
-		OrderedCacheBehaviors: cloudfront.DistributionOrderedCacheBehaviorArray{
-			&cloudfront.DistributionOrderedCacheBehaviorArgs{
-				PathPattern:          pulumi.String("/images/*"),
-				TargetOriginId:       contentBucket.Arn,
-				ViewerProtocolPolicy: pulumi.String("redirect-to-https"),
-				AllowedMethods: pulumi.StringArray{
-					pulumi.String("GET"),
-					pulumi.String("HEAD"),
-				},
-				CachedMethods: pulumi.StringArray{
-					pulumi.String("GET"),
-					pulumi.String("HEAD"),
-				},
-				ForwardedValues: &cloudfront.DistributionOrderedCacheBehaviorForwardedValuesArgs{
-					QueryString: pulumi.Bool(false),
-					Cookies: &cloudfront.DistributionOrderedCacheBehaviorForwardedValuesCookiesArgs{
-						Forward: pulumi.String("none"),
-					},
+			LambdaFunctionAssociations: orderedLambdaAssociations,
+			ResponseHeadersPolicyId:    headersPolicy.ID(),
+			CachePolicyId:              policies.profiles[behavior.Profile].ID(),
+			OriginRequestPolicyId:      policies.originRequest.ID(),
+			Compress:                   pulumi.Bool(true),
+		})
+	}
+
+	distributionName := fmt.Sprintf("%s-cdn", mainDomain)
+	distribution, err := cloudfront.NewDistribution(ctx, distributionName, &cloudfront.DistributionArgs{
+		Enabled:           pulumi.Bool(true),
+		Aliases:           stringArrayToPulumiStringArray(domainHosts(domains)),
+		DefaultRootObject: pulumi.String(project.indexDoc),
+		DefaultCacheBehavior: cloudfront.DistributionDefaultCacheBehaviorArgs{
+			TargetOriginId: contentBucket.Arn,
+			FunctionAssociations: cloudfront.DistributionDefaultCacheBehaviorFunctionAssociationArray{
+				cloudfront.DistributionDefaultCacheBehaviorFunctionAssociationArgs{
+					EventType:   pulumi.String("viewer-request"),
+					FunctionArn: viewerFunction.Arn,
 				},
-				MinTtl:     pulumi.Int(60 * 60 * 24 * 30), // 30 days
-				DefaultTtl: pulumi.Int(60 * 60 * 24 * 30), // 30 days
-				MaxTtl:     pulumi.Int(60 * 60 * 24 * 30), // 30 days
-				Compress:   pulumi.Bool(true),
 			},
+			LambdaFunctionAssociations: lambdaAssociations,
+			ResponseHeadersPolicyId:    headersPolicy.ID(),
+			CachePolicyId:              policies.profiles[defaultCacheProfile].ID(),
+			OriginRequestPolicyId:      policies.originRequest.ID(),
+			ViewerProtocolPolicy:       pulumi.String("redirect-to-https"),
+			AllowedMethods:             pulumi.StringArray{pulumi.String("GET"), pulumi.String("HEAD")},
+			CachedMethods:              pulumi.StringArray{pulumi.String("GET"), pulumi.String("HEAD")},
+			Compress:                   pulumi.Bool(true),
 		},
+		OrderedCacheBehaviors: orderedBehaviors,
 		Origins: cloudfront.DistributionOriginArray{
-			cloudfront.DistributionOriginArgs{
-				OriginId:   contentBucket.Arn,
-				DomainName: contentBucket.WebsiteEndpoint,
-				CustomOriginConfig: cloudfront.DistributionOriginCustomOriginConfigArgs{
-					OriginProtocolPolicy: pulumi.String("http-only"),
-					HttpPort:             pulumi.Int(80),
-					HttpsPort:            pulumi.Int(443),
-					OriginSslProtocols:   pulumi.StringArray{pulumi.String("TLSv1.2")},
-				},
-			},
+			origin,
 		},
-		PriceClass: pulumi.String("PriceClass_100"),
+		CustomErrorResponses: customErrorResponses(project),
+		PriceClass:           pulumi.String("PriceClass_100"),
 
 		// Put access logs to the bucket we created before
 		LoggingConfig: cloudfront.DistributionLoggingConfigArgs{
 			Bucket:         logsBucket.BucketDomainName,
 			IncludeCookies: pulumi.Bool(false),
-			Prefix:         pulumi.String(fmt.Sprintf("%s/", projectName)),
+			Prefix:         pulumi.String(fmt.Sprintf("%s/", project.name)),
 		},
 
 		// Set restrictions for our websites, at this moment we don't need any
@@ -215,13 +305,15 @@ func instantiateCloudfront(
 		},
 		// Use the distribution certificate
 		ViewerCertificate: cloudfront.DistributionViewerCertificateArgs{
-			AcmCertificateArn: getArnCertificate(ctx, domains),
+			AcmCertificateArn: certificateArn,
 			SslSupportMethod:  pulumi.String("sni-only"),
 		},
 
 		// It takes around 15min to create cloudfront distribution, so we don't want to wait for it
 		WaitForDeployment: pulumi.Bool(false),
 	})
-	handleErr(err)
-	return distribution
+	if err != nil {
+		return nil, resourceErr(distributionName, err)
+	}
+	return distribution, nil
 }
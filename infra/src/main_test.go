@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"gopkg.in/yaml.v3"
+)
+
+var updateGolden = flag.Bool("update", false, "update golden files in testdata/golden")
+
+func TestMain(m *testing.M) {
+	// Deployments under test never talk to AWS directly
+	openContentStore = func(ctx context.Context, region string, bucket string, acl string) (contentStore, error) {
+		return newMemoryContentStore(), nil
+	}
+	openInvalidator = func(ctx context.Context) (cdnInvalidator, error) {
+		return &recordingInvalidator{}, nil
+	}
+	buildHandler = func(pkg string, outDir string) error {
+		return nil
+	}
+	os.Exit(m.Run())
+}
+
+const testSiteDir = "testdata/site"
+
+func testProject(domain string, aliases ...string) staticSiteProject {
+	return staticSiteProject{
+		name:       "test-site",
+		dir:        testSiteDir,
+		bucketPath: "www/test-site",
+		domain:     domain,
+		aliases:    aliases,
+		indexDoc:   "index.html",
+		errorDoc:   "error.html",
+		cors:       "*",
+
+		contentSync: contentSyncObjects,
+	}
+}
+
+func deployTestProject(project staticSiteProject) pulumi.RunFunc {
+	return func(ctx *pulumi.Context) error {
+		logsBucket, err := createBucket(ctx, "test-logs")
+		if err != nil {
+			return err
+		}
+		precompressLambda, err := lambdaPrecompress(ctx)
+		if err != nil {
+			return err
+		}
+		policies, err := createCachePolicies(ctx)
+		if err != nil {
+			return err
+		}
+		return deployProject(ctx, project, logsBucket, precompressLambda, policies)
+	}
+}
+
+func TestDeployProject(t *testing.T) {
+	mocks, err := runWithMocks(t, "test", nil, deployTestProject(testProject("example.com", "www.example.com")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	objects := mocks.byType("aws:s3/bucketObject:BucketObject")
+	if got, want := names(objects), "www/test-site/error.html,www/test-site/images/logo.svg,www/test-site/index.html"; got != want {
+		t.Errorf("bucket objects = %s, want %s", got, want)
+	}
+	index := mocks.find(t, "aws:s3/bucketObject:BucketObject", "www/test-site/index.html")
+	if index.Inputs["key"] != "/index.html" {
+		t.Errorf("index key = %v, want /index.html", index.Inputs["key"])
+	}
+
+	distribution := mocks.find(t, "aws:cloudfront/distribution:Distribution", "example.com-cdn")
+	if got := strings.Join(inputStrings(t, distribution, "aliases"), ","); got != "example.com,www.example.com" {
+		t.Errorf("distribution aliases = %s", got)
+	}
+
+	records := mocks.byType("aws:route53/record:Record")
+	want := "example.com,validation-record-example.com,validation-record-www.example.com,www.example.com"
+	if got := names(records); got != want {
+		t.Errorf("route53 records = %s, want %s", got, want)
+	}
+}
+
+func TestDeployProjectNestedSubdomain(t *testing.T) {
+	mocks, err := runWithMocks(t, "test", nil, deployTestProject(testProject("shop.example.co.uk")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	record := mocks.find(t, "aws:route53/record:Record", "shop.example.co.uk")
+	if record.Inputs["name"] != "shop" || record.Inputs["zoneId"] != "zone-example.co.uk" {
+		t.Errorf("alias record = %v, want shop in zone-example.co.uk", record.Inputs)
+	}
+	validation := mocks.find(t, "aws:route53/record:Record", "validation-record-shop.example.co.uk")
+	if validation.Inputs["zoneId"] != "zone-example.co.uk" {
+		t.Errorf("validation record zone = %v", validation.Inputs["zoneId"])
+	}
+}
+
+func TestDeployProjectWithoutDomain(t *testing.T) {
+	mocks, err := runWithMocks(t, "test", nil, deployTestProject(testProject("")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if distributions := mocks.byType("aws:cloudfront/distribution:Distribution"); len(distributions) != 0 {
+		t.Errorf("expected no distribution, got %s", names(distributions))
+	}
+	if records := mocks.byType("aws:route53/record:Record"); len(records) != 0 {
+		t.Errorf("expected no records, got %s", names(records))
+	}
+}
+
+func TestInstantiateCloudfront(t *testing.T) {
+	mocks, err := runWithMocks(t, "test", nil, deployTestProject(testProject("example.com", "www.example.com")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	distribution := mocks.find(t, "aws:cloudfront/distribution:Distribution", "example.com-cdn")
+	if distribution.Inputs["defaultRootObject"] != "index.html" {
+		t.Errorf("default root object = %v", distribution.Inputs["defaultRootObject"])
+	}
+	logging := distribution.Inputs["loggingConfig"].(map[string]interface{})
+	if logging["prefix"] != "test-site/" {
+		t.Errorf("logging prefix = %v", logging["prefix"])
+	}
+	cacheBehavior := distribution.Inputs["defaultCacheBehavior"].(map[string]interface{})
+	associations := cacheBehavior["functionAssociations"].([]interface{})
+	if len(associations) != 1 {
+		t.Fatalf("expected one function association, got %v", associations)
+	}
+	association := associations[0].(map[string]interface{})
+	if association["eventType"] != "viewer-request" || association["functionArn"] != "arn:aws:mock:::test-site-viewer-request" {
+		t.Errorf("unexpected function association %v", association)
+	}
+	certificate := distribution.Inputs["viewerCertificate"].(map[string]interface{})
+	if certificate["acmCertificateArn"] != "arn:aws:mock:::example.com-certificate" {
+		t.Errorf("unexpected viewer certificate %v", certificate)
+	}
+}
+
+func TestDeployInfrastructureReportsFailedProject(t *testing.T) {
+	config := stackConfig(t, "prod", func(sites []siteConfig) {
+		sites[1].Domain = "cz"
+	})
+
+	mocks, err := runWithMocks(t, "prod", config, deployInfrastructure)
+
+	var failures deployErrors
+	if !errors.As(err, &failures) {
+		t.Fatalf("expected deployErrors, got %v", err)
+	}
+	// Both the site and its contact form fail on the invalid domain
+	if len(failures) != 2 || failures[0].project != "sramek-transportation" || failures[1].project != "sramek-transportation" {
+		t.Fatalf("unexpected failures: %v", err)
+	}
+	// The other project is still deployed
+	mocks.find(t, "aws:cloudfront/distribution:Distribution", "zahradnictvi-sramek.cz-cdn")
+	mocks.find(t, "aws:lambda/function:Function", "sramek-garden-center-contact-form")
+}
+
+func stackConfig(t *testing.T, stack string, editSites func(sites []siteConfig)) map[string]string {
+	// Loads config of the given stack with site directories pointed to the test site
+	t.Helper()
+	content, err := os.ReadFile(filepath.Join("..", "Pulumi."+stack+".yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stackFile struct {
+		Config map[string]interface{} `yaml:"config"`
+	}
+	if err := yaml.Unmarshal(content, &stackFile); err != nil {
+		t.Fatal(err)
+	}
+
+	config := make(map[string]string, len(stackFile.Config))
+	for key, value := range stackFile.Config {
+		if str, ok := value.(string); ok {
+			config[key] = str
+			continue
+		}
+		// Structured values are passed to the program as JSON, the same way pulumi does
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		config[key] = string(encoded)
+	}
+
+	var sites []siteConfig
+	if err := json.Unmarshal([]byte(config["www-infra:sites"]), &sites); err != nil {
+		t.Fatal(err)
+	}
+	for i := range sites {
+		sites[i].Dir = testSiteDir
+	}
+	if editSites != nil {
+		editSites(sites)
+	}
+	encodedSites, err := json.Marshal(sites)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config["www-infra:sites"] = string(encodedSites)
+	return config
+}
+
+func TestStackSnapshots(t *testing.T) {
+	stackFiles, err := filepath.Glob(filepath.Join("..", "Pulumi.*.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, stackFile := range stackFiles {
+		stack := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(stackFile), "Pulumi."), ".yaml")
+		t.Run(stack, func(t *testing.T) {
+			mocks, err := runWithMocks(t, stack, stackConfig(t, stack, nil), deployInfrastructure)
+			if err != nil {
+				t.Fatal(err)
+			}
+			snapshot, err := mocks.snapshot()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			goldenFile := filepath.Join("testdata", "golden", stack+".json")
+			if *updateGolden {
+				if err := os.WriteFile(goldenFile, snapshot, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			golden, err := os.ReadFile(goldenFile)
+			if err != nil {
+				t.Fatalf("%v (run go test -update to create it)", err)
+			}
+			if !bytes.Equal(golden, snapshot) {
+				t.Errorf("registered resources of stack %s differ from %s (run go test -update to accept)", stack, goldenFile)
+			}
+		})
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/apigateway"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// registeredResource is a single resource registered during a mocked deployment
+type registeredResource struct {
+	Type           string                 `json:"type"`
+	Name           string                 `json:"name"`
+	Inputs         map[string]interface{} `json:"inputs"`
+	RetainOnDelete bool                   `json:"retainOnDelete,omitempty"`
+}
+
+// recordingMocks is a mock resource monitor which records every registered
+// resource, so tests can assert on the produced resource graph offline
+type recordingMocks struct {
+	mu        sync.Mutex
+	resources []registeredResource
+	// Reference to the stage of the email form REST API component, see readComponentChildren
+	emailFormStage resource.PropertyValue
+}
+
+func (m *recordingMocks) NewResource(args pulumi.MockResourceArgs) (string, resource.PropertyMap, error) {
+	if args.ReadRPC != nil {
+		// Read by the mocks themselves, see readComponentChildren
+		return args.ID, args.Inputs, nil
+	}
+	m.mu.Lock()
+	m.resources = append(m.resources, registeredResource{
+		Type:           args.TypeToken,
+		Name:           args.Name,
+		Inputs:         args.Inputs.Mappable(),
+		RetainOnDelete: args.RegisterRPC.GetRetainOnDelete(),
+	})
+	m.mu.Unlock()
+
+	outputs := args.Inputs.Copy()
+	for key, value := range mockOutputs(args) {
+		outputs[resource.PropertyKey(key)] = resource.NewPropertyValue(value)
+	}
+	if args.TypeToken == "aws-apigateway:index:RestAPI" {
+		outputs["stage"] = m.emailFormStage
+	}
+	return args.Name + "-id", outputs, nil
+}
+
+func mockOutputs(args pulumi.MockResourceArgs) map[string]interface{} {
+	// Computed outputs the deployment reads from the resources it creates
+	arn := fmt.Sprintf("arn:aws:mock:::%s", args.Name)
+	switch args.TypeToken {
+	case "aws:s3/bucket:Bucket":
+		bucketName := args.Name
+		if name, ok := args.Inputs["bucket"]; ok {
+			bucketName = name.StringValue()
+		}
+		return map[string]interface{}{
+			"arn":                      arn,
+			"bucket":                   bucketName,
+			"bucketDomainName":         args.Name + ".s3.amazonaws.com",
+			"bucketRegionalDomainName": args.Name + ".s3.eu-central-1.amazonaws.com",
+			"websiteEndpoint":          args.Name + ".s3-website.eu-central-1.amazonaws.com",
+		}
+	case "aws:cloudfront/distribution:Distribution":
+		return map[string]interface{}{
+			"arn":          arn,
+			"domainName":   args.Name + ".cloudfront.net",
+			"hostedZoneId": "Z2FDTNDATAQYW2",
+		}
+	case "aws:acm/certificate:Certificate":
+		domains := []string{args.Inputs["domainName"].StringValue()}
+		if sans, ok := args.Inputs["subjectAlternativeNames"]; ok {
+			domains = domains[:0]
+			for _, san := range sans.ArrayValue() {
+				domains = append(domains, san.StringValue())
+			}
+		}
+		options := make([]interface{}, len(domains))
+		for i, domain := range domains {
+			options[i] = map[string]interface{}{
+				"domainName":          domain,
+				"resourceRecordName":  "_validation." + domain,
+				"resourceRecordType":  "CNAME",
+				"resourceRecordValue": "_validation.acm-validations.aws",
+			}
+		}
+		return map[string]interface{}{"arn": arn, "domainValidationOptions": options}
+	case "aws:acm/certificateValidation:CertificateValidation":
+		return map[string]interface{}{"certificateArn": args.Inputs["certificateArn"].StringValue()}
+	case "aws:route53/record:Record":
+		return map[string]interface{}{"fqdn": args.Inputs["name"].StringValue()}
+	case "aws:lambda/function:Function":
+		return map[string]interface{}{"arn": arn, "qualifiedArn": arn + ":1"}
+	case "aws:ses/domainIdentity:DomainIdentity":
+		return map[string]interface{}{"arn": arn, "verificationToken": "token-" + args.Name}
+	case "aws:ses/domainDkim:DomainDkim":
+		return map[string]interface{}{"dkimTokens": []interface{}{"dkim1", "dkim2", "dkim3"}}
+	case "aws:sqs/queue:Queue":
+		return map[string]interface{}{"arn": arn, "url": "https://sqs.eu-central-1.amazonaws.com/123456789012/" + args.Name}
+	case "aws-apigateway:index:RestAPI":
+		return map[string]interface{}{"url": "https://" + args.Name + ".execute-api.amazonaws.com/prod/"}
+	default:
+		return map[string]interface{}{"arn": arn}
+	}
+}
+
+func (m *recordingMocks) Call(args pulumi.MockCallArgs) (resource.PropertyMap, error) {
+	switch args.Token {
+	case "aws:iam/getPolicyDocument:getPolicyDocument":
+		return resource.NewPropertyMapFromMap(map[string]interface{}{
+			"json": `{"Version":"2012-10-17","Statement":[]}`,
+		}), nil
+	case "aws:route53/getZone:getZone":
+		name := args.Args["name"].StringValue()
+		return resource.NewPropertyMapFromMap(map[string]interface{}{
+			"id":   "zone-" + name,
+			"name": name,
+		}), nil
+	case "archive:index/getFile:getFile":
+		outputs := args.Args.Copy()
+		outputs["outputBase64sha256"] = resource.NewStringProperty("mock-sha256")
+		return outputs, nil
+	}
+	return args.Args, nil
+}
+
+func (m *recordingMocks) byType(typeToken string) []registeredResource {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	found := make([]registeredResource, 0)
+	for _, res := range m.resources {
+		if res.Type == typeToken {
+			found = append(found, res)
+		}
+	}
+	return found
+}
+
+func (m *recordingMocks) find(t *testing.T, typeToken string, name string) registeredResource {
+	t.Helper()
+	for _, res := range m.byType(typeToken) {
+		if res.Name == name {
+			return res
+		}
+	}
+	t.Fatalf("resource %s of type %s was not registered", name, typeToken)
+	return registeredResource{}
+}
+
+func (m *recordingMocks) snapshot() ([]byte, error) {
+	// Serializes registered resources in a stable order for golden files
+	m.mu.Lock()
+	sorted := append([]registeredResource(nil), m.resources...)
+	m.mu.Unlock()
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Type != sorted[j].Type {
+			return sorted[i].Type < sorted[j].Type
+		}
+		return sorted[i].Name < sorted[j].Name
+	})
+	return json.MarshalIndent(sorted, "", "  ")
+}
+
+func runWithMocks(t *testing.T, stack string, config map[string]string, program pulumi.RunFunc) (*recordingMocks, error) {
+	// Runs the program against the recording mocks with the given stack config
+	t.Helper()
+	configJson, err := json.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(pulumi.EnvConfig, string(configJson))
+
+	mocks := &recordingMocks{}
+	err = pulumi.RunErr(func(ctx *pulumi.Context) error {
+		if err := mocks.readComponentChildren(ctx); err != nil {
+			return err
+		}
+		return program(ctx)
+	}, pulumi.WithMocks("www-infra", stack, mocks))
+	return mocks, err
+}
+
+func (m *recordingMocks) readComponentChildren(ctx *pulumi.Context) error {
+	// The mock monitor resolves the resource references in the outputs of a
+	// component only to resources it knows, so the children the components
+	// of the program refer to are read first
+	_, err := apigateway.GetStage(ctx, "email-form", pulumi.ID("email-form-stage-id"), &apigateway.StageState{
+		RestApi:   pulumi.String("email-form-id"),
+		StageName: pulumi.String("prod"),
+	})
+	urn := fmt.Sprintf("urn:pulumi:%s::%s::aws:apigateway/stage:Stage::email-form", ctx.Stack(), ctx.Project())
+	m.emailFormStage = resource.MakeCustomResourceReference(resource.URN(urn), "email-form-stage-id", "")
+	return err
+}
+
+func inputStrings(t *testing.T, res registeredResource, key string) []string {
+	// Reads a list of strings from the resource inputs
+	t.Helper()
+	values, ok := res.Inputs[key].([]interface{})
+	if !ok {
+		t.Fatalf("input %s of %s is not a list: %v", key, res.Name, res.Inputs[key])
+	}
+	strs := make([]string, len(values))
+	for i, value := range values {
+		strs[i] = fmt.Sprint(value)
+	}
+	return strs
+}
+
+func names(resources []registeredResource) string {
+	list := make([]string, len(resources))
+	for i, res := range resources {
+		list[i] = res.Name
+	}
+	sort.Strings(list)
+	return strings.Join(list, ",")
+}
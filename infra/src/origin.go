@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/cloudfront"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/s3"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+func distributionOrigin(ctx *pulumi.Context, project staticSiteProject, contentBucket *s3.Bucket) (cloudfront.DistributionOriginArgs, error) {
+	// Public sites are served from the S3 website endpoint, private ones from
+	// the S3 REST endpoint signed by an Origin Access Control
+	var origin cloudfront.DistributionOriginArgs
+	if !project.privateOrigin {
+		origin = cloudfront.DistributionOriginArgs{
+			OriginId:   contentBucket.Arn,
+			DomainName: contentBucket.WebsiteEndpoint,
+			CustomOriginConfig: cloudfront.DistributionOriginCustomOriginConfigArgs{
+				OriginProtocolPolicy: pulumi.String("http-only"),
+				HttpPort:             pulumi.Int(80),
+				HttpsPort:            pulumi.Int(443),
+				OriginSslProtocols:   pulumi.StringArray{pulumi.String("TLSv1.2")},
+			},
+		}
+	} else {
+		log.Printf("Creating origin access control for project: %s\n", project.name)
+		oacName := fmt.Sprintf("%s-oac", project.name)
+		oac, err := cloudfront.NewOriginAccessControl(ctx, oacName, &cloudfront.OriginAccessControlArgs{
+			Name:                          pulumi.String(oacName),
+			Description:                   pulumi.String(fmt.Sprintf("CloudFront access to the %s content bucket", project.name)),
+			OriginAccessControlOriginType: pulumi.String("s3"),
+			SigningBehavior:               pulumi.String("always"),
+			SigningProtocol:               pulumi.String("sigv4"),
+		})
+		if err != nil {
+			return cloudfront.DistributionOriginArgs{}, resourceErr(oacName, err)
+		}
+		origin = cloudfront.DistributionOriginArgs{
+			OriginId:              contentBucket.Arn,
+			DomainName:            contentBucket.BucketRegionalDomainName,
+			OriginAccessControlId: oac.ID(),
+		}
+	}
+
+	if project.precompress {
+		origin.CustomHeaders = cloudfront.DistributionOriginCustomHeaderArray{
+			cloudfront.DistributionOriginCustomHeaderArgs{
+				Name:  pulumi.String(indexDocumentHeader),
+				Value: pulumi.String(project.indexDoc),
+			},
+		}
+	}
+	return origin, nil
+}
+
+func allowDistributionRead(ctx *pulumi.Context, project staticSiteProject, contentBucket *s3.Bucket, distribution *cloudfront.Distribution) error {
+	// Grants read access to the private content bucket only to the given
+	// distribution, except for the content manifest
+	policyName := fmt.Sprintf("%s-bucket-policy", project.name)
+	policy := pulumi.All(contentBucket.Arn, distribution.Arn).ApplyT(func(args []interface{}) (string, error) {
+		bucketArn, distributionArn := args[0].(string), args[1].(string)
+		document, err := json.Marshal(map[string]interface{}{
+			"Version": "2012-10-17",
+			"Statement": []map[string]interface{}{
+				{
+					"Sid":       "AllowCloudFrontRead",
+					"Effect":    "Allow",
+					"Principal": map[string]string{"Service": "cloudfront.amazonaws.com"},
+					"Action":    []string{"s3:GetObject", "s3:ListBucket"},
+					"Resource":  []string{bucketArn, bucketArn + "/*"},
+					"Condition": map[string]interface{}{
+						"StringEquals": map[string]string{"AWS:SourceArn": distributionArn},
+					},
+				},
+				{
+					// The sync manifest lists every object of the site, it is
+					// read only by the deployment
+					"Sid":       "DenyCloudFrontManifest",
+					"Effect":    "Deny",
+					"Principal": map[string]string{"Service": "cloudfront.amazonaws.com"},
+					"Action":    []string{"s3:GetObject"},
+					"Resource":  []string{bucketArn + "/" + manifestKey},
+				},
+			},
+		})
+		return string(document), err
+	}).(pulumi.StringOutput)
+
+	_, err := s3.NewBucketPolicy(ctx, policyName, &s3.BucketPolicyArgs{
+		Bucket: contentBucket.ID(),
+		Policy: policy,
+	})
+	return resourceErr(policyName, err)
+}
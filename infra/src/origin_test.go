@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestDeployProjectPrivateOrigin(t *testing.T) {
+	project := testProject("example.com", "www.example.com")
+	project.privateOrigin = true
+	mocks, err := runWithMocks(t, "test", nil, deployTestProject(project))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bucket := mocks.find(t, "aws:s3/bucket:Bucket", "test-site-bucket")
+	if _, ok := bucket.Inputs["website"]; ok {
+		t.Errorf("private bucket must not be a website: %v", bucket.Inputs)
+	}
+	accessBlock := mocks.find(t, "aws:s3/bucketPublicAccessBlock:BucketPublicAccessBlock", "test-site-public-access-block")
+	for _, key := range []string{"blockPublicAcls", "blockPublicPolicy", "ignorePublicAcls", "restrictPublicBuckets"} {
+		if accessBlock.Inputs[key] != true {
+			t.Errorf("public access block %s = %v, want true", key, accessBlock.Inputs[key])
+		}
+	}
+	for _, object := range mocks.byType("aws:s3/bucketObject:BucketObject") {
+		if acl, ok := object.Inputs["acl"]; ok {
+			t.Errorf("object %s has acl %v", object.Name, acl)
+		}
+	}
+
+	mocks.find(t, "aws:cloudfront/originAccessControl:OriginAccessControl", "test-site-oac")
+	distribution := mocks.find(t, "aws:cloudfront/distribution:Distribution", "example.com-cdn")
+	origin := distribution.Inputs["origins"].([]interface{})[0].(map[string]interface{})
+	if origin["domainName"] != "test-site-bucket.s3.eu-central-1.amazonaws.com" || origin["originAccessControlId"] != "test-site-oac-id" {
+		t.Errorf("unexpected origin %v", origin)
+	}
+	if _, ok := origin["customOriginConfig"]; ok {
+		t.Errorf("private origin must not use the website endpoint: %v", origin)
+	}
+	errorResponses := distribution.Inputs["customErrorResponses"].([]interface{})
+	if len(errorResponses) != 4 {
+		t.Fatalf("expected 403, 404, 500 and 503 error responses, got %v", errorResponses)
+	}
+	for _, response := range errorResponses[:2] {
+		response := response.(map[string]interface{})
+		if response["responsePagePath"] != "/error.html" || response["responseCode"] != 404.0 {
+			t.Errorf("unexpected error response %v", response)
+		}
+	}
+
+	policy := mocks.find(t, "aws:s3/bucketPolicy:BucketPolicy", "test-site-bucket-policy")
+	var document struct {
+		Statement []struct {
+			Effect    string
+			Principal map[string]string
+			Resource  []string
+			Condition map[string]map[string]string
+		}
+	}
+	if err := json.Unmarshal([]byte(policy.Inputs["policy"].(string)), &document); err != nil {
+		t.Fatal(err)
+	}
+	statement := document.Statement[0]
+	if statement.Principal["Service"] != "cloudfront.amazonaws.com" {
+		t.Errorf("unexpected principal %v", statement.Principal)
+	}
+	if statement.Condition["StringEquals"]["AWS:SourceArn"] != "arn:aws:mock:::example.com-cdn" {
+		t.Errorf("bucket policy is not limited to the distribution: %v", statement.Condition)
+	}
+	manifest := document.Statement[1]
+	if manifest.Effect != "Deny" || len(manifest.Resource) != 1 || manifest.Resource[0] != "arn:aws:mock:::test-site-bucket/"+manifestKey {
+		t.Errorf("content manifest is readable by the distribution: %+v", manifest)
+	}
+}
+
+func TestPrivateOriginRequiresDomain(t *testing.T) {
+	project := testProject("")
+	project.privateOrigin = true
+	if _, err := runWithMocks(t, "test", nil, deployTestProject(project)); err == nil {
+		t.Error("expected error for private origin without domain")
+	}
+}
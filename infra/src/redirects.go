@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultRedirectStatus = 301
+	// Path appended to prefixes when following redirects to find loops
+	redirectCheckPage = "page.html"
+)
+
+// redirectRule redirects a path to the target. A path ending with * is a
+// prefix matching all paths under it, a target ending with * gets the rest
+// of the path appended. Exact paths win over prefixes, longer prefixes over
+// shorter ones. Targets are paths of the site or absolute URLs
+type redirectRule struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Status int    `json:"status"` // 301 or 302, defaults to 301
+
+	source string // where the rule is defined, named in errors
+}
+
+// redirectRules of a site, from its config followed by its redirects file
+type redirectRules []redirectRule
+
+func (rule redirectRule) String() string {
+	if rule.source == "" {
+		return rule.From
+	}
+	return fmt.Sprintf("%s (%s)", rule.From, rule.source)
+}
+
+func (rule redirectRule) prefix() (string, bool) {
+	if strings.HasSuffix(rule.From, "*") {
+		return strings.TrimSuffix(rule.From, "*"), true
+	}
+	return rule.From, false
+}
+
+func (rule redirectRule) status() int {
+	if rule.Status == 0 {
+		return defaultRedirectStatus
+	}
+	return rule.Status
+}
+
+func validRedirectPath(path string) bool {
+	// Paths are matched against the request uri as sent, so they are written
+	// percent-encoded and without query string
+	if !strings.HasPrefix(path, "/") || strings.ContainsAny(path, "?#") {
+		return false
+	}
+	for _, char := range path {
+		if char <= ' ' || char > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func validRedirectUrl(target string) bool {
+	parsed, err := url.Parse(target)
+	if err != nil {
+		return false
+	}
+	return (parsed.Scheme == "https" || parsed.Scheme == "http") && parsed.Host != "" && parsed.RawQuery == "" && parsed.Fragment == ""
+}
+
+func (rule redirectRule) validate() error {
+	from, prefix := rule.prefix()
+	if !validRedirectPath(from) || strings.Contains(from, "*") {
+		return fmt.Errorf("redirect %s: invalid path, must start with / and may end with *", rule)
+	}
+	to := strings.TrimSuffix(rule.To, "*")
+	if strings.HasSuffix(rule.To, "*") && !prefix {
+		return fmt.Errorf("redirect %s: only prefixes can append the rest of the path to %s", rule, rule.To)
+	}
+	if strings.Contains(to, "*") || (!validRedirectPath(to) && !validRedirectUrl(to)) {
+		return fmt.Errorf("redirect %s: invalid target %q, must be a path or an absolute URL without query string", rule, rule.To)
+	}
+	if status := rule.status(); status != 301 && status != 302 {
+		return fmt.Errorf("redirect %s: invalid status %d, must be 301 or 302", rule, status)
+	}
+	return nil
+}
+
+func (rules redirectRules) validate() error {
+	// Checks the rules one by one, conflicts and loops are checked by checkRedirects
+	for _, rule := range rules {
+		if err := rule.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parseRedirectsFile(path string) (redirectRules, error) {
+	// Reads a redirects file, each line is "from to [status]". Empty lines
+	// and lines starting with # are skipped
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var rules redirectRules
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		source := fmt.Sprintf("%s:%d", path, line)
+		if len(fields) > 3 || len(fields) < 2 {
+			return nil, fmt.Errorf("%s: expected from, to and optional status, got %q", source, scanner.Text())
+		}
+		rule := redirectRule{From: fields[0], To: fields[1], source: source}
+		if len(fields) == 3 {
+			if rule.Status, err = strconv.Atoi(fields[2]); err != nil {
+				return nil, fmt.Errorf("%s: invalid status %q", source, fields[2])
+			}
+		}
+		rules = append(rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+func siteRedirects(project staticSiteProject) (redirectRules, error) {
+	// Rules of the site config followed by the rules of its redirects file
+	rules := make(redirectRules, 0, len(project.redirects))
+	for i, rule := range project.redirects {
+		rule.source = fmt.Sprintf("config redirect %d", i)
+		rules = append(rules, rule)
+	}
+	if project.redirectsFile == "" {
+		return rules, nil
+	}
+	// A relative path is relative to the site directory, not to where pulumi runs
+	path := project.redirectsFile
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(project.dir, path)
+	}
+	fileRules, err := parseRedirectsFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading redirects file: %w", err)
+	}
+	if err := fileRules.validate(); err != nil {
+		return nil, err
+	}
+	return append(rules, fileRules...), nil
+}
+
+func (rules redirectRules) match(path string) (string, bool) {
+	// Target of the path the way the viewer request function resolves it
+	var best redirectRule
+	bestPrefix := ""
+	for _, rule := range rules {
+		from, prefix := rule.prefix()
+		if !prefix && from == path {
+			return rule.To, true
+		}
+		if prefix && strings.HasPrefix(path, from) && len(from) > len(bestPrefix) {
+			best, bestPrefix = rule, from
+		}
+	}
+	if bestPrefix == "" {
+		return "", false
+	}
+	if strings.HasSuffix(best.To, "*") {
+		return strings.TrimSuffix(best.To, "*") + strings.TrimPrefix(path, bestPrefix), true
+	}
+	return best.To, true
+}
+
+func normalizeTrailingSlash(path string, trailingSlash string) string {
+	// Same normalization as the viewer request function
+	name := path[strings.LastIndex(path, "/")+1:]
+	switch {
+	case trailingSlash == trailingSlashAdd && name != "" && !strings.Contains(name, "."):
+		return path + "/"
+	case trailingSlash == trailingSlashRemove && len(path) > 1 && name == "":
+		if trimmed := strings.TrimRight(path, "/"); trimmed != "" {
+			return trimmed
+		}
+		return "/"
+	}
+	return path
+}
+
+func sitePath(target string, hosts []string) (string, bool) {
+	// Path of the target when the site serves it, external targets end a redirect chain
+	if strings.HasPrefix(target, "/") {
+		return target, true
+	}
+	parsed, err := url.Parse(target)
+	if err != nil {
+		return "", false
+	}
+	for _, host := range hosts {
+		if strings.EqualFold(parsed.Host, host) {
+			if parsed.EscapedPath() == "" {
+				return "/", true
+			}
+			return parsed.EscapedPath(), true
+		}
+	}
+	return "", false
+}
+
+func checkRedirects(rules redirectRules, hosts []string, trailingSlash string) error {
+	// Fails on rules redirecting the same path twice and on redirects the
+	// viewer would follow forever, all problems are reported at once
+	var errs []error
+	defined := make(map[string]redirectRule, len(rules))
+	for _, rule := range rules {
+		if previous, ok := defined[rule.From]; ok {
+			errs = append(errs, fmt.Errorf("redirect %s is defined by %s and %s", rule.From, previous.source, rule.source))
+			continue
+		}
+		defined[rule.From] = rule
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	for _, rule := range rules {
+		from, prefix := rule.prefix()
+		if prefix {
+			from += redirectCheckPage
+		}
+		visited := map[string]bool{from: true}
+		chain := []string{from}
+		path := from
+		for {
+			target, ok := "", false
+			if matched, found := rules.match(path); found {
+				target, ok = sitePath(matched, hosts)
+			} else if normalized := normalizeTrailingSlash(path, trailingSlash); normalized != path {
+				target, ok = normalized, true
+			}
+			if !ok {
+				break
+			}
+			chain = append(chain, target)
+			if visited[target] || len(chain) > len(rules)+2 {
+				errs = append(errs, fmt.Errorf("redirect %s loops: %s", rule, strings.Join(chain, " -> ")))
+				break
+			}
+			visited[target] = true
+			path = target
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// edgeRedirect is a redirect of the viewer request function
+type edgeRedirect struct {
+	To     string `json:"to"`
+	Status int    `json:"status"`
+}
+
+// edgePrefixRedirect redirects all paths under the prefix, with the rest of
+// the path appended to the target when splat is set
+type edgePrefixRedirect struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Splat  bool   `json:"splat"`
+	Status int    `json:"status"`
+}
+
+func compileRedirects(rules redirectRules) (map[string]edgeRedirect, []edgePrefixRedirect) {
+	// Exact paths are looked up by the function, prefixes are tried longest first
+	exact := make(map[string]edgeRedirect)
+	prefixes := make([]edgePrefixRedirect, 0)
+	for _, rule := range rules {
+		from, prefix := rule.prefix()
+		if !prefix {
+			exact[from] = edgeRedirect{To: rule.To, Status: rule.status()}
+			continue
+		}
+		prefixes = append(prefixes, edgePrefixRedirect{
+			From:   from,
+			To:     strings.TrimSuffix(rule.To, "*"),
+			Splat:  strings.HasSuffix(rule.To, "*"),
+			Status: rule.status(),
+		})
+	}
+	sort.SliceStable(prefixes, func(i, j int) bool { return len(prefixes[i].From) > len(prefixes[j].From) })
+	return exact, prefixes
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRedirectRulesValidate(t *testing.T) {
+	valid := redirectRules{
+		{From: "/old.html", To: "/new.html"},
+		{From: "/blog/*", To: "/clanky/*", Status: 302},
+		{From: "/shop/*", To: "https://shop.example.org/"},
+		{From: "/caf%C3%A9.html", To: "http://example.org/cafe"},
+	}
+	if err := valid.validate(); err != nil {
+		t.Fatalf("valid redirects rejected: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		rules redirectRules
+		err   string
+	}{
+		{"relative path", redirectRules{{From: "old.html", To: "/new.html"}}, "invalid path"},
+		{"query in path", redirectRules{{From: "/old.php?id=1", To: "/new.html"}}, "invalid path"},
+		{"inner star", redirectRules{{From: "/a/*/b", To: "/b"}}, "invalid path"},
+		{"space in path", redirectRules{{From: "/old page.html", To: "/new.html"}}, "invalid path"},
+		{"splat without prefix", redirectRules{{From: "/old.html", To: "/new/*"}}, "only prefixes"},
+		{"missing target", redirectRules{{From: "/old.html"}}, "invalid target"},
+		{"relative target", redirectRules{{From: "/old.html", To: "new.html"}}, "invalid target"},
+		{"target query", redirectRules{{From: "/old.html", To: "https://example.org/?a=b"}}, "invalid target"},
+		{"target scheme", redirectRules{{From: "/old.html", To: "ftp://example.org/"}}, "invalid target"},
+		{"status", redirectRules{{From: "/old.html", To: "/new.html", Status: 307}}, "invalid status 307"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.rules.validate(); err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("validate() = %v, want error containing %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestParseRedirectsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "_redirects")
+	content := "# Old site\n\n/old.html   /new.html\n/blog/*  /clanky/*  302\n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	rules, err := parseRedirectsFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := redirectRules{
+		{From: "/old.html", To: "/new.html", source: path + ":3"},
+		{From: "/blog/*", To: "/clanky/*", Status: 302, source: path + ":4"},
+	}
+	if !reflect.DeepEqual(rules, want) {
+		t.Errorf("parseRedirectsFile() = %v, want %v", rules, want)
+	}
+
+	for _, line := range []string{"/old.html\n", "/old.html /new.html 301 extra\n", "/old.html /new.html moved\n"} {
+		if err := os.WriteFile(path, []byte(line), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := parseRedirectsFile(path); err == nil || !strings.Contains(err.Error(), path+":1") {
+			t.Errorf("parseRedirectsFile(%q) = %v, want error naming the line", line, err)
+		}
+	}
+}
+
+func TestSiteRedirects(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "_redirects")
+	if err := os.WriteFile(path, []byte("/b.html /c.html\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	project := testProject("example.com")
+	project.dir = filepath.Dir(path)
+	project.redirects = redirectRules{{From: "/a.html", To: "/b.html"}}
+	project.redirectsFile = "_redirects"
+	rules, err := siteRedirects(project)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 2 || rules[0].source != "config redirect 0" || rules[1].source != path+":1" {
+		t.Errorf("siteRedirects() = %v", rules)
+	}
+	project.dir = "testdata/site"
+	project.redirectsFile = path
+	if rules, err := siteRedirects(project); err != nil || len(rules) != 2 {
+		t.Errorf("siteRedirects() with an absolute path = %v, %v", rules, err)
+	}
+
+	if err := os.WriteFile(path, []byte("/b.html c.html\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := siteRedirects(project); err == nil || !strings.Contains(err.Error(), path+":1") {
+		t.Errorf("siteRedirects() with an invalid file = %v", err)
+	}
+	project.redirectsFile = filepath.Join(t.TempDir(), "missing")
+	if _, err := siteRedirects(project); err == nil || !strings.Contains(err.Error(), "reading redirects file") {
+		t.Errorf("siteRedirects() with a missing file = %v", err)
+	}
+}
+
+func TestRedirectRulesMatch(t *testing.T) {
+	rules := redirectRules{
+		{From: "/blog/*", To: "/clanky/*"},
+		{From: "/blog/archive/*", To: "/archiv.html"},
+		{From: "/blog/index.html", To: "/clanky/"},
+	}
+	tests := []struct {
+		path string
+		want string
+		ok   bool
+	}{
+		{"/blog/index.html", "/clanky/", true},
+		{"/blog/2019/zima.html", "/clanky/2019/zima.html", true},
+		{"/blog/archive/2010/", "/archiv.html", true},
+		{"/blog", "", false},
+		{"/cenik.html", "", false},
+	}
+	for _, test := range tests {
+		if target, ok := rules.match(test.path); target != test.want || ok != test.ok {
+			t.Errorf("match(%s) = %s, %v, want %s, %v", test.path, target, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestCheckRedirects(t *testing.T) {
+	hosts := []string{"example.com", "www.example.com"}
+	valid := redirectRules{
+		{From: "/a.html", To: "/b.html"},
+		{From: "/b.html", To: "/c.html"},
+		{From: "/old/*", To: "/new/*"},
+		{From: "/external.html", To: "https://example.org/a.html"},
+		{From: "/c.html", To: "https://example.org/c.html"},
+	}
+	if err := checkRedirects(valid, hosts, ""); err != nil {
+		t.Errorf("checkRedirects() of valid redirects = %v", err)
+	}
+
+	tests := []struct {
+		name          string
+		rules         redirectRules
+		trailingSlash string
+		err           string
+	}{
+		{
+			"duplicate",
+			redirectRules{{From: "/a.html", To: "/b.html", source: "config redirect 0"}, {From: "/a.html", To: "/c.html", source: "_redirects:1"}},
+			"",
+			"redirect /a.html is defined by config redirect 0 and _redirects:1",
+		},
+		{
+			"self",
+			redirectRules{{From: "/a.html", To: "/a.html"}},
+			"",
+			"loops: /a.html -> /a.html",
+		},
+		{
+			"cycle",
+			redirectRules{{From: "/a.html", To: "/b.html"}, {From: "/b.html", To: "/a.html"}},
+			"",
+			"loops: /a.html -> /b.html -> /a.html",
+		},
+		{
+			"own host",
+			redirectRules{{From: "/a.html", To: "https://www.example.com/b.html"}, {From: "/b.html", To: "/a.html"}},
+			"",
+			"loops: /a.html -> /b.html -> /a.html",
+		},
+		{
+			"growing splat",
+			redirectRules{{From: "/a/*", To: "/a/b/*"}},
+			"",
+			"redirect /a/* loops",
+		},
+		{
+			"trailing slash",
+			redirectRules{{From: "/docs/", To: "/docs"}},
+			trailingSlashAdd,
+			"loops: /docs/ -> /docs -> /docs/",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := checkRedirects(test.rules, hosts, test.trailingSlash); err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("checkRedirects() = %v, want error containing %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestCompileRedirects(t *testing.T) {
+	rules := redirectRules{
+		{From: "/a.html", To: "/b.html", Status: 302},
+		{From: "/blog/*", To: "/clanky/*"},
+		{From: "/blog/archive/*", To: "https://archive.example.org/"},
+	}
+	exact, prefixes := compileRedirects(rules)
+	if want := map[string]edgeRedirect{"/a.html": {To: "/b.html", Status: 302}}; !reflect.DeepEqual(exact, want) {
+		t.Errorf("exact redirects = %v, want %v", exact, want)
+	}
+	want := []edgePrefixRedirect{
+		{From: "/blog/archive/", To: "https://archive.example.org/", Status: 301},
+		{From: "/blog/", To: "/clanky/", Splat: true, Status: 301},
+	}
+	if !reflect.DeepEqual(prefixes, want) {
+		t.Errorf("prefix redirects = %v, want %v", prefixes, want)
+	}
+}
+
+func TestDeployProjectRedirects(t *testing.T) {
+	project := testProject("example.com")
+	project.redirects = redirectRules{{From: "/old.html", To: "/index.html"}}
+	mocks, err := runWithMocks(t, "test", nil, deployTestProject(project))
+	if err != nil {
+		t.Fatal(err)
+	}
+	function := mocks.find(t, "aws:cloudfront/function:Function", "test-site-viewer-request")
+	if code := function.Inputs["code"].(string); !strings.Contains(code, `"redirects":{"/old.html":{"to":"/index.html","status":301}}`) {
+		t.Errorf("function code has no redirects:\n%s", code)
+	}
+}
+
+func TestDeployProjectRedirectLoop(t *testing.T) {
+	project := testProject("example.com")
+	project.redirects = redirectRules{{From: "/a.html", To: "/b.html"}, {From: "/b.html", To: "/a.html"}}
+	mocks, err := runWithMocks(t, "test", nil, deployTestProject(project))
+	if err == nil || !strings.Contains(err.Error(), "loops") {
+		t.Fatalf("deploy with a redirect loop = %v", err)
+	}
+	if buckets := mocks.byType("aws:s3/bucket:Bucket"); len(buckets) != 1 {
+		t.Errorf("site resources created before the check: %s", names(buckets))
+	}
+}
+
+func TestDeployProjectRedirectsRequireDomain(t *testing.T) {
+	project := testProject("")
+	project.redirects = redirectRules{{From: "/old.html", To: "/index.html"}}
+	_, err := runWithMocks(t, "test", nil, deployTestProject(project))
+	if err == nil || !strings.Contains(err.Error(), "redirects require a domain") {
+		t.Errorf("deploy of redirects without domain = %v", err)
+	}
+}
+
+func TestViewerRequestCodeSize(t *testing.T) {
+	redirects := make(map[string]edgeRedirect)
+	for i := 0; i < 500; i++ {
+		redirects[fmt.Sprintf("/old/page-%d.html", i)] = edgeRedirect{To: "/index.html", Status: 301}
+	}
+	_, err := viewerRequestCode(viewerRequestConfig{Host: "example.com", Redirects: redirects})
+	if err == nil || !strings.Contains(err.Error(), "CloudFront accepts at most") {
+		t.Errorf("viewerRequestCode() of too many redirects = %v", err)
+	}
+}
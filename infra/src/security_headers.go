@@ -0,0 +1,327 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/cloudfront"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+)
+
+const (
+	// Two years, the max-age required for the HSTS preload list
+	defaultHstsMaxAge       = 63072000
+	defaultReferrerPolicy   = "strict-origin-when-cross-origin"
+	defaultFrameOption      = "DENY"
+	defaultPermissionPolicy = "accelerometer=(), camera=(), geolocation=(), gyroscope=(), magnetometer=(), microphone=(), payment=(), usb=()"
+	// Longest Content-Security-Policy CloudFront accepts in a response headers policy
+	maxCspLength = 1783
+)
+
+// cspDirective is a Content-Security-Policy directive with its sources
+type cspDirective struct {
+	name    string
+	sources []string
+}
+
+// Strict policy every site starts from, sources of the site config and its
+// presets are added to it. Sources replace 'none'
+var defaultCsp = []cspDirective{
+	{"default-src", []string{"'self'"}},
+	{"script-src", []string{"'self'"}},
+	{"style-src", []string{"'self'"}},
+	{"img-src", []string{"'self'", "data:"}},
+	{"font-src", []string{"'self'"}},
+	{"connect-src", []string{"'self'"}},
+	{"media-src", []string{"'self'"}},
+	{"frame-src", []string{"'none'"}},
+	{"object-src", []string{"'none'"}},
+	{"base-uri", []string{"'self'"}},
+	{"form-action", []string{"'self'"}},
+	{"frame-ancestors", []string{"'none'"}},
+}
+
+// Sources needed by the libraries and services the sites use
+var cspPresets = map[string]map[string][]string{
+	// Font Awesome inlines its SVG styles and small icon fonts
+	"fontawesome": {"style-src": {"'unsafe-inline'"}, "font-src": {"data:"}},
+	// GLightbox styles the slides inline and plays videos through Plyr and embedded players
+	"glightbox": {
+		"style-src":  {"'unsafe-inline'", "https://cdn.plyr.io"},
+		"script-src": {"https://cdn.plyr.io"},
+		"frame-src":  {"https://www.youtube-nocookie.com", "https://www.youtube.com", "https://player.vimeo.com"},
+		"media-src":  {"https://cdn.plyr.io"},
+	},
+	// Swiper positions the slides with inline styles
+	"swiper":           {"style-src": {"'unsafe-inline'"}},
+	"google-fonts":     {"style-src": {"https://fonts.googleapis.com"}, "font-src": {"https://fonts.gstatic.com"}},
+	"google-analytics": {"script-src": {"https://www.googletagmanager.com"}, "img-src": {"https://*.google-analytics.com", "https://*.googletagmanager.com"}, "connect-src": {"https://*.google-analytics.com", "https://*.analytics.google.com", "https://*.googletagmanager.com"}},
+	"google-maps":      {"frame-src": {"https://www.google.com"}},
+	"youtube":          {"frame-src": {"https://www.youtube-nocookie.com", "https://www.youtube.com"}},
+	// CAPTCHA widgets of the contact form, added for the configured provider
+	"turnstile": {"script-src": {"https://challenges.cloudflare.com"}, "frame-src": {"https://challenges.cloudflare.com"}},
+	"recaptcha": {"script-src": {"https://www.google.com", "https://www.gstatic.com"}, "frame-src": {"https://www.google.com"}},
+	"hcaptcha":  {"script-src": {"https://hcaptcha.com", "https://*.hcaptcha.com"}, "frame-src": {"https://hcaptcha.com", "https://*.hcaptcha.com"}, "style-src": {"https://hcaptcha.com", "https://*.hcaptcha.com"}, "connect-src": {"https://hcaptcha.com", "https://*.hcaptcha.com"}},
+}
+
+var (
+	cspKeywordPattern = regexp.MustCompile(`^'(self|none|unsafe-inline|unsafe-eval|unsafe-hashes|strict-dynamic|wasm-unsafe-eval|(sha256|sha384|sha512)-[A-Za-z0-9+/]+={0,2})'$`)
+	cspSourcePattern  = regexp.MustCompile(`^([a-z][a-z0-9+.-]*:|(https?://)?(\*\.)?[a-z0-9.-]+(:[0-9]+|:\*)?(/[^\s;,']*)?)$`)
+	// Inline scripts of the site documents, scripts with a src are loaded from their source
+	inlineScriptPattern = regexp.MustCompile(`(?is)<script(\s[^>]*)?>(.*?)</script>`)
+	scriptSrcPattern    = regexp.MustCompile(`(?i)\ssrc\s*=`)
+)
+
+// Values accepted by CloudFront for the headers
+var (
+	referrerPolicies = []string{"no-referrer", "no-referrer-when-downgrade", "origin", "origin-when-cross-origin", "same-origin", "strict-origin", "strict-origin-when-cross-origin", "unsafe-url"}
+	frameOptions     = []string{"DENY", "SAMEORIGIN"}
+)
+
+// securityHeaders are the security response headers of a site, unset fields
+// keep the strict defaults
+type securityHeaders struct {
+	// Libraries and services the Content-Security-Policy allows, see cspPresets
+	CspPresets []string `json:"csp-presets"`
+	// Extra sources by Content-Security-Policy directive
+	Csp map[string][]string `json:"csp"`
+	// Seconds browsers use only https for the site, defaults to two years
+	HstsMaxAge            int    `json:"hsts-max-age"`
+	HstsIncludeSubdomains bool   `json:"hsts-include-subdomains"`
+	HstsPreload           bool   `json:"hsts-preload"`
+	ReferrerPolicy        string `json:"referrer-policy"`
+	PermissionsPolicy     string `json:"permissions-policy"`
+	// X-Frame-Options, DENY or SAMEORIGIN
+	FrameOption string `json:"frame-option"`
+}
+
+func containsString(values []string, value string) bool {
+	for _, item := range values {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+func cspDirectiveNames() []string {
+	names := make([]string, len(defaultCsp))
+	for i, directive := range defaultCsp {
+		names[i] = directive.name
+	}
+	return names
+}
+
+func (headers *securityHeaders) validate() error {
+	if headers == nil {
+		return nil
+	}
+	for _, preset := range headers.CspPresets {
+		if _, ok := cspPresets[preset]; !ok {
+			return fmt.Errorf("security-headers: unknown csp preset %q", preset)
+		}
+	}
+	for name, sources := range headers.Csp {
+		if !containsString(cspDirectiveNames(), name) {
+			return fmt.Errorf("security-headers: unknown csp directive %q, must be one of %s", name, strings.Join(cspDirectiveNames(), ", "))
+		}
+		for _, source := range sources {
+			if !cspKeywordPattern.MatchString(source) && !cspSourcePattern.MatchString(source) {
+				return fmt.Errorf("security-headers: invalid csp source %q of %s", source, name)
+			}
+		}
+	}
+	if headers.HstsMaxAge < 0 {
+		return fmt.Errorf("security-headers: hsts-max-age must not be negative")
+	}
+	if headers.HstsPreload && (!headers.HstsIncludeSubdomains || headers.hstsMaxAge() < 31536000) {
+		return fmt.Errorf("security-headers: hsts-preload requires hsts-include-subdomains and an hsts-max-age of at least a year")
+	}
+	if headers.ReferrerPolicy != "" && !containsString(referrerPolicies, headers.ReferrerPolicy) {
+		return fmt.Errorf("security-headers: invalid referrer-policy %q", headers.ReferrerPolicy)
+	}
+	if headers.FrameOption != "" && !containsString(frameOptions, headers.FrameOption) {
+		return fmt.Errorf("security-headers: invalid frame-option %q, must be DENY or SAMEORIGIN", headers.FrameOption)
+	}
+	return nil
+}
+
+func (headers *securityHeaders) hstsMaxAge() int {
+	if headers == nil || headers.HstsMaxAge == 0 {
+		return defaultHstsMaxAge
+	}
+	return headers.HstsMaxAge
+}
+
+func (headers *securityHeaders) referrerPolicy() string {
+	if headers == nil || headers.ReferrerPolicy == "" {
+		return defaultReferrerPolicy
+	}
+	return headers.ReferrerPolicy
+}
+
+func (headers *securityHeaders) frameOption() string {
+	if headers == nil || headers.FrameOption == "" {
+		return defaultFrameOption
+	}
+	return headers.FrameOption
+}
+
+func (headers *securityHeaders) permissionsPolicy() string {
+	if headers == nil || headers.PermissionsPolicy == "" {
+		return defaultPermissionPolicy
+	}
+	return headers.PermissionsPolicy
+}
+
+func inlineScriptHashes(dir string) ([]string, error) {
+	// CSP hashes of the inline scripts of all documents of the site, so they
+	// run without allowing every inline script
+	unique := make(map[string]bool)
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.Type().IsRegular() || !strings.EqualFold(filepath.Ext(path), ".html") {
+			return err
+		}
+		document, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		for _, match := range inlineScriptPattern.FindAllSubmatch(document, -1) {
+			if scriptSrcPattern.Match(match[1]) || len(strings.TrimSpace(string(match[2]))) == 0 {
+				continue
+			}
+			sum := sha256.Sum256(match[2])
+			unique[fmt.Sprintf("'sha256-%s'", base64.StdEncoding.EncodeToString(sum[:]))] = true
+		}
+		return nil
+	})
+	hashes := make([]string, 0, len(unique))
+	for hash := range unique {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+	return hashes, err
+}
+
+func contentSecurityPolicy(project staticSiteProject, region string, scriptHashes []string) (string, error) {
+	// Adds the presets, the sources of the site, the form endpoint and the
+	// inline script hashes to the strict default policy
+	headers := project.securityHeaders
+	extra := make(map[string][]string)
+	add := func(sources map[string][]string) {
+		for name, values := range sources {
+			extra[name] = append(extra[name], values...)
+		}
+	}
+	if headers != nil {
+		for _, preset := range headers.CspPresets {
+			add(cspPresets[preset])
+		}
+		add(headers.Csp)
+	}
+	if form := project.contactForm; form != nil {
+		if region == "" {
+			return "", fmt.Errorf("security-headers: aws:region is required to allow the contact form endpoint")
+		}
+		add(map[string][]string{"connect-src": {fmt.Sprintf("https://*.execute-api.%s.amazonaws.com", region)}})
+		if form.Captcha != nil {
+			add(cspPresets[form.Captcha.Provider])
+		}
+	}
+	// Hashes disable 'unsafe-inline', so they are left out when the site allows it
+	if !containsString(extra["script-src"], "'unsafe-inline'") {
+		add(map[string][]string{"script-src": scriptHashes})
+	}
+	if headers.frameOption() == "SAMEORIGIN" {
+		extra["frame-ancestors"] = append(extra["frame-ancestors"], "'self'")
+	}
+
+	parts := make([]string, 0, len(defaultCsp)+1)
+	for _, directive := range defaultCsp {
+		sources := directive.sources
+		if len(extra[directive.name]) > 0 && containsString(sources, "'none'") {
+			sources = nil
+		}
+		seen := make(map[string]bool)
+		unique := make([]string, 0, len(sources)+len(extra[directive.name]))
+		for _, source := range append(append([]string{}, sources...), extra[directive.name]...) {
+			if !seen[source] {
+				seen[source] = true
+				unique = append(unique, source)
+			}
+		}
+		parts = append(parts, fmt.Sprintf("%s %s", directive.name, strings.Join(unique, " ")))
+	}
+	parts = append(parts, "upgrade-insecure-requests")
+	policy := strings.Join(parts, "; ")
+	if len(policy) > maxCspLength {
+		return "", fmt.Errorf("security-headers: content security policy has %d characters, CloudFront accepts at most %d", len(policy), maxCspLength)
+	}
+	return policy, nil
+}
+
+func securityHeadersPolicy(ctx *pulumi.Context, project staticSiteProject) (*cloudfront.ResponseHeadersPolicy, error) {
+	// Response headers policy of the site distribution, it overrides headers
+	// sent by the origin so the bucket cannot weaken them
+	headers := project.securityHeaders
+	scriptHashes, err := inlineScriptHashes(project.dir)
+	if err != nil {
+		return nil, fmt.Errorf("hashing inline scripts of %s: %w", project.dir, err)
+	}
+	csp, err := contentSecurityPolicy(project, config.Get(ctx, "aws:region"), scriptHashes)
+	if err != nil {
+		return nil, err
+	}
+
+	policyName := fmt.Sprintf("%s-security-headers", project.name)
+	log.Printf("Creating response headers policy %s\n", policyName)
+	policy, err := cloudfront.NewResponseHeadersPolicy(ctx, policyName, &cloudfront.ResponseHeadersPolicyArgs{
+		Name:    pulumi.String(policyName),
+		Comment: pulumi.String(fmt.Sprintf("Security headers of %s", project.name)),
+		SecurityHeadersConfig: &cloudfront.ResponseHeadersPolicySecurityHeadersConfigArgs{
+			ContentSecurityPolicy: &cloudfront.ResponseHeadersPolicySecurityHeadersConfigContentSecurityPolicyArgs{
+				ContentSecurityPolicy: pulumi.String(csp),
+				Override:              pulumi.Bool(true),
+			},
+			ContentTypeOptions: &cloudfront.ResponseHeadersPolicySecurityHeadersConfigContentTypeOptionsArgs{
+				Override: pulumi.Bool(true),
+			},
+			FrameOptions: &cloudfront.ResponseHeadersPolicySecurityHeadersConfigFrameOptionsArgs{
+				FrameOption: pulumi.String(headers.frameOption()),
+				Override:    pulumi.Bool(true),
+			},
+			ReferrerPolicy: &cloudfront.ResponseHeadersPolicySecurityHeadersConfigReferrerPolicyArgs{
+				ReferrerPolicy: pulumi.String(headers.referrerPolicy()),
+				Override:       pulumi.Bool(true),
+			},
+			StrictTransportSecurity: &cloudfront.ResponseHeadersPolicySecurityHeadersConfigStrictTransportSecurityArgs{
+				AccessControlMaxAgeSec: pulumi.Int(headers.hstsMaxAge()),
+				IncludeSubdomains:      pulumi.Bool(headers != nil && headers.HstsIncludeSubdomains),
+				Preload:                pulumi.Bool(headers != nil && headers.HstsPreload),
+				Override:               pulumi.Bool(true),
+			},
+		},
+		CustomHeadersConfig: &cloudfront.ResponseHeadersPolicyCustomHeadersConfigArgs{
+			Items: cloudfront.ResponseHeadersPolicyCustomHeadersConfigItemArray{
+				&cloudfront.ResponseHeadersPolicyCustomHeadersConfigItemArgs{
+					Header:   pulumi.String("Permissions-Policy"),
+					Value:    pulumi.String(headers.permissionsPolicy()),
+					Override: pulumi.Bool(true),
+				},
+			},
+		},
+	})
+	if err != nil {
+		return nil, resourceErr(policyName, err)
+	}
+	return policy, nil
+}
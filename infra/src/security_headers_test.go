@@ -0,0 +1,156 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestContentSecurityPolicy(t *testing.T) {
+	project := testProject("example.com")
+	policy, err := contentSecurityPolicy(project, "", nil)
+	want := "default-src 'self'; script-src 'self'; style-src 'self'; img-src 'self' data:; font-src 'self'; connect-src 'self'; media-src 'self'; frame-src 'none'; object-src 'none'; base-uri 'self'; form-action 'self'; frame-ancestors 'none'; upgrade-insecure-requests"
+	if err != nil || policy != want {
+		t.Errorf("default policy = %s, %v", policy, err)
+	}
+
+	project.securityHeaders = &securityHeaders{
+		CspPresets:  []string{"google-maps", "swiper"},
+		Csp:         map[string][]string{"script-src": {"https://code.jquery.com"}, "style-src": {"'unsafe-inline'"}},
+		FrameOption: "SAMEORIGIN",
+	}
+	project.contactForm = &contactForm{Captcha: &formCaptcha{Provider: "turnstile"}}
+	policy, err = contentSecurityPolicy(project, "eu-central-1", []string{"'sha256-abc='"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, directive := range []string{
+		"script-src 'self' https://code.jquery.com https://challenges.cloudflare.com 'sha256-abc='",
+		"style-src 'self' 'unsafe-inline';",
+		"frame-src https://www.google.com https://challenges.cloudflare.com;",
+		"connect-src 'self' https://*.execute-api.eu-central-1.amazonaws.com;",
+		"frame-ancestors 'self';",
+	} {
+		if !strings.Contains(policy, directive) {
+			t.Errorf("policy %s\ndoes not contain %s", policy, directive)
+		}
+	}
+
+	if _, err := contentSecurityPolicy(project, "", nil); err == nil {
+		t.Error("form endpoint allowed without region")
+	}
+	project.securityHeaders.Csp["script-src"] = []string{"'unsafe-inline'"}
+	if policy, _ := contentSecurityPolicy(project, "eu-central-1", []string{"'sha256-abc='"}); strings.Contains(policy, "sha256") {
+		t.Errorf("hashes would disable 'unsafe-inline': %s", policy)
+	}
+	project.securityHeaders.Csp["img-src"] = []string{strings.Repeat("https://a.example.com/", 100)}
+	if _, err := contentSecurityPolicy(project, "eu-central-1", nil); err == nil || !strings.Contains(err.Error(), "at most") {
+		t.Errorf("too long policy = %v", err)
+	}
+}
+
+func TestInlineScriptHashes(t *testing.T) {
+	dir := t.TempDir()
+	script := "\n  gtag('config', 'G-TEST');\n"
+	documents := map[string]string{
+		"index.html":     "<script async src=\"https://www.googletagmanager.com/gtag/js\"></script><script>" + script + "</script>",
+		"sub/about.html": "<SCRIPT type=\"text/javascript\">" + script + "</SCRIPT><script> </script>",
+		"main.js":        "<script>ignored()</script>",
+	}
+	for name, content := range documents {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	hashes, err := inlineScriptHashes(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte(script))
+	want := "'sha256-" + base64.StdEncoding.EncodeToString(sum[:]) + "'"
+	if len(hashes) != 1 || hashes[0] != want {
+		t.Errorf("hashes = %v, want [%s]", hashes, want)
+	}
+}
+
+func TestSecurityHeadersValidate(t *testing.T) {
+	valid := securityHeaders{
+		CspPresets: []string{"glightbox", "google-fonts"},
+		Csp: map[string][]string{
+			"script-src": {"https://code.jquery.com", "'sha256-AbC+/12='"},
+			"img-src":    {"https://*.example.com", "blob:"},
+		},
+		HstsIncludeSubdomains: true,
+		HstsPreload:           true,
+		ReferrerPolicy:        "no-referrer",
+		FrameOption:           "SAMEORIGIN",
+	}
+	if err := valid.validate(); err != nil {
+		t.Fatalf("valid headers rejected: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		edit func(headers *securityHeaders)
+		err  string
+	}{
+		{"preset", func(headers *securityHeaders) { headers.CspPresets = []string{"jquery"} }, "unknown csp preset"},
+		{"directive", func(headers *securityHeaders) { headers.Csp = map[string][]string{"script": {"'self'"}} }, "unknown csp directive"},
+		{"keyword", func(headers *securityHeaders) { headers.Csp = map[string][]string{"script-src": {"'inline'"}} }, "invalid csp source"},
+		{"injection", func(headers *securityHeaders) {
+			headers.Csp = map[string][]string{"script-src": {"https://example.com; script-src *"}}
+		}, "invalid csp source"},
+		{"preload", func(headers *securityHeaders) { headers.HstsIncludeSubdomains = false }, "hsts-preload requires"},
+		{"referrer", func(headers *securityHeaders) { headers.ReferrerPolicy = "never" }, "invalid referrer-policy"},
+		{"frame", func(headers *securityHeaders) { headers.FrameOption = "ALLOW" }, "invalid frame-option"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			headers := valid
+			test.edit(&headers)
+			if err := headers.validate(); err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("validate() = %v, want error containing %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestDeployProjectSecurityHeaders(t *testing.T) {
+	mocks, err := runWithMocks(t, "test", nil, deployTestProject(testProject("example.com", "www.example.com")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policy := mocks.find(t, "aws:cloudfront/responseHeadersPolicy:ResponseHeadersPolicy", "test-site-security-headers")
+	security := policy.Inputs["securityHeadersConfig"].(map[string]interface{})
+	hsts := security["strictTransportSecurity"].(map[string]interface{})
+	if hsts["accessControlMaxAgeSec"] != float64(defaultHstsMaxAge) || hsts["override"] != true {
+		t.Errorf("hsts = %v", hsts)
+	}
+	if frame := security["frameOptions"].(map[string]interface{}); frame["frameOption"] != "DENY" {
+		t.Errorf("frame options = %v", frame)
+	}
+	for _, header := range []string{"contentSecurityPolicy", "contentTypeOptions", "referrerPolicy"} {
+		if _, ok := security[header]; !ok {
+			t.Errorf("policy has no %s", header)
+		}
+	}
+
+	// Both the default and the ordered behaviors send the headers
+	distribution := mocks.find(t, "aws:cloudfront/distribution:Distribution", "example.com-cdn")
+	behaviors := []interface{}{distribution.Inputs["defaultCacheBehavior"]}
+	behaviors = append(behaviors, distribution.Inputs["orderedCacheBehaviors"].([]interface{})...)
+	for i, behavior := range behaviors {
+		if id := behavior.(map[string]interface{})["responseHeadersPolicyId"]; id != "test-site-security-headers-id" {
+			t.Errorf("behavior %d response headers policy = %v", i, id)
+		}
+	}
+}
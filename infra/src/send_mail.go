@@ -1,57 +1,174 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"log"
+	"strings"
 
 	apigateway "github.com/pulumi/pulumi-aws-apigateway/sdk/go/apigateway"
-	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/lambda"
 	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ses"
 	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
 )
 
-func emailFormApiGateway(ctx *pulumi.Context, lambda_mail *lambda.Function, lambda_cors *lambda.Function) *apigateway.RestAPI {
-	postMethod := apigateway.MethodPOST
-	optionMethod := apigateway.MethodOPTIONS
+func emailFormApiGateway(ctx *pulumi.Context, routes []apigateway.RouteArgs) (*apigateway.RestAPI, error) {
 	restAPI, err := apigateway.NewRestAPI(ctx, "email-form", &apigateway.RestAPIArgs{
 		StageName: pulumi.String("prod"),
-		Routes: []apigateway.RouteArgs{
-			{
-				Path:         "/",
-				Method:       &postMethod,
-				EventHandler: lambda_mail,
-			},
-			{
-				Path:         "/",
-				Method:       &optionMethod,
-				EventHandler: lambda_cors,
-			},
-		},
+		Routes:    routes,
 	})
-	handleErr(err)
-	return restAPI
+	if err != nil {
+		return nil, resourceErr("email-form", err)
+	}
+	return restAPI, nil
+}
+
+// formTemplates are the names of the SES templates of a site form
+type formTemplates struct {
+	notification string
+	// Empty when the site sends no auto-reply
+	autoReply string
+}
+
+func contactFormTemplates(ctx *pulumi.Context, project staticSiteProject, domains []siteDomain) (formTemplates, error) {
+	// Templates are rendered with the branding of the site, SES fills in the submission
+	var names formTemplates
+	branding, err := project.contactForm.branding(domains)
+	if err != nil {
+		return names, err
+	}
+	templates, err := renderEmailTemplates(branding)
+	if err != nil {
+		return names, err
+	}
+	for _, tmpl := range templates {
+		if tmpl.kind == autoReplyTemplate && !project.contactForm.AutoReply {
+			continue
+		}
+		name := fmt.Sprintf("%s-contact-form-%s", project.name, tmpl.kind)
+		log.Printf("emailForm - Setting AWS SES template %s\n", name)
+		_, err := ses.NewTemplate(ctx, name, &ses.TemplateArgs{
+			Name:    pulumi.String(name),
+			Subject: pulumi.String(tmpl.subject),
+			Html:    pulumi.String(tmpl.html),
+			Text:    pulumi.String(tmpl.text),
+		})
+		if err != nil {
+			return names, resourceErr(name, err)
+		}
+		if tmpl.kind == autoReplyTemplate {
+			names.autoReply = name
+		} else {
+			names.notification = name
+		}
+	}
+	return names, nil
+}
+
+func contactFormPath(project staticSiteProject) string {
+	// Every site posts its form to its own path of the shared API
+	return fmt.Sprintf("/%s", project.name)
 }
 
-func simpleMailService(ctx *pulumi.Context, emailDomain string) {
+func contactFormRoutes(ctx *pulumi.Context, project staticSiteProject, backend emailFormBackend) ([]apigateway.RouteArgs, error) {
+	// Creates the form lambda of the site and returns its API routes, API
+	// Gateway answers the CORS preflight itself
+	log.Printf("emailForm - Setting lambda functions of %s\n", project.name)
+	domains, err := getSiteDomains(project)
+	if err != nil {
+		return nil, err
+	}
+	origins, err := project.contactForm.allowedOrigins(domains)
+	if err != nil {
+		return nil, err
+	}
+
+	templates, err := contactFormTemplates(ctx, project, domains)
+	if err != nil {
+		return nil, err
+	}
+
+	lambdaEmailForm, err := lambdaEmailForm(ctx, project, origins, templates, backend)
+	if err != nil {
+		return nil, err
+	}
+
+	postMethod := apigateway.MethodPOST
+	return []apigateway.RouteArgs{
+		{
+			Path:         contactFormPath(project),
+			Method:       &postMethod,
+			EventHandler: lambdaEmailForm,
+		},
+		preflightRoute(project, origins),
+	}, nil
+}
+
+func simpleMailService(ctx *pulumi.Context, sites []staticSiteProject) deployErrors {
+	// Contact forms of all sites are served by one API, failures of a site
+	// are reported under the site and its route is left out
 	log.Println("emailForm - Setting AWS SES")
+	var errs deployErrors
+	hasForms := false
+	for _, site := range sites {
+		hasForms = hasForms || site.contactForm != nil
+	}
+	if !hasForms {
+		return errs
+	}
+	// Forms cannot work without the shared resources, so none is deployed
+	backend, err := newEmailFormBackend(ctx, sites)
+	if err != nil {
+		errs.add("email-form", err)
+		return errs
+	}
 
-	log.Println("emailForm - Setting AWS SES email identities")
-	sesIdentity(ctx, emailDomain)
+	identities := make(map[string]bool)
+	routes := make([]apigateway.RouteArgs, 0)
+	formSites := make([]staticSiteProject, 0)
+	for _, site := range sites {
+		if site.contactForm == nil {
+			continue
+		}
 
-	log.Println("emailForm - Setting lambda functions")
-	lambdaEmailForm := lambdaEmailForm(ctx)
-	lambdaCors := lambdaEmailFormCors(ctx)
+		// Sites sending from the same domain share the SES identity
+		var identityErr error
+		if domain := site.contactForm.senderDomain(); !identities[domain] {
+			identities[domain] = true
+			log.Printf("emailForm - Setting AWS SES email identity %s\n", domain)
+			identityErr = sesIdentity(ctx, site)
+		}
+		siteRoutes, routesErr := contactFormRoutes(ctx, site, backend)
+		if err := errors.Join(identityErr, routesErr); err != nil {
+			errs.add(site.name, err)
+			continue
+		}
+		routes = append(routes, siteRoutes...)
+		formSites = append(formSites, site)
+	}
+	if len(routes) == 0 {
+		return errs
+	}
 
 	log.Println("emailForm - API Gateway settings")
-	restApi := emailFormApiGateway(ctx, lambdaEmailForm, lambdaCors)
+	restApi, err := emailFormApiGateway(ctx, routes)
+	if err != nil {
+		errs.add("email-form", err)
+		return errs
+	}
 
-	log.Println("emailForm - Setting AWS SES complete")
-	ctx.Export("email_form_url", &restApi.Url)
-}
+	for _, site := range formSites {
+		if err := formThrottle(ctx, restApi.Stage.RestApi(), restApi.Stage.StageName(), site); err != nil {
+			errs.add(site.name, err)
+		}
+	}
 
-func sesIdentity(ctx *pulumi.Context, emailDomain string) {
-	_, err := ses.NewDomainIdentity(ctx, emailDomain, &ses.DomainIdentityArgs{
-		Domain: pulumi.String(emailDomain),
-	})
-	handleErr(err)
-	// TODO - verify domain (after migration domain to AWS)
+	log.Println("emailForm - Setting AWS SES complete")
+	ctx.Export("email_form_url", restApi.Url)
+	for _, site := range formSites {
+		path := contactFormPath(site)
+		ctx.Export(fmt.Sprintf("%s-contactFormUrl", site.name), restApi.Url.ApplyT(func(url string) string {
+			return strings.TrimSuffix(url, "/") + path
+		}).(pulumi.StringOutput))
+	}
+	return errs
 }
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+func testFormProject(name string, domain string) staticSiteProject {
+	project := testProject(domain, "www."+domain)
+	project.name = name
+	project.contactForm = &contactForm{
+		Recipients: []string{"orders@" + domain},
+		Sender:     "form@" + domain,
+	}
+	return project
+}
+
+func TestSimpleMailService(t *testing.T) {
+	mocks, err := runWithMocks(t, "test", nil, func(ctx *pulumi.Context) error {
+		return simpleMailService(ctx, []staticSiteProject{
+			testFormProject("shop", "example.com"),
+			testProject("example.org"),
+			testFormProject("blog", "example.net"),
+		}).errOrNil()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := names(mocks.byType("aws:ses/domainIdentity:DomainIdentity")); got != "example.com,example.net" {
+		t.Errorf("ses identities = %s", got)
+	}
+	want := "blog-contact-form,email-form-mail-sender,shop-contact-form"
+	if got := names(mocks.byType("aws:lambda/function:Function")); got != want {
+		t.Errorf("lambda functions = %s, want %s", got, want)
+	}
+
+	api := mocks.find(t, "aws-apigateway:index:RestAPI", "email-form")
+	routes := api.Inputs["routes"].([]interface{})
+	if len(routes) != 4 {
+		t.Fatalf("expected POST and OPTIONS routes of both sites, got %v", routes)
+	}
+	for i, want := range []string{"POST /shop", "OPTIONS /shop", "POST /blog", "OPTIONS /blog"} {
+		route := routes[i].(map[string]interface{})
+		if got := route["method"].(string) + " " + route["path"].(string); got != want {
+			t.Errorf("route %d = %s, want %s", i, got, want)
+		}
+		if _, native := route["data"]; native != strings.HasPrefix(want, "OPTIONS") {
+			t.Errorf("route %s answered by API Gateway = %v", want, native)
+		}
+	}
+}
+
+func TestSimpleMailServiceEnvironment(t *testing.T) {
+	built := make([]string, 0)
+	previous := buildHandler
+	buildHandler = func(pkg string, outDir string) error {
+		built = append(built, pkg+" -> "+outDir)
+		return nil
+	}
+	t.Cleanup(func() { buildHandler = previous })
+
+	project := testFormProject("shop", "example.com")
+	project.contactForm.Recipients = append(project.contactForm.Recipients, "owner@example.com")
+	project.contactForm.Subject = "Order from {{.Name}}"
+	mocks, err := runWithMocks(t, "test", nil, func(ctx *pulumi.Context) error {
+		return simpleMailService(ctx, []staticSiteProject{project}).errOrNil()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Join(built, ","), "./lambda/mailqueue -> ../dist/email-form-mail-sender,./lambda/sendmail -> ../dist/shop-contact-form"; got != want {
+		t.Errorf("built handlers = %s, want %s", got, want)
+	}
+
+	mail := mocks.find(t, "aws:lambda/function:Function", "shop-contact-form")
+	if mail.Inputs["runtime"] != goHandlerRuntime || mail.Inputs["handler"] != "bootstrap" {
+		t.Errorf("contact form runtime = %v, handler = %v", mail.Inputs["runtime"], mail.Inputs["handler"])
+	}
+	variables := mail.Inputs["environment"].(map[string]interface{})["variables"].(map[string]interface{})
+	expected := map[string]string{
+		"MAIL_FROM":         "form@example.com",
+		"MAIL_TO":           "orders@example.com,owner@example.com",
+		"SUBJECT_TEMPLATE":  "Order from {{.Name}}",
+		"ALLOW_ORIGINS":     "https://example.com,https://www.example.com",
+		"SITE":              "shop",
+		"SUBMISSIONS_TABLE": submissionsTableName,
+	}
+	for key, value := range expected {
+		if variables[key] != value {
+			t.Errorf("environment %s = %v, want %s", key, variables[key], value)
+		}
+	}
+}
+
+func TestSimpleMailServiceReportsFailedSite(t *testing.T) {
+	previous := buildHandler
+	buildHandler = func(pkg string, outDir string) error {
+		if strings.Contains(outDir, "shop") {
+			return errors.New("compile error")
+		}
+		return nil
+	}
+	t.Cleanup(func() { buildHandler = previous })
+
+	var errs deployErrors
+	mocks, err := runWithMocks(t, "test", nil, func(ctx *pulumi.Context) error {
+		errs = simpleMailService(ctx, []staticSiteProject{
+			testFormProject("shop", "example.com"),
+			testFormProject("blog", "example.net"),
+		})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) != 1 || errs[0].project != "shop" || errs[0].resource != "shop-contact-form" {
+		t.Fatalf("expected the shop handler to fail, got %v", errs)
+	}
+	api := mocks.find(t, "aws-apigateway:index:RestAPI", "email-form")
+	if routes := api.Inputs["routes"].([]interface{}); len(routes) != 2 {
+		t.Errorf("expected only routes of the blog, got %v", routes)
+	}
+}
+
+func TestSimpleMailServiceWithoutForms(t *testing.T) {
+	mocks, err := runWithMocks(t, "test", nil, func(ctx *pulumi.Context) error {
+		return simpleMailService(ctx, []staticSiteProject{testProject("example.com")}).errOrNil()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if apis := mocks.byType("aws-apigateway:index:RestAPI"); len(apis) != 0 {
+		t.Errorf("expected no API without contact forms, got %s", names(apis))
+	}
+}
+
+func TestSimpleMailServiceBackend(t *testing.T) {
+	mocks, err := runWithMocks(t, "test", nil, func(ctx *pulumi.Context) error {
+		return simpleMailService(ctx, []staticSiteProject{
+			testFormProject("shop", "example.com"),
+			testFormProject("blog", "example.net"),
+		}).errOrNil()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := names(mocks.byType("aws:dynamodb/table:Table")); got != "email-form-rate-limits,email-form-submissions" {
+		t.Errorf("tables = %s", got)
+	}
+	table := mocks.find(t, "aws:dynamodb/table:Table", submissionsTableName)
+	if table.Inputs["hashKey"] != "site" || table.Inputs["rangeKey"] != "id" {
+		t.Errorf("submissions table keys = %v, %v", table.Inputs["hashKey"], table.Inputs["rangeKey"])
+	}
+
+	queue := mocks.find(t, "aws:sqs/queue:Queue", mailQueueName)
+	if queue.Inputs["visibilityTimeoutSeconds"] != float64(mailQueueVisibilityTimeout) {
+		t.Errorf("queue visibility timeout = %v", queue.Inputs["visibilityTimeoutSeconds"])
+	}
+	want := `{"deadLetterTargetArn":"arn:aws:mock:::email-form-mail-dlq","maxReceiveCount":6}`
+	if queue.Inputs["redrivePolicy"] != want {
+		t.Errorf("redrive policy = %v, want %s", queue.Inputs["redrivePolicy"], want)
+	}
+	alarm := mocks.find(t, "aws:cloudwatch/metricAlarm:MetricAlarm", "email-form-mail-dlq-alarm")
+	if dimensions := alarm.Inputs["dimensions"].(map[string]interface{}); dimensions["QueueName"] != mailDeadLetterQueueName {
+		t.Errorf("alarm dimensions = %v", dimensions)
+	}
+	if actions := inputStrings(t, alarm, "alarmActions"); len(actions) != 1 || actions[0] != "arn:aws:mock:::email-form-alerts" {
+		t.Errorf("alarm actions = %v", actions)
+	}
+	subscriptions := mocks.byType("aws:sns/topicSubscription:TopicSubscription")
+	if got := names(subscriptions); got != "email-form-alerts-orders@example.com,email-form-alerts-orders@example.net" {
+		t.Errorf("alert subscriptions = %s", got)
+	}
+	sender := mocks.find(t, "aws:lambda/function:Function", "email-form-mail-sender")
+	variables := sender.Inputs["environment"].(map[string]interface{})["variables"].(map[string]interface{})
+	if variables["MAX_RECEIVE_COUNT"] != "6" || variables["MAIL_QUEUE_URL"] != "https://sqs.eu-central-1.amazonaws.com/123456789012/email-form-mail" {
+		t.Errorf("mail sender environment = %v", variables)
+	}
+	mapping := mocks.find(t, "aws:lambda/eventSourceMapping:EventSourceMapping", "email-form-mail-sender-queue")
+	if mapping.Inputs["functionName"] != "arn:aws:mock:::email-form-mail-sender" {
+		t.Errorf("queue mapped to %v", mapping.Inputs["functionName"])
+	}
+	if got := inputStrings(t, mapping, "functionResponseTypes"); len(got) != 1 || got[0] != "ReportBatchItemFailures" {
+		t.Errorf("function response types = %v", got)
+	}
+
+	// Every form lambda queues its failed mails to the shared queue
+	for _, name := range []string{"shop-contact-form", "blog-contact-form"} {
+		mail := mocks.find(t, "aws:lambda/function:Function", name)
+		variables := mail.Inputs["environment"].(map[string]interface{})["variables"].(map[string]interface{})
+		if variables["MAIL_QUEUE_URL"] == nil || variables["MAIL_QUEUE_URL"] == "" {
+			t.Errorf("%s has no mail queue", name)
+		}
+	}
+}
+
+func TestAlertRecipients(t *testing.T) {
+	shop := testFormProject("shop", "example.com")
+	shop.contactForm.Recipients = []string{"Owner <owner@example.com>", "orders@example.com"}
+	blog := testFormProject("blog", "example.com")
+	got := alertRecipients([]staticSiteProject{shop, blog, testProject("example.org")})
+	if strings.Join(got, ",") != "orders@example.com,owner@example.com" {
+		t.Errorf("alert recipients = %v", got)
+	}
+}
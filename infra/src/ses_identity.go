@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/route53"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ses"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+)
+
+const (
+	// Easy DKIM always issues three signing tokens
+	dkimTokenCount = 3
+	// TTL of the mail DNS records
+	mailRecordTtl = 600
+	// DMARC policy of sites without their own, reports nothing and rejects nothing
+	defaultDmarcPolicy = "v=DMARC1; p=none;"
+)
+
+func senderZone(project staticSiteProject, senderDomain string) (siteDomain, error) {
+	// The sender domain uses the hosted zone of the site when it is part of it,
+	// otherwise the zone of its registrable domain
+	zone := project.hostedZone
+	if zone != "" && senderDomain != normalizeHost(zone) && !strings.HasSuffix(senderDomain, "."+normalizeHost(zone)) {
+		zone = ""
+	}
+	return parseDomain(senderDomain, zone)
+}
+
+func mailRecord(ctx *pulumi.Context, name string, zoneId string, recordType string, recordName pulumi.StringInput, value pulumi.StringInput) error {
+	_, err := route53.NewRecord(ctx, name, &route53.RecordArgs{
+		ZoneId:  pulumi.String(zoneId),
+		Name:    recordName,
+		Type:    pulumi.String(recordType),
+		Ttl:     pulumi.Int(mailRecordTtl),
+		Records: pulumi.StringArray{value},
+	})
+	return resourceErr(name, err)
+}
+
+func sesIdentity(ctx *pulumi.Context, project staticSiteProject) error {
+	// Creates the SES identity of the contact form sender together with its
+	// verification, Easy DKIM, custom MAIL FROM and DMARC records. The deploy
+	// waits until SES verifies the domain
+	form := project.contactForm
+	domain, err := senderZone(project, form.senderDomain())
+	if err != nil {
+		return err
+	}
+	zoneId, err := getRoute53HostedZone(ctx, domain.zone)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("emailForm - Setting AWS SES identity %s in zone %s\n", domain.host, domain.zone)
+	identity, err := ses.NewDomainIdentity(ctx, domain.host, &ses.DomainIdentityArgs{
+		Domain: pulumi.String(domain.host),
+	})
+	if err != nil {
+		return resourceErr(domain.host, err)
+	}
+
+	verificationRecordName := fmt.Sprintf("%s-ses-verification", domain.host)
+	verificationRecord, err := route53.NewRecord(ctx, verificationRecordName, &route53.RecordArgs{
+		ZoneId:  pulumi.String(zoneId),
+		Name:    pulumi.String(fmt.Sprintf("_amazonses.%s", domain.host)),
+		Type:    pulumi.String("TXT"),
+		Ttl:     pulumi.Int(mailRecordTtl),
+		Records: pulumi.StringArray{identity.VerificationToken},
+	})
+	if err != nil {
+		return resourceErr(verificationRecordName, err)
+	}
+	verificationName := fmt.Sprintf("%s-verification", domain.host)
+	verification, err := ses.NewDomainIdentityVerification(ctx, verificationName, &ses.DomainIdentityVerificationArgs{
+		Domain: identity.ID(),
+	}, pulumi.DependsOn([]pulumi.Resource{verificationRecord}))
+	if err != nil {
+		return resourceErr(verificationName, err)
+	}
+	ctx.Export(fmt.Sprintf("%s-sesIdentityStatus", domain.host), verification.Arn.ApplyT(func(_ string) string {
+		return "verified"
+	}).(pulumi.StringOutput))
+
+	// Records below are independent, so all of their failures are reported
+	errs := []error{
+		sesDkimRecords(ctx, domain, zoneId, identity),
+		sesMailFrom(ctx, domain, zoneId, form, identity),
+		mailRecord(ctx, fmt.Sprintf("%s-dmarc", domain.host), zoneId, "TXT",
+			pulumi.String(fmt.Sprintf("_dmarc.%s", domain.host)), pulumi.String(form.dmarc())),
+	}
+	return errors.Join(errs...)
+}
+
+func sesDkimRecords(ctx *pulumi.Context, domain siteDomain, zoneId string, identity *ses.DomainIdentity) error {
+	// Publishes the Easy DKIM signing keys as CNAMEs to the keys hosted by SES
+	dkimName := fmt.Sprintf("%s-dkim", domain.host)
+	dkim, err := ses.NewDomainDkim(ctx, dkimName, &ses.DomainDkimArgs{
+		Domain: identity.Domain,
+	})
+	if err != nil {
+		return resourceErr(dkimName, err)
+	}
+	for i := 0; i < dkimTokenCount; i++ {
+		token := dkim.DkimTokens.Index(pulumi.Int(i))
+		err := mailRecord(ctx, fmt.Sprintf("%s-dkim-%d", domain.host, i), zoneId, "CNAME",
+			pulumi.Sprintf("%s._domainkey.%s", token, domain.host),
+			pulumi.Sprintf("%s.dkim.amazonses.com", token))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sesMailFrom(ctx *pulumi.Context, domain siteDomain, zoneId string, form *contactForm, identity *ses.DomainIdentity) error {
+	// Bounces go to the MAIL FROM subdomain, so SPF aligns with the sender domain
+	mailFromDomain := form.mailFrom()
+	mailFromName := fmt.Sprintf("%s-mail-from", domain.host)
+	_, err := ses.NewMailFrom(ctx, mailFromName, &ses.MailFromArgs{
+		Domain:              identity.Domain,
+		MailFromDomain:      pulumi.String(mailFromDomain),
+		BehaviorOnMxFailure: pulumi.String("UseDefaultValue"),
+	})
+	if err != nil {
+		return resourceErr(mailFromName, err)
+	}
+
+	region := config.Get(ctx, "aws:region")
+	return errors.Join(
+		mailRecord(ctx, fmt.Sprintf("%s-mail-from-mx", domain.host), zoneId, "MX",
+			pulumi.String(mailFromDomain), pulumi.String(fmt.Sprintf("10 feedback-smtp.%s.amazonses.com", region))),
+		mailRecord(ctx, fmt.Sprintf("%s-mail-from-spf", domain.host), zoneId, "TXT",
+			pulumi.String(mailFromDomain), pulumi.String("v=spf1 include:amazonses.com ~all")),
+	)
+}
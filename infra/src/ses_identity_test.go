@@ -0,0 +1,84 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+func TestSesIdentity(t *testing.T) {
+	project := testFormProject("shop", "example.co.uk")
+	mocks, err := runWithMocks(t, "test", map[string]string{"aws:region": "eu-central-1"}, func(ctx *pulumi.Context) error {
+		return sesIdentity(ctx, project)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mocks.find(t, "aws:ses/domainIdentity:DomainIdentity", "example.co.uk")
+	verification := mocks.find(t, "aws:ses/domainIdentityVerification:DomainIdentityVerification", "example.co.uk-verification")
+	if verification.Inputs["domain"] != "example.co.uk-id" {
+		t.Errorf("verification domain = %v", verification.Inputs["domain"])
+	}
+	mailFrom := mocks.find(t, "aws:ses/mailFrom:MailFrom", "example.co.uk-mail-from")
+	if mailFrom.Inputs["mailFromDomain"] != "mail.example.co.uk" {
+		t.Errorf("mail from domain = %v", mailFrom.Inputs["mailFromDomain"])
+	}
+
+	tests := []struct {
+		name       string
+		recordType string
+		recordName string
+		value      string
+	}{
+		{"example.co.uk-ses-verification", "TXT", "_amazonses.example.co.uk", "token-example.co.uk"},
+		{"example.co.uk-dkim-0", "CNAME", "dkim1._domainkey.example.co.uk", "dkim1.dkim.amazonses.com"},
+		{"example.co.uk-dkim-2", "CNAME", "dkim3._domainkey.example.co.uk", "dkim3.dkim.amazonses.com"},
+		{"example.co.uk-mail-from-mx", "MX", "mail.example.co.uk", "10 feedback-smtp.eu-central-1.amazonses.com"},
+		{"example.co.uk-mail-from-spf", "TXT", "mail.example.co.uk", "v=spf1 include:amazonses.com ~all"},
+		{"example.co.uk-dmarc", "TXT", "_dmarc.example.co.uk", defaultDmarcPolicy},
+	}
+	for _, test := range tests {
+		record := mocks.find(t, "aws:route53/record:Record", test.name)
+		if record.Inputs["type"] != test.recordType || record.Inputs["name"] != test.recordName || record.Inputs["zoneId"] != "zone-example.co.uk" {
+			t.Errorf("record %s = %v", test.name, record.Inputs)
+		}
+		if values := inputStrings(t, record, "records"); len(values) != 1 || values[0] != test.value {
+			t.Errorf("record %s values = %v, want %s", test.name, values, test.value)
+		}
+	}
+}
+
+func TestSesIdentityUsesSiteHostedZone(t *testing.T) {
+	project := testFormProject("shop", "shop.example.com")
+	project.hostedZone = "shop.example.com"
+	project.contactForm.MailFrom = "bounces.shop.example.com"
+	project.contactForm.Dmarc = "v=DMARC1; p=reject;"
+	mocks, err := runWithMocks(t, "test", nil, func(ctx *pulumi.Context) error {
+		return sesIdentity(ctx, project)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dmarc := mocks.find(t, "aws:route53/record:Record", "shop.example.com-dmarc")
+	if dmarc.Inputs["zoneId"] != "zone-shop.example.com" {
+		t.Errorf("dmarc record zone = %v", dmarc.Inputs["zoneId"])
+	}
+	if values := inputStrings(t, dmarc, "records"); values[0] != "v=DMARC1; p=reject;" {
+		t.Errorf("dmarc record = %v", values)
+	}
+	spf := mocks.find(t, "aws:route53/record:Record", "shop.example.com-mail-from-spf")
+	if spf.Inputs["name"] != "bounces.shop.example.com" {
+		t.Errorf("spf record name = %v", spf.Inputs["name"])
+	}
+}
+
+func TestSenderZone(t *testing.T) {
+	project := testProject("shop.example.com")
+	project.hostedZone = "shop.example.com"
+	domain, err := senderZone(project, "example.com")
+	if err != nil || domain.zone != "example.com" {
+		t.Errorf("sender outside the site zone = %+v, %v, want zone example.com", domain, err)
+	}
+}
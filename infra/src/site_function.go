@@ -0,0 +1,291 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/pulumi/pulumi-archive/sdk/go/archive"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/cloudwatch"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/lambda"
+	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws/iam"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+const (
+	// Lambda@Edge functions must be deployed where the Cloudfront control plane resides
+	edgeRegion = "us-east-1"
+	// Days the function logs are kept
+	functionLogRetention = 14
+)
+
+// siteFunctionArgs describes a lambda function, the component creates its
+// role, policies, log group and code archive
+type siteFunctionArgs struct {
+	source      string // Go handler package for provided.al2023, a single file otherwise
+	handler     string // defaults to the bootstrap executable of Go handlers
+	runtime     string
+	region      string // deploys through a dedicated provider when set
+	edge        bool   // Lambda@Edge function, published and assumable by edgelambda
+	memorySize  int
+	environment map[string]string
+	outputs     map[string]pulumi.StringInput    // environment variables known during the deploy, like secrets and URLs
+	statements  []iam.GetPolicyDocumentStatement // permissions besides logging
+	legacyName  string                           // name of the deployed function before it was a component, it is kept
+	// Name of the regional provider of the deployed function before it was a component
+	legacyProvider string
+}
+
+// siteFunction is a lambda function with its supporting resources
+type siteFunction struct {
+	pulumi.ResourceState
+
+	Arn          pulumi.StringOutput `pulumi:"arn"`
+	QualifiedArn pulumi.StringOutput `pulumi:"qualifiedArn"`
+
+	function *lambda.Function
+}
+
+func (args siteFunctionArgs) validate() error {
+	if args.source == "" || args.runtime == "" {
+		return fmt.Errorf("source and runtime are required")
+	}
+	if args.runtime != goHandlerRuntime && args.handler == "" {
+		return fmt.Errorf("handler is required for runtime %s", args.runtime)
+	}
+	if args.edge {
+		// Lambda@Edge supports neither custom runtimes nor environment variables
+		if args.region != edgeRegion {
+			return fmt.Errorf("edge functions must be deployed in %s", edgeRegion)
+		}
+		if args.runtime == goHandlerRuntime || len(args.environment) > 0 || len(args.outputs) > 0 {
+			return fmt.Errorf("edge functions support neither %s nor environment variables", goHandlerRuntime)
+		}
+	}
+	return nil
+}
+
+func functionArchive(ctx *pulumi.Context, name string, args siteFunctionArgs, parent pulumi.Resource) (string, *archive.LookupFileResult, error) {
+	// Go handlers are built first, other runtimes ship the source file as is
+	if args.runtime == goHandlerRuntime {
+		return goHandlerArchive(ctx, name, args.source, pulumi.Parent(parent))
+	}
+	lambdaArchive := fmt.Sprintf("../dist/%s.zip", name)
+	lookupFile, err := archive.LookupFile(ctx, &archive.LookupFileArgs{
+		Type:       "zip",
+		SourceFile: pulumi.StringRef(args.source),
+		OutputPath: lambdaArchive,
+	}, pulumi.Parent(parent))
+	if err != nil {
+		return "", nil, resourceErr(lambdaArchive, err)
+	}
+	return lambdaArchive, lookupFile, nil
+}
+
+func functionPolicy(ctx *pulumi.Context, name string, args siteFunctionArgs, parent pulumi.Resource) (*iam.GetPolicyDocumentResult, *iam.GetPolicyDocumentResult, error) {
+	// Returns the assume role document and the permissions of the function
+	principals := []string{"lambda.amazonaws.com"}
+	if args.edge {
+		principals = append(principals, "edgelambda.amazonaws.com")
+	}
+	assumeRole, err := iam.GetPolicyDocument(ctx, &iam.GetPolicyDocumentArgs{
+		Statements: []iam.GetPolicyDocumentStatement{
+			{
+				Effect: pulumi.StringRef("Allow"),
+				Principals: []iam.GetPolicyDocumentStatementPrincipal{
+					{Type: "Service", Identifiers: principals},
+				},
+				Actions: []string{"sts:AssumeRole"},
+			},
+		},
+	}, pulumi.Parent(parent))
+	if err != nil {
+		return nil, nil, resourceErr(fmt.Sprintf("%s-assume-role", name), err)
+	}
+
+	// Edge replicas log to the region they run in, so logging is not limited to one group
+	statements := append([]iam.GetPolicyDocumentStatement{
+		{
+			Effect: pulumi.StringRef("Allow"),
+			Actions: []string{
+				"logs:CreateLogGroup",
+				"logs:CreateLogStream",
+				"logs:PutLogEvents",
+			},
+			Resources: []string{"arn:aws:logs:*:*:*"},
+		},
+	}, args.statements...)
+	policy, err := iam.GetPolicyDocument(ctx, &iam.GetPolicyDocumentArgs{Statements: statements}, pulumi.Parent(parent))
+	if err != nil {
+		return nil, nil, resourceErr(fmt.Sprintf("%s-policy-document", name), err)
+	}
+	return assumeRole, policy, nil
+}
+
+func newSiteFunction(ctx *pulumi.Context, name string, args siteFunctionArgs, opts ...pulumi.ResourceOption) (*siteFunction, error) {
+	// Creates the function named name together with its role, inline policy,
+	// log group and archive, all named after the function
+	if err := args.validate(); err != nil {
+		return nil, resourceErr(name, err)
+	}
+	fn := &siteFunction{}
+	if err := ctx.RegisterComponentResource("www-infra:index:SiteFunction", name, fn, opts...); err != nil {
+		return nil, resourceErr(name, err)
+	}
+	// Renaming a deployed function would replace it
+	functionName := name
+	if args.legacyName != "" {
+		functionName = args.legacyName
+	}
+	tags := pulumi.StringMap{
+		"Project":  pulumi.String(ctx.Project()),
+		"Stack":    pulumi.String(ctx.Stack()),
+		"Function": pulumi.String(functionName),
+	}
+
+	childOpts := []pulumi.ResourceOption{pulumi.Parent(fn)}
+	if args.region != "" {
+		providerName := fmt.Sprintf("%s-%s", name, args.region)
+		providerOpts := []pulumi.ResourceOption{pulumi.Parent(fn)}
+		if args.legacyProvider != "" {
+			// Functions of a renamed provider would be replaced
+			providerOpts = append(providerOpts, pulumi.Aliases([]pulumi.Alias{{Name: pulumi.String(args.legacyProvider), NoParent: pulumi.Bool(true)}}))
+		}
+		provider, err := aws.NewProvider(ctx, providerName, &aws.ProviderArgs{
+			Region: pulumi.String(args.region),
+		}, providerOpts...)
+		if err != nil {
+			return nil, resourceErr(providerName, err)
+		}
+		childOpts = append(childOpts, pulumi.Provider(provider))
+	}
+
+	assumeRole, policy, err := functionPolicy(ctx, name, args, fn)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Creating IAM for %s lambda\n", name)
+	roleName := fmt.Sprintf("%s-role", name)
+	role, err := iam.NewRole(ctx, roleName, &iam.RoleArgs{
+		Name:             pulumi.String(roleName),
+		AssumeRolePolicy: pulumi.String(assumeRole.Json),
+		Tags:             tags,
+	}, pulumi.Parent(fn))
+	if err != nil {
+		return nil, resourceErr(roleName, err)
+	}
+	policyName := fmt.Sprintf("%s-policy", name)
+	rolePolicy, err := iam.NewRolePolicy(ctx, policyName, &iam.RolePolicyArgs{
+		Name:   pulumi.String(policyName),
+		Role:   role.Name,
+		Policy: pulumi.String(policy.Json),
+	}, pulumi.Parent(fn))
+	if err != nil {
+		return nil, resourceErr(policyName, err)
+	}
+
+	logGroupName := fmt.Sprintf("%s-logs", name)
+	logGroup, err := cloudwatch.NewLogGroup(ctx, logGroupName, &cloudwatch.LogGroupArgs{
+		Name:            pulumi.String(fmt.Sprintf("/aws/lambda/%s", functionName)),
+		RetentionInDays: pulumi.Int(functionLogRetention),
+		Tags:            tags,
+	}, childOpts...)
+	if err != nil {
+		return nil, resourceErr(logGroupName, err)
+	}
+
+	log.Printf("Archiving %s lambda from %s\n", name, args.source)
+	lambdaArchive, lookupFile, err := functionArchive(ctx, name, args, fn)
+	if err != nil {
+		return nil, err
+	}
+
+	handler := args.handler
+	var architectures pulumi.StringArrayInput
+	if args.runtime == goHandlerRuntime {
+		handler = "bootstrap"
+		architectures = pulumi.StringArray{pulumi.String("arm64")}
+	}
+	var environment lambda.FunctionEnvironmentPtrInput
+	if len(args.environment) > 0 || len(args.outputs) > 0 {
+		variables := pulumi.ToStringMap(args.environment)
+		for key, value := range args.outputs {
+			variables[key] = value
+		}
+		environment = &lambda.FunctionEnvironmentArgs{Variables: variables}
+	}
+	var memorySize pulumi.IntPtrInput
+	if args.memorySize > 0 {
+		memorySize = pulumi.Int(args.memorySize)
+	}
+	// Cloudfront associates a published version of edge functions
+	var publish pulumi.BoolPtrInput
+	if args.edge {
+		publish = pulumi.Bool(true)
+	}
+
+	functionOpts := append(childOpts, pulumi.DependsOn([]pulumi.Resource{rolePolicy, logGroup}))
+	if args.legacyName != "" {
+		functionOpts = append(functionOpts, pulumi.Aliases([]pulumi.Alias{{Name: pulumi.String(args.legacyName), NoParent: pulumi.Bool(true)}}))
+	}
+	if args.edge {
+		// Deleting fails until CloudFront removes the replicas, hours after the
+		// function is detached. Removed edge functions are left to be deleted by hand
+		functionOpts = append(functionOpts, pulumi.RetainOnDelete(true))
+	}
+	log.Printf("Creating %s lambda\n", name)
+	fn.function, err = lambda.NewFunction(ctx, name, &lambda.FunctionArgs{
+		Code:           pulumi.NewFileArchive(lambdaArchive),
+		Name:           pulumi.String(functionName),
+		Role:           role.Arn,
+		Handler:        pulumi.String(handler),
+		SourceCodeHash: pulumi.String(lookupFile.OutputBase64sha256),
+		Runtime:        pulumi.String(args.runtime),
+		Architectures:  architectures,
+		Environment:    environment,
+		MemorySize:     memorySize,
+		Publish:        publish,
+		Tags:           tags,
+	}, functionOpts...)
+	if err != nil {
+		return nil, resourceErr(name, err)
+	}
+
+	fn.Arn = fn.function.Arn
+	fn.QualifiedArn = fn.function.QualifiedArn
+	if err := ctx.RegisterResourceOutputs(fn, pulumi.Map{
+		"arn":          fn.Arn,
+		"qualifiedArn": fn.QualifiedArn,
+	}); err != nil {
+		return nil, resourceErr(name, err)
+	}
+	return fn, nil
+}
+
+func policyStatement(actions ...string) iam.GetPolicyDocumentStatement {
+	// Allows the actions on all resources
+	return iam.GetPolicyDocumentStatement{
+		Effect:    pulumi.StringRef("Allow"),
+		Actions:   actions,
+		Resources: []string{"*"},
+	}
+}
+
+func tableStatement(table string, actions ...string) iam.GetPolicyDocumentStatement {
+	// Allows the actions on the DynamoDB table in any region of the account
+	return iam.GetPolicyDocumentStatement{
+		Effect:    pulumi.StringRef("Allow"),
+		Actions:   actions,
+		Resources: []string{fmt.Sprintf("arn:aws:dynamodb:*:*:table/%s", table)},
+	}
+}
+
+func queueStatement(queue string, actions ...string) iam.GetPolicyDocumentStatement {
+	// Allows the actions on the SQS queue in any region of the account
+	return iam.GetPolicyDocumentStatement{
+		Effect:    pulumi.StringRef("Allow"),
+		Actions:   actions,
+		Resources: []string{fmt.Sprintf("arn:aws:sqs:*:*:%s", queue)},
+	}
+}
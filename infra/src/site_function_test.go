@@ -0,0 +1,95 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws/iam"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+func TestNewSiteFunction(t *testing.T) {
+	mocks, err := runWithMocks(t, "test", nil, func(ctx *pulumi.Context) error {
+		_, err := newSiteFunction(ctx, "lambda-test", siteFunctionArgs{
+			source:      "./lambda/sendmail",
+			runtime:     goHandlerRuntime,
+			environment: map[string]string{"KEY": "value"},
+			statements:  []iam.GetPolicyDocumentStatement{policyStatement("ses:SendEmail")},
+		})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mocks.find(t, "www-infra:index:SiteFunction", "lambda-test")
+	mocks.find(t, "aws:iam/role:Role", "lambda-test-role")
+	mocks.find(t, "aws:iam/rolePolicy:RolePolicy", "lambda-test-policy")
+	logs := mocks.find(t, "aws:cloudwatch/logGroup:LogGroup", "lambda-test-logs")
+	if logs.Inputs["name"] != "/aws/lambda/lambda-test" || logs.Inputs["retentionInDays"] != float64(functionLogRetention) {
+		t.Errorf("log group inputs = %v", logs.Inputs)
+	}
+	if providers := mocks.byType("pulumi:providers:aws"); len(providers) != 0 {
+		t.Errorf("function without region must use the default provider, got %s", names(providers))
+	}
+
+	function := mocks.find(t, "aws:lambda/function:Function", "lambda-test")
+	if function.Inputs["handler"] != "bootstrap" || function.Inputs["runtime"] != goHandlerRuntime {
+		t.Errorf("function handler = %v, runtime = %v", function.Inputs["handler"], function.Inputs["runtime"])
+	}
+	if _, ok := function.Inputs["publish"]; ok {
+		t.Errorf("regional function must not publish versions")
+	}
+	tags := function.Inputs["tags"].(map[string]interface{})
+	if tags["Function"] != "lambda-test" || tags["Stack"] != "test" || tags["Project"] != "www-infra" {
+		t.Errorf("function tags = %v", tags)
+	}
+}
+
+func TestNewSiteFunctionEdge(t *testing.T) {
+	mocks, err := runWithMocks(t, "test", nil, func(ctx *pulumi.Context) error {
+		_, err := lambdaPrecompress(ctx)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	provider := mocks.find(t, "pulumi:providers:aws", "lambda-precompress-us-east-1")
+	if provider.Inputs["region"] != edgeRegion {
+		t.Errorf("provider region = %v", provider.Inputs["region"])
+	}
+	function := mocks.find(t, "aws:lambda/function:Function", "lambda-precompress")
+	if function.Inputs["publish"] != true || function.Inputs["handler"] != "lambda_precompress.handler" {
+		t.Errorf("edge function inputs = %v", function.Inputs)
+	}
+	// The replicated function deployed as lambda-redirect is kept, not replaced
+	if function.Inputs["name"] != "lambda-redirect" || !function.RetainOnDelete {
+		t.Errorf("edge function name = %v, retained on delete %v", function.Inputs["name"], function.RetainOnDelete)
+	}
+	logGroup := mocks.find(t, "aws:cloudwatch/logGroup:LogGroup", "lambda-precompress-logs")
+	if logGroup.Inputs["name"] != "/aws/lambda/lambda-redirect" {
+		t.Errorf("log group name = %v", logGroup.Inputs["name"])
+	}
+}
+
+func TestSiteFunctionArgsValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		args siteFunctionArgs
+		err  string
+	}{
+		{"missing source", siteFunctionArgs{runtime: goHandlerRuntime}, "source and runtime are required"},
+		{"missing handler", siteFunctionArgs{source: "f.mjs", runtime: "nodejs20.x"}, "handler is required"},
+		{"edge region", siteFunctionArgs{source: "f.mjs", handler: "f.handler", runtime: "nodejs20.x", edge: true}, "must be deployed in us-east-1"},
+		{"edge go", siteFunctionArgs{source: "./lambda/sendmail", runtime: goHandlerRuntime, region: edgeRegion, edge: true}, "support neither"},
+		{"edge environment", siteFunctionArgs{source: "f.mjs", handler: "f.handler", runtime: "nodejs20.x", region: edgeRegion, edge: true, environment: map[string]string{"A": "b"}}, "support neither"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.args.validate(); err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("validate() = %v, want error containing %q", err, test.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,10 @@
+// Runs a viewer request function the way the cloudfront-js runtime does: the
+// code defines a global handler called with the event. The code file is the
+// first argument, the event is read from stdin and the result written to stdout
+import { readFileSync } from 'node:fs';
+import vm from 'node:vm';
+
+const context = vm.createContext({});
+vm.runInContext(readFileSync(process.argv[2], 'utf8'), context);
+const event = JSON.parse(readFileSync(0, 'utf8'));
+process.stdout.write(JSON.stringify(context.handler(event)));
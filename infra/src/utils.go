@@ -4,7 +4,6 @@ import (
 	"errors"
 	"fmt"
 	"log"
-	"mime"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -17,46 +16,93 @@ import (
 	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
 )
 
-func handleErr(err error) {
-	if err != nil {
-		panic(err)
-	}
+// objectSettings are applied to every uploaded content object
+type objectSettings struct {
+	acl            string
+	cacheControl   cacheControlRules
+	contentTypes   contentTypes
+	precompressDir string // output directory of pre-compressed variants, empty when disabled
 }
 
-func filesToBucketObjects(ctx *pulumi.Context, accessBlock *s3.BucketPublicAccessBlock, bucket *s3.Bucket, localPath string, bucketPath string) ([]*s3.BucketObject, error) {
+func filesToBucketObjects(ctx *pulumi.Context, accessBlock *s3.BucketPublicAccessBlock, bucket *s3.Bucket, settings objectSettings, localPath string, bucketPath string) ([]*s3.BucketObject, error) {
 	log.Printf("Processing directory content to the buckets %s\n", localPath)
 	files, err := os.ReadDir(localPath)
-	handleErr(err)
+	if err != nil {
+		return nil, fmt.Errorf("reading content directory: %w", err)
+	}
 	buckets := make([]*s3.BucketObject, 0)
 	for _, file := range files {
 		nextDirPath := filepath.Join(localPath, file.Name())
 		nextBucketPath := filepath.Join(bucketPath, file.Name())
 		if file.Type().IsDir() {
-			recBuckets, err := filesToBucketObjects(ctx, accessBlock, bucket, nextDirPath, nextBucketPath)
-			handleErr(err)
+			recBuckets, err := filesToBucketObjects(ctx, accessBlock, bucket, settings, nextDirPath, nextBucketPath)
+			if err != nil {
+				return nil, err
+			}
 			buckets = append(buckets, recBuckets...)
 		} else if file.Type().IsRegular() {
-			bucketObject, err := bucketObjectConverter(ctx, accessBlock, bucket, nextDirPath, nextBucketPath)
-			handleErr(err)
-			buckets = append(buckets, bucketObject)
+			bucketObjects, err := bucketObjectConverter(ctx, accessBlock, bucket, settings, nextDirPath, nextBucketPath)
+			if err != nil {
+				return nil, resourceErr(nextBucketPath, err)
+			}
+			buckets = append(buckets, bucketObjects...)
 		}
 	}
 	return buckets, nil
 }
 
-func bucketObjectConverter(ctx *pulumi.Context, accessBlock *s3.BucketPublicAccessBlock, bucket *s3.Bucket, localPath string, bucketPath string) (*s3.BucketObject, error) {
+func bucketObjectConverter(ctx *pulumi.Context, accessBlock *s3.BucketPublicAccessBlock, bucket *s3.Bucket, settings objectSettings, localPath string, bucketPath string) ([]*s3.BucketObject, error) {
+	// Converts the file to a bucket object and its pre-compressed variants
 	re := regexp.MustCompile("www/[^/]+")
-	mimeType := mime.TypeByExtension(filepath.Ext(localPath))
+	mimeType, _ := settings.contentTypes.contentType(localPath)
 	// Remove wwwDir from the path (as we want to save files directly to the bucket root)
 	dstFilePath := re.ReplaceAllString(bucketPath, "")
-	log.Printf("Converting file %s to bucket object with mime type %s\n", bucketPath, mimeType)
-	return s3.NewBucketObject(ctx, bucketPath, &s3.BucketObjectArgs{
-		Key:         pulumi.String(dstFilePath),
-		Bucket:      bucket.ID(),
-		Acl:         pulumi.String("public-read"),
-		Source:      pulumi.NewFileAsset(localPath),
-		ContentType: pulumi.String(mimeType),
-	}, pulumi.DependsOn([]pulumi.Resource{accessBlock}))
+	cacheControl := settings.cacheControl.cacheControl(dstFilePath)
+	log.Printf("Converting file %s to bucket object with mime type %s, cache control %s\n", bucketPath, mimeType, cacheControl)
+	// Buckets with disabled ACLs reject objects with any ACL set
+	var objectAcl pulumi.StringPtrInput
+	if settings.acl != "" {
+		objectAcl = pulumi.String(settings.acl)
+	}
+	newObject := func(name string, key string, source string, encoding string) (*s3.BucketObject, error) {
+		var contentEncoding pulumi.StringPtrInput
+		if encoding != "" {
+			contentEncoding = pulumi.String(encoding)
+		}
+		// Retained, so switching to manifest sync keeps the content, the
+		// objects of removed files are deleted by the content sync
+		return s3.NewBucketObject(ctx, name, &s3.BucketObjectArgs{
+			Key:             pulumi.String(key),
+			Bucket:          bucket.ID(),
+			Acl:             objectAcl,
+			Source:          pulumi.NewFileAsset(source),
+			ContentType:     pulumi.String(mimeType),
+			ContentEncoding: contentEncoding,
+			CacheControl:    pulumi.String(cacheControl),
+		}, pulumi.DependsOn([]pulumi.Resource{accessBlock}), pulumi.RetainOnDelete(true))
+	}
+
+	object, err := newObject(bucketPath, dstFilePath, localPath, "")
+	if err != nil {
+		return nil, err
+	}
+	objects := []*s3.BucketObject{object}
+	if settings.precompressDir == "" {
+		return objects, nil
+	}
+
+	variants, err := compressFile(localPath, strings.TrimPrefix(dstFilePath, "/"), settings.precompressDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, variant := range variants {
+		object, err := newObject(bucketPath+variant.suffix, dstFilePath+variant.suffix, variant.localPath, variant.encoding)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, object)
+	}
+	return objects, nil
 }
 
 func getRoute53HostedZone(ctx *pulumi.Context, targetDomain string) (string, error) {
@@ -68,36 +114,10 @@ func getRoute53HostedZone(ctx *pulumi.Context, targetDomain string) (string, err
 		})
 	}
 	lookupResult, err := zoneLookupFunc(targetDomain)
-	handleErr(err)
-	return lookupResult.Id, nil
-}
-
-func getDomainAndSubdomain(domain string) (string, string) {
-	// returns domain and subdomain
-	// example: www.example.com -> example.com, www
-	// example: example.com -> example.com, example.com
-	split := strings.Split(domain, ".")
-	if len(split) > 2 {
-		return strings.Join(split[1:], "."), split[0]
-	} else {
-		return domain, domain
-	}
-}
-
-func getDomainWithSubdomains(domain string) ([]string, error) {
-	// Get default subdomains for the given domain
-	// domain must be in the format 'example.com'
-	if len(domain) == 0 {
-		return []string{}, nil
-	} else if len(strings.Split(domain, ".")) > 2 {
-		log.Fatalf("Invalid domain format: %s, must be in format 'example.com'\n", domain)
-		return []string{}, errors.New("Invalid domain format")
-	} else {
-		return []string{
-			domain,
-			"www." + domain,
-		}, nil
+	if err != nil {
+		return "", resourceErr(fmt.Sprintf("hosted-zone-%s", targetDomain), err)
 	}
+	return lookupResult.Id, nil
 }
 
 func stringArrayToPulumiStringArray(arr []string) pulumi.StringArray {
@@ -109,14 +129,15 @@ func stringArrayToPulumiStringArray(arr []string) pulumi.StringArray {
 	return pulumiArr
 }
 
-func createAliasRecord(ctx *pulumi.Context, distribution *cloudfront.Distribution, domain string) {
+func createAliasRecord(ctx *pulumi.Context, distribution *cloudfront.Distribution, domain siteDomain) error {
 	// Creates alias records for the given domain and distribution
-	parentDomain, subDomain := getDomainAndSubdomain(domain)
-	log.Printf("Creating alias for domain %s\n", domain)
-	hzid, err := getRoute53HostedZone(ctx, parentDomain)
-	handleErr(err)
-	record, err := route53.NewRecord(ctx, domain, &route53.RecordArgs{
-		Name:   pulumi.String(subDomain),
+	log.Printf("Creating alias for domain %s in zone %s\n", domain.host, domain.zone)
+	hzid, err := getRoute53HostedZone(ctx, domain.zone)
+	if err != nil {
+		return err
+	}
+	record, err := route53.NewRecord(ctx, domain.host, &route53.RecordArgs{
+		Name:   pulumi.String(domain.recordName()),
 		ZoneId: pulumi.String(hzid),
 		Type:   pulumi.String("A"),
 		Aliases: route53.RecordAliasArray{
@@ -127,48 +148,68 @@ func createAliasRecord(ctx *pulumi.Context, distribution *cloudfront.Distributio
 			},
 		},
 	})
-	handleErr(err)
-	log.Printf("Created alias record %v for domain %s", record.Name, domain)
+	if err != nil {
+		return resourceErr(domain.host, err)
+	}
+	log.Printf("Created alias record %v for domain %s", record.Name, domain.host)
+	return nil
 }
 
-func createAliasRecords(ctx *pulumi.Context, distribution *cloudfront.Distribution, domains []string) {
+func createAliasRecords(ctx *pulumi.Context, distribution *cloudfront.Distribution, domains []siteDomain) error {
 	// Creates alias records for the given domains and distribution
+	var errs []error
 	for _, domain := range domains {
-		createAliasRecord(ctx, distribution, domain)
+		errs = append(errs, createAliasRecord(ctx, distribution, domain))
 	}
+	return errors.Join(errs...)
 }
 
-func createValidationRecords(ctx *pulumi.Context, domains []string, certificate *acm.Certificate, hostedZoneId string) []*route53.Record {
-	log.Println("Creating validation records for domains ", domains)
+func createValidationRecords(ctx *pulumi.Context, domains []siteDomain, certificate *acm.Certificate) ([]*route53.Record, error) {
+	log.Println("Creating validation records for domains ", domainHosts(domains))
 
 	records := make([]*route53.Record, len(domains))
 
 	for i, domain := range domains {
-		currentIndex := i
-		certValidationDomain, err := route53.NewRecord(ctx, fmt.Sprintf("validation-record-%s", domain), &route53.RecordArgs{
-			Name: certificate.DomainValidationOptions.ApplyT(func(options []acm.CertificateDomainValidationOption) string {
-				resourceRecordName := options[currentIndex].ResourceRecordName
-				log.Printf("Domain %s, DNS resource record name: %v", domain, resourceRecordName)
+		hostedZoneId, err := getRoute53HostedZone(ctx, domain.zone)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("DNS Hosted zone of %s: %s", domain.host, hostedZoneId)
+
+		// Validation options are matched by domain name, ACM does not keep the order of alternative names
+		validationOption := certificate.DomainValidationOptions.ApplyT(func(options []acm.CertificateDomainValidationOption) (acm.CertificateDomainValidationOption, error) {
+			for _, option := range options {
+				if option.DomainName != nil && *option.DomainName == domain.host {
+					return option, nil
+				}
+			}
+			return acm.CertificateDomainValidationOption{}, fmt.Errorf("no validation option for domain %s", domain.host)
+		}).(acm.CertificateDomainValidationOptionOutput)
+
+		recordName := fmt.Sprintf("validation-record-%s", domain.host)
+		certValidationDomain, err := route53.NewRecord(ctx, recordName, &route53.RecordArgs{
+			Name: validationOption.ResourceRecordName().ApplyT(func(resourceRecordName *string) string {
+				log.Printf("Domain %s, DNS resource record name: %v", domain.host, resourceRecordName)
 				return *resourceRecordName
 			}).(pulumi.StringOutput),
-			Type: certificate.DomainValidationOptions.ApplyT(func(options []acm.CertificateDomainValidationOption) string {
-				resourceRecordType := options[currentIndex].ResourceRecordType
-				log.Printf("Domain %s, DNS resource record type: %v", domain, resourceRecordType)
+			Type: validationOption.ResourceRecordType().ApplyT(func(resourceRecordType *string) string {
+				log.Printf("Domain %s, DNS resource record type: %v", domain.host, resourceRecordType)
 				return *resourceRecordType
 			}).(pulumi.StringOutput),
 			Records: pulumi.StringArray{
-				certificate.DomainValidationOptions.ApplyT(func(options []acm.CertificateDomainValidationOption) string {
-					recordValue := options[currentIndex].ResourceRecordValue
-					log.Printf("Domain %s, DNS record value: %v", domain, recordValue)
+				validationOption.ResourceRecordValue().ApplyT(func(recordValue *string) string {
+					log.Printf("Domain %s, DNS record value: %v", domain.host, recordValue)
 					return *recordValue
 				}).(pulumi.StringOutput)},
 			ZoneId: pulumi.String(hostedZoneId),
 			Ttl:    pulumi.Int(60),
 		})
-		handleErr(err)
-		records[currentIndex] = certValidationDomain
+		if err != nil {
+			return nil, resourceErr(recordName, err)
+		}
+		records[i] = certValidationDomain
 	}
-	return records
+	return records, nil
 }
 
 func mapValidationRecordsFqdn(validationRecords []*route53.Record) pulumi.StringArray {
@@ -180,61 +221,110 @@ func mapValidationRecordsFqdn(validationRecords []*route53.Record) pulumi.String
 	return fqdnArray
 }
 
-func createBucket(ctx *pulumi.Context, bucketName string) *s3.Bucket {
-	bucket, err := s3.NewBucket(ctx, fmt.Sprintf("%s-s3-bucket", bucketName), &s3.BucketArgs{
+func createBucket(ctx *pulumi.Context, bucketName string) (*s3.Bucket, error) {
+	// Creates a new private S3 bucket
+	resourceName := fmt.Sprintf("%s-s3-bucket", bucketName)
+	bucket, err := s3.NewBucket(ctx, resourceName, &s3.BucketArgs{
 		Acl:    pulumi.String("private"),
 		Bucket: pulumi.String(bucketName),
 	})
-	handleErr(err)
+	if err != nil {
+		return nil, resourceErr(resourceName, err)
+	}
 
-	_, er := s3.NewBucketOwnershipControls(ctx, fmt.Sprintf("%s-ownership-controls", bucketName), &s3.BucketOwnershipControlsArgs{
+	ownershipName := fmt.Sprintf("%s-ownership-controls", bucketName)
+	_, err = s3.NewBucketOwnershipControls(ctx, ownershipName, &s3.BucketOwnershipControlsArgs{
 		Bucket: bucket.ID(),
 		Rule: &s3.BucketOwnershipControlsRuleArgs{
 			ObjectOwnership: pulumi.String("BucketOwnerPreferred"),
 		},
 	})
-	handleErr(er) // Creates a new S3 bucket
-	return bucket
+	if err != nil {
+		return nil, resourceErr(ownershipName, err)
+	}
+	return bucket, nil
 }
 
-func createContentBucket(ctx *pulumi.Context, project staticSiteProject, blockPublicAccess bool) *s3.Bucket {
+func createContentBucket(ctx *pulumi.Context, project staticSiteProject, blockPublicAccess bool) (*s3.Bucket, *siteContent, error) {
+	// Creates the content bucket and uploads the site into it
 	log.Println("Creating content S3 bucket. Index document: ", project.indexDoc)
 
-	bucketName := fmt.Sprintf("%s-bucket", project.name)
-	bucket, err := s3.NewBucket(ctx, bucketName, &s3.BucketArgs{
-		Website: s3.BucketWebsiteArgs{
+	// Private buckets are read by Cloudfront through the REST endpoint, so they
+	// need neither website hosting nor object ACLs
+	bucketArgs := &s3.BucketArgs{}
+	objectOwnership, objectAcl := "BucketOwnerEnforced", ""
+	if !blockPublicAccess {
+		bucketArgs.Website = s3.BucketWebsiteArgs{
 			IndexDocument: pulumi.String(project.indexDoc),
 			ErrorDocument: pulumi.String(project.errorDoc),
-		},
-	})
-	handleErr(err)
-	_, err = s3.NewBucketOwnershipControls(ctx, fmt.Sprintf("%s-ownership-controls", project.name), &s3.BucketOwnershipControlsArgs{
+		}
+		objectOwnership, objectAcl = "ObjectWriter", "public-read"
+	}
+
+	bucketName := fmt.Sprintf("%s-bucket", project.name)
+	bucket, err := s3.NewBucket(ctx, bucketName, bucketArgs)
+	if err != nil {
+		return nil, nil, resourceErr(bucketName, err)
+	}
+	ownershipName := fmt.Sprintf("%s-ownership-controls", project.name)
+	ownershipControls, err := s3.NewBucketOwnershipControls(ctx, ownershipName, &s3.BucketOwnershipControlsArgs{
 		Bucket: bucket.ID(),
 		Rule: &s3.BucketOwnershipControlsRuleArgs{
-			ObjectOwnership: pulumi.String("ObjectWriter"),
+			ObjectOwnership: pulumi.String(objectOwnership),
 		},
 	})
-	handleErr(err)
+	if err != nil {
+		return nil, nil, resourceErr(ownershipName, err)
+	}
 
 	// set public access to our bucket
-	publicAccessBlock, err := s3.NewBucketPublicAccessBlock(ctx, fmt.Sprintf("%s-public-access-block", project.name), &s3.BucketPublicAccessBlockArgs{
+	accessBlockName := fmt.Sprintf("%s-public-access-block", project.name)
+	accessBlockArgs := &s3.BucketPublicAccessBlockArgs{
 		Bucket:          bucket.ID(),
 		BlockPublicAcls: pulumi.Bool(blockPublicAccess),
-	})
-	handleErr(err)
+	}
+	if blockPublicAccess {
+		accessBlockArgs.BlockPublicPolicy = pulumi.Bool(true)
+		accessBlockArgs.IgnorePublicAcls = pulumi.Bool(true)
+		accessBlockArgs.RestrictPublicBuckets = pulumi.Bool(true)
+	}
+	publicAccessBlock, err := s3.NewBucketPublicAccessBlock(ctx, accessBlockName, accessBlockArgs,
+		pulumi.DependsOn([]pulumi.Resource{ownershipControls}))
+	if err != nil {
+		return nil, nil, resourceErr(accessBlockName, err)
+	}
 
 	// create S3 buckets with web content
-	_, err = filesToBucketObjects(ctx, publicAccessBlock, bucket, project.dir, project.bucketPath)
-	handleErr(err)
+	settings := objectSettings{acl: objectAcl, cacheControl: project.cacheControl, contentTypes: project.contentTypes}
+	if err := warnUndeterminedContentTypes(ctx, project); err != nil {
+		return nil, nil, err
+	}
+	if project.precompress {
+		settings.precompressDir = precompressedDir(project.name)
+	}
+	var objects []*s3.BucketObject
+	if project.contentSync != contentSyncManifest {
+		objects, err = filesToBucketObjects(ctx, publicAccessBlock, bucket, settings, project.dir, project.bucketPath)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	content, err := newSiteContent(ctx, project, bucket, publicAccessBlock, settings, objects)
+	if err != nil {
+		return nil, nil, err
+	}
 
 	// Set the CORS configuration for the bucket
-	setBucketCors(ctx, bucket, project.cors, project.name)
-	return bucket
+	if err := setBucketCors(ctx, bucket, project.cors, project.name); err != nil {
+		return nil, nil, err
+	}
+	return bucket, content, nil
 }
 
-func setBucketCors(ctx *pulumi.Context, bucket *s3.Bucket, cors string, projectName string) {
+func setBucketCors(ctx *pulumi.Context, bucket *s3.Bucket, cors string, projectName string) error {
 	if cors != "" {
-		_, err := s3.NewBucketCorsConfigurationV2(ctx, fmt.Sprintf("%s-cors-setting", projectName), &s3.BucketCorsConfigurationV2Args{
+		corsName := fmt.Sprintf("%s-cors-setting", projectName)
+		_, err := s3.NewBucketCorsConfigurationV2(ctx, corsName, &s3.BucketCorsConfigurationV2Args{
 			Bucket: bucket.ID(),
 			CorsRules: s3.BucketCorsConfigurationV2CorsRuleArray{
 				&s3.BucketCorsConfigurationV2CorsRuleArgs{
@@ -245,6 +335,9 @@ func setBucketCors(ctx *pulumi.Context, bucket *s3.Bucket, cors string, projectN
 				},
 			},
 		})
-		handleErr(err)
+		if err != nil {
+			return resourceErr(corsName, err)
+		}
 	}
+	return nil
 }
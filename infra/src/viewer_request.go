@@ -0,0 +1,120 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"text/template"
+
+	// The v5 provider does not know the cloudfront-js-2.0 runtime
+	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws/cloudfront"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+const (
+	// Canonical host of the site, the primary domain when not set
+	canonicalHostWww  = "www"
+	canonicalHostApex = "apex"
+	// Trailing slash normalization of the paths, none when not set
+	trailingSlashAdd    = "add"
+	trailingSlashRemove = "remove"
+
+	viewerRequestRuntime = "cloudfront-js-2.0"
+	// Largest function code CloudFront accepts
+	maxViewerRequestSize = 10 * 1024
+)
+
+//go:embed edge_functions/viewer_request.js
+var viewerRequestSource string
+
+var viewerRequestTemplate = template.Must(template.New("viewer_request.js").Parse(viewerRequestSource))
+
+// viewerRequestConfig are the settings of the viewer request function of a site
+type viewerRequestConfig struct {
+	Host          string                  `json:"host"`
+	TrailingSlash string                  `json:"trailingSlash"`
+	Redirects     map[string]edgeRedirect `json:"redirects"`
+	Prefixes      []edgePrefixRedirect    `json:"prefixes"`
+	// Document requests of directories are rewritten to, the S3 REST
+	// endpoint of a private origin does not resolve index documents
+	IndexDoc string `json:"indexDoc,omitempty"`
+}
+
+func siteViewerRequestConfig(project staticSiteProject, host string, redirects redirectRules) viewerRequestConfig {
+	// Settings of the viewer request function of the site, the redirects must
+	// have been checked by checkRedirects
+	exact, prefixes := compileRedirects(redirects)
+	settings := viewerRequestConfig{Host: host, TrailingSlash: project.trailingSlash, Redirects: exact, Prefixes: prefixes}
+	if project.privateOrigin {
+		settings.IndexDoc = project.indexDoc
+	}
+	return settings
+}
+
+func canonicalHost(project staticSiteProject, domains []siteDomain) (string, error) {
+	// Host all the other hosts of the site redirect to, it must be served by the distribution
+	host := domains[0].host
+	switch project.canonicalHost {
+	case canonicalHostWww:
+		host = "www." + strings.TrimPrefix(host, "www.")
+	case canonicalHostApex:
+		host = strings.TrimPrefix(host, "www.")
+	}
+	for _, served := range domainHosts(domains) {
+		if served == host {
+			return host, nil
+		}
+	}
+	return "", fmt.Errorf("canonical host %s is not a domain of the site", host)
+}
+
+func viewerRequestCode(settings viewerRequestConfig) (string, error) {
+	if settings.Redirects == nil {
+		settings.Redirects = map[string]edgeRedirect{}
+	}
+	if settings.Prefixes == nil {
+		settings.Prefixes = []edgePrefixRedirect{}
+	}
+	encoded, err := json.Marshal(settings)
+	if err != nil {
+		return "", err
+	}
+	var code strings.Builder
+	if err := viewerRequestTemplate.Execute(&code, string(encoded)); err != nil {
+		return "", err
+	}
+	if code.Len() > maxViewerRequestSize {
+		return "", fmt.Errorf("code has %d bytes, CloudFront accepts at most %d, move redirects to prefixes", code.Len(), maxViewerRequestSize)
+	}
+	return code.String(), nil
+}
+
+func viewerRequestFunction(ctx *pulumi.Context, project staticSiteProject, domains []siteDomain, redirects redirectRules) (*cloudfront.Function, error) {
+	// CloudFront Function redirecting the viewers of the site, it runs at the
+	// edge location itself so unlike Lambda@Edge it needs no role or region.
+	// The redirects must have been checked by checkRedirects
+	host, err := canonicalHost(project, domains)
+	if err != nil {
+		return nil, err
+	}
+	code, err := viewerRequestCode(siteViewerRequestConfig(project, host, redirects))
+	if err != nil {
+		return nil, fmt.Errorf("generating viewer request function: %w", err)
+	}
+
+	functionName := fmt.Sprintf("%s-viewer-request", project.name)
+	log.Printf("Creating CloudFront function %s redirecting to %s\n", functionName, host)
+	function, err := cloudfront.NewFunction(ctx, functionName, &cloudfront.FunctionArgs{
+		Name:    pulumi.String(functionName),
+		Comment: pulumi.String(fmt.Sprintf("Redirects the viewers of %s to https://%s", project.name, host)),
+		Runtime: pulumi.String(viewerRequestRuntime),
+		Code:    pulumi.String(code),
+		Publish: pulumi.Bool(true),
+	})
+	if err != nil {
+		return nil, resourceErr(functionName, err)
+	}
+	return function, nil
+}
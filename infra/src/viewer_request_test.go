@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// viewerRequestFixture is a viewer request event of a site with the expected
+// redirect, or the uri of the request passed on to the cache
+type viewerRequestFixture struct {
+	Site   siteConfig      `json:"site"`
+	Event  json.RawMessage `json:"event"`
+	Expect struct {
+		StatusCode int    `json:"statusCode"`
+		Location   string `json:"location"`
+		Uri        string `json:"uri"`
+	} `json:"expect"`
+}
+
+func TestViewerRequestFixtures(t *testing.T) {
+	// Runs the generated function code in node against the fixture events
+	// of testdata/viewer_request, the way CloudFront runs it at the edge
+	node, err := exec.LookPath("node")
+	if err != nil {
+		t.Skip("node is needed to run the viewer request function")
+	}
+	fixtures, err := filepath.Glob(filepath.Join("testdata", "viewer_request", "*.json"))
+	if err != nil || len(fixtures) == 0 {
+		t.Fatalf("no viewer request fixtures: %v", err)
+	}
+	for _, fixtureFile := range fixtures {
+		t.Run(strings.TrimSuffix(filepath.Base(fixtureFile), ".json"), func(t *testing.T) {
+			content, err := os.ReadFile(fixtureFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var fixture viewerRequestFixture
+			if err := json.Unmarshal(content, &fixture); err != nil {
+				t.Fatal(err)
+			}
+			project := fixture.Site.project()
+			domains, err := getSiteDomains(project)
+			if err != nil {
+				t.Fatal(err)
+			}
+			host, err := canonicalHost(project, domains)
+			if err != nil {
+				t.Fatal(err)
+			}
+			redirects, err := siteRedirects(project)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := checkRedirects(redirects, domainHosts(domains), project.trailingSlash); err != nil {
+				t.Fatal(err)
+			}
+			code, err := viewerRequestCode(siteViewerRequestConfig(project, host, redirects))
+			if err != nil {
+				t.Fatal(err)
+			}
+			codeFile := filepath.Join(t.TempDir(), "viewer_request.js")
+			if err := os.WriteFile(codeFile, []byte(code), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			cmd := exec.Command(node, filepath.Join("testdata", "viewer_request", "run.mjs"), codeFile)
+			cmd.Stdin = bytes.NewReader(fixture.Event)
+			var stderr bytes.Buffer
+			cmd.Stderr = &stderr
+			output, err := cmd.Output()
+			if err != nil {
+				t.Fatalf("running the function: %v\n%s", err, stderr.String())
+			}
+			var result struct {
+				StatusCode int    `json:"statusCode"`
+				Uri        string `json:"uri"`
+				Headers    map[string]struct {
+					Value string `json:"value"`
+				} `json:"headers"`
+			}
+			if err := json.Unmarshal(output, &result); err != nil {
+				t.Fatalf("invalid function result %s: %v", output, err)
+			}
+
+			if fixture.Expect.StatusCode != 0 {
+				if result.StatusCode != fixture.Expect.StatusCode || result.Headers["location"].Value != fixture.Expect.Location {
+					t.Errorf("response = %s, want %d to %s", output, fixture.Expect.StatusCode, fixture.Expect.Location)
+				}
+				return
+			}
+			if result.StatusCode != 0 || result.Uri != fixture.Expect.Uri {
+				t.Errorf("result = %s, want the request of %s", output, fixture.Expect.Uri)
+			}
+		})
+	}
+}
+
+func TestCanonicalHost(t *testing.T) {
+	tests := []struct {
+		domain    string
+		aliases   []string
+		canonical string
+		want      string
+	}{
+		{"example.com", []string{"www.example.com"}, "", "example.com"},
+		{"example.com", []string{"www.example.com"}, canonicalHostWww, "www.example.com"},
+		{"www.example.com", []string{"example.com"}, canonicalHostApex, "example.com"},
+		{"www.example.com", []string{"example.com"}, canonicalHostWww, "www.example.com"},
+	}
+	for _, test := range tests {
+		project := testProject(test.domain, test.aliases...)
+		project.canonicalHost = test.canonical
+		domains, err := getSiteDomains(project)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if host, err := canonicalHost(project, domains); err != nil || host != test.want {
+			t.Errorf("canonicalHost(%s %v, %q) = %s, %v, want %s", test.domain, test.aliases, test.canonical, host, err, test.want)
+		}
+	}
+
+	project := testProject("example.com")
+	project.canonicalHost = canonicalHostWww
+	domains, err := getSiteDomains(project)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := canonicalHost(project, domains); err == nil || !strings.Contains(err.Error(), "not a domain of the site") {
+		t.Errorf("canonical host outside of the site = %v", err)
+	}
+}
+
+func TestDeployProjectViewerRequestFunction(t *testing.T) {
+	project := testProject("example.com", "www.example.com")
+	project.canonicalHost = canonicalHostWww
+	project.trailingSlash = trailingSlashAdd
+	mocks, err := runWithMocks(t, "test", nil, deployTestProject(project))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	function := mocks.find(t, "aws:cloudfront/function:Function", "test-site-viewer-request")
+	if function.Inputs["runtime"] != viewerRequestRuntime || function.Inputs["publish"] != true {
+		t.Errorf("function inputs = %v", function.Inputs)
+	}
+	if code := function.Inputs["code"].(string); !strings.Contains(code, `var config = {"host":"www.example.com","trailingSlash":"add","redirects":{},"prefixes":[]};`) {
+		t.Errorf("function code has no site settings:\n%s", code)
+	}
+
+	// Every behavior redirects, Lambda@Edge is not associated for the redirects
+	distribution := mocks.find(t, "aws:cloudfront/distribution:Distribution", "example.com-cdn")
+	behaviors := []interface{}{distribution.Inputs["defaultCacheBehavior"]}
+	behaviors = append(behaviors, distribution.Inputs["orderedCacheBehaviors"].([]interface{})...)
+	for i, behavior := range behaviors {
+		associations := behavior.(map[string]interface{})["functionAssociations"].([]interface{})
+		association := associations[0].(map[string]interface{})
+		if len(associations) != 1 || association["eventType"] != "viewer-request" || association["functionArn"] != "arn:aws:mock:::test-site-viewer-request" {
+			t.Errorf("behavior %d function associations = %v", i, associations)
+		}
+	}
+	if associations, ok := behaviors[0].(map[string]interface{})["lambdaFunctionAssociations"]; ok {
+		t.Errorf("lambda associations = %v", associations)
+	}
+}
+
+func TestViewerRequestFunctionRequiresServedHost(t *testing.T) {
+	project := testProject("example.com")
+	project.canonicalHost = canonicalHostWww
+	_, err := runWithMocks(t, "test", nil, func(ctx *pulumi.Context) error {
+		domains, err := getSiteDomains(project)
+		if err != nil {
+			return err
+		}
+		_, err = viewerRequestFunction(ctx, project, domains, nil)
+		return err
+	})
+	if err == nil || !strings.Contains(err.Error(), "www.example.com is not a domain") {
+		t.Errorf("viewerRequestFunction() = %v", err)
+	}
+}
@@ -31,6 +31,7 @@ fi
 cd ./infra/src || error_with_message "Failed to change directory to infra/src"
 go fmt
 go build -o /dev/null
+go test ./... || error_with_message "Infra tests failed, run go test -update to accept intended snapshot changes"
 
 ## Check html
 echo "Checking HTML files"
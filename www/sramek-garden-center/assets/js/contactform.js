@@ -0,0 +1,91 @@
+/**
+ * Contact form, posted to the contact form API of the site
+ */
+
+const formUrl =
+  "https://kqdu8ejpge.execute-api.eu-central-1.amazonaws.com/prod/sramek-garden-center";
+
+// Czech messages of the stable response codes of the contact form API
+const errorMessages = {
+  invalid_encoding: "Formulář se nepodařilo odeslat, zkuste to prosím znovu.",
+  invalid_json: "Formulář se nepodařilo odeslat, zkuste to prosím znovu.",
+  too_fast: "Formulář byl odeslán příliš rychle, zkuste to prosím znovu za chvíli.",
+  rate_limited: "Odeslali jste příliš mnoho zpráv, zkuste to prosím později.",
+  captcha_failed: "Ověření, že nejste robot, se nezdařilo. Zkuste to prosím znovu.",
+  captcha_unavailable: "Ověření, že nejste robot, je nedostupné, zkuste to prosím později.",
+  send_failed: "Zprávu se nepodařilo odeslat, zkuste to prosím později.",
+};
+const fieldNames = {
+  name: "Jméno",
+  phone: "Tel. číslo",
+  email: "Email",
+  message: "Zpráva",
+};
+const fieldMessages = {
+  required: "je povinné pole",
+  too_long: "je příliš dlouhé",
+  invalid_email: "není platná emailová adresa",
+  invalid_phone: "není platné telefonní číslo",
+};
+
+function errorMessage(response) {
+  if (response.code == "invalid_fields" && response.fields) {
+    return response.fields
+      .map(
+        (field) =>
+          `${fieldNames[field.field] || field.field} ${fieldMessages[field.code] || "není vyplněno správně"}`
+      )
+      .join(", ");
+  }
+  return errorMessages[response.code] || errorMessages.send_failed;
+}
+
+function showStatus(form, message, error) {
+  const status = form.querySelector(".contact-form-status");
+  status.textContent = message;
+  status.classList.toggle("error", error);
+}
+
+async function submit(form) {
+  const data = new FormData(form);
+  const submitButton = form.querySelector('[type="submit"]');
+  submitButton.disabled = true;
+  showStatus(form, "Odesílám…", false);
+  try {
+    const response = await fetch(formUrl, {
+      method: "POST",
+      headers: {
+        Accept: "application/json",
+        "Content-Type": "application/json",
+      },
+      body: JSON.stringify({
+        name: data.get("name"),
+        phone: data.get("phone"),
+        email: data.get("email"),
+        message: data.get("message"),
+        website: data.get("website"),
+        // Milliseconds since the page was loaded, the API rejects forms
+        // filled faster than a person could
+        elapsed: Math.round(performance.now()),
+      }),
+    });
+    const result = await response.json().catch(() => ({}));
+    if (result.code == "sent" || result.code == "received") {
+      showStatus(form, "Vaše zpráva byla odeslána. Děkujeme!", false);
+      form.reset();
+    } else {
+      showStatus(form, errorMessage(result), true);
+    }
+  } catch (error) {
+    showStatus(form, errorMessages.send_failed, true);
+  } finally {
+    submitButton.disabled = false;
+  }
+}
+
+document.querySelectorAll(".contact-form").forEach((form) => {
+  form.addEventListener("submit", (event) => {
+    event.preventDefault();
+    submit(form);
+  });
+});
@@ -44,8 +44,40 @@
     });
   })
 
+  // Czech messages of the stable response codes of the contact form API
+  const errorMessages = {
+    invalid_encoding: 'Formulář se nepodařilo odeslat, zkuste to prosím znovu.',
+    invalid_json: 'Formulář se nepodařilo odeslat, zkuste to prosím znovu.',
+    too_fast: 'Formulář byl odeslán příliš rychle, zkuste to prosím znovu za chvíli.',
+    rate_limited: 'Odeslali jste příliš mnoho zpráv, zkuste to prosím později.',
+    captcha_failed: 'Ověření, že nejste robot, se nezdařilo. Zkuste to prosím znovu.',
+    captcha_unavailable: 'Ověření, že nejste robot, je nedostupné, zkuste to prosím později.',
+    send_failed: 'Zprávu se nepodařilo odeslat, zkuste to prosím později.'
+  };
+  const fieldNames = {
+    name: 'Jméno',
+    phone: 'Tel. číslo',
+    email: 'Email',
+    message: 'Zpráva'
+  };
+  const fieldMessages = {
+    required: 'je povinné pole',
+    too_long: 'je příliš dlouhé',
+    invalid_email: 'není platná emailová adresa',
+    invalid_phone: 'není platné telefonní číslo'
+  };
+
+  function errorMessage(parsedData) {
+    if (parsedData.code == 'invalid_fields' && parsedData.fields) {
+      return parsedData.fields.map(field =>
+        `${fieldNames[field.field] || field.field} ${fieldMessages[field.code] || 'není vyplněno správně'}`
+      ).join('<br>');
+    }
+    return errorMessages[parsedData.code] || 'Zprávu se nepodařilo odeslat, zkuste to prosím později.';
+  }
+
   function form_submit(thisForm, formData) {
-    fetch("https://kqdu8ejpge.execute-api.eu-central-1.amazonaws.com/prod/", {
+    fetch("https://kqdu8ejpge.execute-api.eu-central-1.amazonaws.com/prod/sramek-transportation", {
       method: 'POST',
       headers: {
         Accept: "application/json",
@@ -53,29 +85,29 @@
       },
       body: JSON.stringify({
         name: formData.get('name'),
+        phone: formData.get('phone'),
         email: formData.get('email'),
-        message: formData.get('message')
+        message: formData.get('message'),
+        website: formData.get('website'),
+        // Milliseconds since the page was loaded, the API rejects forms filled
+        // faster than a person could. Unlike Date.now() it does not depend on
+        // the clock of the device
+        elapsed: Math.round(performance.now()),
+        captcha: formData.get('recaptcha-response')
       })
     })
-    .then(response => {
-      if( response.ok ) {
-        return response.text();
-      } else {
-        throw new Error(`${response.status} ${response.statusText} ${response.url}`); 
-      }
-    })
-    .then(data => {
+    .then(response => response.json().catch(() => ({})))
+    .then(parsedData => {
       thisForm.querySelector('.loading').classList.remove('d-block');
-      const parsedData = JSON.parse(data);
-      if (parsedData.message == 'Email sent successfully') {
+      if (parsedData.code == 'sent' || parsedData.code == 'received') {
         thisForm.querySelector('.sent-message').classList.add('d-block');
         thisForm.reset(); 
       } else {
-        throw new Error(data ? data : 'Form submission failed and no error message returned from: ' + action); 
+        throw new Error(errorMessage(parsedData)); 
       }
     })
     .catch((error) => {
-      displayError(thisForm, error);
+      displayError(thisForm, error.message || error);
     });
   }
 